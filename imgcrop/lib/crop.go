@@ -0,0 +1,164 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package lib
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// subImager is implemented by all image types in the
+// standard library which can share pixels with a sub-image.
+type subImager interface {
+	SubImage(image.Rectangle) image.Image
+}
+
+// Crop returns the part of img which lies within rect.
+// Where possible, the result shares its pixels with img.
+// Otherwise, the pixels are copied into a new image.
+func Crop(img image.Image, rect image.Rectangle) image.Image {
+	rect = rect.Intersect(img.Bounds())
+
+	if si, ok := img.(subImager); ok {
+		return si.SubImage(rect)
+	}
+
+	dst := image.NewRGBA64(rect)
+	draw.Draw(dst, rect, img, rect.Min, draw.Src)
+	return dst
+}
+
+// AspectRect returns the largest rectangle with an aspect ratio of w:h,
+// which fits inside the bounds of img. It is positioned according to
+// the gravity.
+func AspectRect(img image.Image, w, h int, g Gravity) image.Rectangle {
+	bounds := img.Bounds()
+	if w <= 0 || h <= 0 {
+		return bounds
+	}
+
+	size := image.Pt(bounds.Dx(), bounds.Dx()*h/w)
+	if size.Y > bounds.Dy() {
+		size = image.Pt(bounds.Dy()*w/h, bounds.Dy())
+	}
+
+	return Place(img, size, g)
+}
+
+// PercentRect returns a rectangle which covers the given percentages
+// of the width and height of img. It is positioned according to the
+// gravity.
+func PercentRect(img image.Image, px, py float64, g Gravity) image.Rectangle {
+	bounds := img.Bounds()
+	size := image.Pt(
+		int(float64(bounds.Dx())*px/100+0.5),
+		int(float64(bounds.Dy())*py/100+0.5),
+	)
+
+	return Place(img, size, g).Intersect(bounds)
+}
+
+// TrimRect returns the smallest rectangle which holds all pixels that
+// differ from the reference color by more than the given tolerance.
+// The tolerance is the largest allowed difference of any channel,
+// in the range 0-255. Colors are compared with premultiplied alpha,
+// so any fully transparent pixel matches a transparent reference.
+//
+// It returns an empty rectangle if all pixels match.
+func TrimRect(img image.Image, ref color.Color, tolerance int) image.Rectangle {
+	b := img.Bounds()
+	r0, g0, b0, a0 := ref.RGBA()
+	tol := uint32(tolerance) * 0x101
+
+	differs := func(x, y int) bool {
+		r, g, b, a := img.At(x, y).RGBA()
+		return diff(r, r0) > tol || diff(g, g0) > tol ||
+			diff(b, b0) > tol || diff(a, a0) > tol
+	}
+
+	rowDiffers := func(y int) bool {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if differs(x, y) {
+				return true
+			}
+		}
+		return false
+	}
+
+	colDiffers := func(x, minY, maxY int) bool {
+		for y := minY; y < maxY; y++ {
+			if differs(x, y) {
+				return true
+			}
+		}
+		return false
+	}
+
+	minY := b.Min.Y
+	for minY < b.Max.Y && !rowDiffers(minY) {
+		minY++
+	}
+
+	if minY == b.Max.Y {
+		return image.Rectangle{}
+	}
+
+	maxY := b.Max.Y
+	for maxY > minY && !rowDiffers(maxY-1) {
+		maxY--
+	}
+
+	minX := b.Min.X
+	for minX < b.Max.X && !colDiffers(minX, minY, maxY) {
+		minX++
+	}
+
+	maxX := b.Max.X
+	for maxX > minX && !colDiffers(maxX-1, minY, maxY) {
+		maxX--
+	}
+
+	return image.Rect(minX, minY, maxX, maxY)
+}
+
+// Trim removes the borders of img which match the color of its top-left
+// pixel, within the given tolerance. See TrimRect for details.
+func Trim(img image.Image, tolerance int) image.Image {
+	b := img.Bounds()
+	if b.Empty() {
+		return img
+	}
+
+	rect := TrimRect(img, img.At(b.Min.X, b.Min.Y), tolerance)
+	return Crop(img, rect)
+}
+
+// Pad places img on a canvas of the given size, filled with color c.
+// The image is positioned according to the gravity. The canvas is never
+// smaller than the image itself. The returned image has its origin at (0, 0).
+func Pad(img image.Image, width, height int, c color.Color, g Gravity) image.Image {
+	b := img.Bounds()
+
+	if width < b.Dx() {
+		width = b.Dx()
+	}
+
+	if height < b.Dy() {
+		height = b.Dy()
+	}
+
+	canvas := image.Rect(0, 0, width, height)
+	dst := image.NewRGBA(canvas)
+	draw.Draw(dst, canvas, image.NewUniform(c), image.Point{}, draw.Src)
+	draw.Draw(dst, Anchor(canvas, b.Size(), g), img, b.Min, draw.Over)
+	return dst
+}
+
+func diff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func Test_Anchor(t *testing.T) {
+	outer := image.Rect(10, 10, 110, 60)
+	size := image.Pt(20, 10)
+
+	tests := map[Gravity]image.Rectangle{
+		Center:    image.Rect(50, 30, 70, 40),
+		NorthWest: image.Rect(10, 10, 30, 20),
+		SouthEast: image.Rect(90, 50, 110, 60),
+		East:      image.Rect(90, 30, 110, 40),
+	}
+
+	for g, want := range tests {
+		if r := Anchor(outer, size, g); r != want {
+			t.Errorf("%v: got %v; want %v", g, r, want)
+		}
+	}
+}
+
+func Test_AspectRect(t *testing.T) {
+	b := image.NewGray(image.Rect(0, 0, 400, 300))
+
+	if r := AspectRect(b, 1, 1, West); r != image.Rect(0, 0, 300, 300) {
+		t.Errorf("1:1: got %v", r)
+	}
+
+	if r := AspectRect(b, 16, 9, South); r != image.Rect(0, 75, 400, 300) {
+		t.Errorf("16:9: got %v", r)
+	}
+}
+
+func Test_Trim(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	m.Set(3, 4, color.White)
+	m.Set(6, 5, color.White)
+
+	// Transparent pixels with differing color values.
+	m.Set(0, 9, color.NRGBA{255, 0, 0, 0})
+
+	out := Trim(m, 0)
+	if out.Bounds() != image.Rect(3, 4, 7, 6) {
+		t.Errorf("got %v", out.Bounds())
+	}
+
+	if _, ok := out.(*image.NRGBA); !ok {
+		t.Error("Trim did not use SubImage")
+	}
+}
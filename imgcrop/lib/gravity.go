@@ -0,0 +1,79 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package lib
+
+import (
+	"fmt"
+	"image"
+	"strings"
+)
+
+// Gravity defines where a rectangle is anchored within another.
+type Gravity uint8
+
+// Known gravity values.
+const (
+	Center Gravity = iota
+	North
+	NorthEast
+	East
+	SouthEast
+	South
+	SouthWest
+	West
+	NorthWest
+	Smart // Select the most interesting area; see SmartRect.
+)
+
+var gravityNames = [...]string{
+	"center", "north", "northeast", "east", "southeast",
+	"south", "southwest", "west", "northwest", "smart",
+}
+
+func (g Gravity) String() string {
+	if int(g) < len(gravityNames) {
+		return gravityNames[g]
+	}
+	return fmt.Sprintf("Gravity(%d)", g)
+}
+
+// ParseGravity returns the gravity with the given name.
+// Names are matched case-insensitively.
+func ParseGravity(name string) (Gravity, error) {
+	for i, v := range gravityNames {
+		if strings.EqualFold(name, v) {
+			return Gravity(i), nil
+		}
+	}
+	return Center, fmt.Errorf("Unknown gravity: %s", name)
+}
+
+// Anchor returns a rectangle of the given size, positioned
+// inside outer according to the gravity. The size may exceed
+// the size of outer, in which case the result extends beyond it.
+// The Smart gravity requires image contents, so Anchor treats it
+// as Center. Use Place instead.
+func Anchor(outer image.Rectangle, size image.Point, g Gravity) image.Rectangle {
+	dx := outer.Dx() - size.X
+	dy := outer.Dy() - size.Y
+
+	var x, y int
+
+	switch g {
+	case North, Center, South, Smart:
+		x = dx / 2
+	case NorthEast, East, SouthEast:
+		x = dx
+	}
+
+	switch g {
+	case West, Center, East, Smart:
+		y = dy / 2
+	case SouthWest, South, SouthEast:
+		y = dy
+	}
+
+	min := outer.Min.Add(image.Pt(x, y))
+	return image.Rectangle{min, min.Add(size)}
+}
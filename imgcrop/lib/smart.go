@@ -0,0 +1,363 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package lib
+
+import (
+	scale "github.com/jteeuwen/imgtools/imgscale/lib"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Images are analyzed at a reduced size. This is the
+// length of the longest side of the analyzed image.
+const analysisSize = 200
+
+// Weights of the individual scores of a crop window.
+const (
+	edgeWeight       = 1.0
+	entropyWeight    = 0.8
+	skinWeight       = 1.5
+	saturationWeight = 0.6
+	centerWeight     = 0.1
+)
+
+// Place returns a rectangle of the given size inside the bounds of img.
+// It is positioned according to the gravity. The Smart gravity selects
+// the most interesting area of the image; see SmartRect.
+func Place(img image.Image, size image.Point, g Gravity) image.Rectangle {
+	if g == Smart {
+		return SmartRect(img, size)
+	}
+	return Anchor(img.Bounds(), size, g)
+}
+
+// SmartRect returns the rectangle of the given size, which holds the most
+// interesting part of img. Candidate windows are scored by their edge
+// density, the entropy of their luminance, and the amount of skin tones
+// and saturated colors they contain.
+func SmartRect(img image.Image, size image.Point) image.Rectangle {
+	b := img.Bounds()
+
+	if size.X >= b.Dx() && size.Y >= b.Dy() {
+		return b
+	}
+
+	f := analyze(img)
+	best := f.bestWindow(size)
+	return f.toSource(best, size, b)
+}
+
+// Heatmap returns the scores which SmartRect uses to select a crop window,
+// at the size of the analyzed image. The red channel holds skin tones,
+// green holds edges and blue holds saturated colors. If crop is non-empty,
+// it is drawn as a white outline.
+func Heatmap(img image.Image, crop image.Rectangle) image.Image {
+	f := analyze(img)
+	m := image.NewRGBA(image.Rect(0, 0, f.w, f.h))
+
+	for i := range f.edge {
+		m.Pix[i*4+0] = uint8(f.skin[i] * 255)
+		m.Pix[i*4+1] = uint8(f.edge[i] * 255)
+		m.Pix[i*4+2] = uint8(f.sat[i] * 255)
+		m.Pix[i*4+3] = 0xff
+	}
+
+	if crop.Empty() {
+		return m
+	}
+
+	b := img.Bounds()
+	r := image.Rect(
+		int(float64(crop.Min.X-b.Min.X)*f.scale),
+		int(float64(crop.Min.Y-b.Min.Y)*f.scale),
+		int(math.Ceil(float64(crop.Max.X-b.Min.X)*f.scale))-1,
+		int(math.Ceil(float64(crop.Max.Y-b.Min.Y)*f.scale))-1,
+	)
+
+	for x := r.Min.X; x <= r.Max.X; x++ {
+		m.Set(x, r.Min.Y, color.White)
+		m.Set(x, r.Max.Y, color.White)
+	}
+
+	for y := r.Min.Y; y <= r.Max.Y; y++ {
+		m.Set(r.Min.X, y, color.White)
+		m.Set(r.Max.X, y, color.White)
+	}
+
+	return m
+}
+
+// entropyBins is the number of luminance histogram bins
+// used to compute the entropy of a window.
+const entropyBins = 16
+
+// features holds the per-pixel scores of an analyzed image,
+// along with their integral images.
+type features struct {
+	w, h  int
+	scale float64 // Size of the analyzed image relative to the source.
+
+	edge, skin, sat []float32
+	bin             []uint8
+
+	// Integral images for O(1) window sums. These have
+	// dimensions (w+1) x (h+1).
+	iedge, iskin, isat []float64
+	ihist              [entropyBins][]int32
+}
+
+// analyze computes the features of a reduced version of img.
+func analyze(img image.Image) *features {
+	b := img.Bounds()
+	f := new(features)
+	f.scale = 1
+
+	if b.Dx() > analysisSize || b.Dy() > analysisSize {
+		if b.Dx() >= b.Dy() {
+			img = scale.Resize(analysisSize, 0, img, scale.Box)
+		} else {
+			img = scale.Resize(0, analysisSize, img, scale.Box)
+		}
+		f.scale = float64(img.Bounds().Dx()) / float64(b.Dx())
+		b = img.Bounds()
+	}
+
+	f.w, f.h = b.Dx(), b.Dy()
+	n := f.w * f.h
+	lum := make([]float32, n)
+	f.edge = make([]float32, n)
+	f.skin = make([]float32, n)
+	f.sat = make([]float32, n)
+	f.bin = make([]uint8, n)
+
+	for y := 0; y < f.h; y++ {
+		for x := 0; x < f.w; x++ {
+			r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			rf, gf, bf := float32(r)/0xffff, float32(g)/0xffff, float32(bl)/0xffff
+
+			i := y*f.w + x
+			lum[i] = 0.2126*rf + 0.7152*gf + 0.0722*bf
+			f.skin[i] = skinScore(rf, gf, bf, lum[i])
+			f.sat[i] = saturationScore(rf, gf, bf, lum[i])
+			f.bin[i] = uint8(math.Min(float64(lum[i]*entropyBins), entropyBins-1))
+		}
+	}
+
+	// Edges are detected with a Laplacian filter on the luminance.
+	for y := 0; y < f.h; y++ {
+		for x := 0; x < f.w; x++ {
+			i := y*f.w + x
+			c := lum[i]
+			v := 4*c - at(lum, f.w, f.h, x-1, y) - at(lum, f.w, f.h, x+1, y) -
+				at(lum, f.w, f.h, x, y-1) - at(lum, f.w, f.h, x, y+1)
+			f.edge[i] = float32(math.Min(math.Abs(float64(v)), 1))
+		}
+	}
+
+	f.iedge = integral(f.edge, f.w, f.h)
+	f.iskin = integral(f.skin, f.w, f.h)
+	f.isat = integral(f.sat, f.w, f.h)
+
+	for k := range f.ihist {
+		hist := make([]int32, (f.w+1)*(f.h+1))
+
+		for y := 0; y < f.h; y++ {
+			var row int32
+			for x := 0; x < f.w; x++ {
+				if f.bin[y*f.w+x] == uint8(k) {
+					row++
+				}
+				hist[(y+1)*(f.w+1)+x+1] = hist[y*(f.w+1)+x+1] + row
+			}
+		}
+
+		f.ihist[k] = hist
+	}
+
+	return f
+}
+
+// window holds the raw scores of a candidate crop window.
+type window struct {
+	x, y                     int
+	edge, skin, sat, entropy float64
+}
+
+// bestWindow returns the top-left corner of the highest scoring window
+// with the given source size, in analysis coordinates.
+func (f *features) bestWindow(size image.Point) image.Point {
+	ww := clamp(int(float64(size.X)*f.scale+0.5), 1, f.w)
+	wh := clamp(int(float64(size.Y)*f.scale+0.5), 1, f.h)
+	area := float64(ww * wh)
+
+	var list []window
+	var max window
+
+	for y := 0; y+wh <= f.h; y++ {
+		for x := 0; x+ww <= f.w; x++ {
+			w := window{
+				x:       x,
+				y:       y,
+				edge:    f.sum(f.iedge, x, y, ww, wh) / area,
+				skin:    f.sum(f.iskin, x, y, ww, wh) / area,
+				sat:     f.sum(f.isat, x, y, ww, wh) / area,
+				entropy: f.entropy(x, y, ww, wh),
+			}
+
+			max.edge = math.Max(max.edge, w.edge)
+			max.skin = math.Max(max.skin, w.skin)
+			max.sat = math.Max(max.sat, w.sat)
+			max.entropy = math.Max(max.entropy, w.entropy)
+			list = append(list, w)
+		}
+	}
+
+	// Candidates in the center of the image are slightly
+	// preferred over those near the edges.
+	cx := float64(f.w-ww) / 2
+	cy := float64(f.h-wh) / 2
+	maxDist := math.Hypot(cx, cy)
+
+	var best image.Point
+	bestScore := math.Inf(-1)
+
+	for _, w := range list {
+		score := edgeWeight*normalize(w.edge, max.edge) +
+			entropyWeight*normalize(w.entropy, max.entropy) +
+			skinWeight*normalize(w.skin, max.skin) +
+			saturationWeight*normalize(w.sat, max.sat)
+
+		if maxDist > 0 {
+			score -= centerWeight * math.Hypot(float64(w.x)-cx, float64(w.y)-cy) / maxDist
+		}
+
+		if score > bestScore {
+			bestScore = score
+			best = image.Pt(w.x, w.y)
+		}
+	}
+
+	return best
+}
+
+// toSource maps a window corner in analysis coordinates onto a
+// rectangle of the given size in the source bounds.
+func (f *features) toSource(p image.Point, size image.Point, b image.Rectangle) image.Rectangle {
+	size.X = clamp(size.X, 0, b.Dx())
+	size.Y = clamp(size.Y, 0, b.Dy())
+
+	x := clamp(int(float64(p.X)/f.scale+0.5), 0, b.Dx()-size.X)
+	y := clamp(int(float64(p.Y)/f.scale+0.5), 0, b.Dy()-size.Y)
+
+	min := b.Min.Add(image.Pt(x, y))
+	return image.Rectangle{min, min.Add(size)}
+}
+
+// sum returns the sum of the values in the given window
+// of the integral image.
+func (f *features) sum(ii []float64, x, y, w, h int) float64 {
+	s := f.w + 1
+	return ii[(y+h)*s+x+w] - ii[y*s+x+w] - ii[(y+h)*s+x] + ii[y*s+x]
+}
+
+// entropy returns the Shannon entropy of the luminance
+// histogram of the given window, in the range [0, 1].
+func (f *features) entropy(x, y, w, h int) float64 {
+	s := f.w + 1
+	total := float64(w * h)
+
+	var e float64
+	for k := range f.ihist {
+		ii := f.ihist[k]
+		n := ii[(y+h)*s+x+w] - ii[y*s+x+w] - ii[(y+h)*s+x] + ii[y*s+x]
+		if n == 0 {
+			continue
+		}
+
+		p := float64(n) / total
+		e -= p * math.Log2(p)
+	}
+
+	return e / math.Log2(entropyBins)
+}
+
+// skinScore returns how closely the given color resembles
+// a skin tone, in the range [0, 1].
+func skinScore(r, g, b, lum float32) float32 {
+	const threshold = 0.8
+
+	mag := float32(math.Sqrt(float64(r*r + g*g + b*b)))
+	if mag == 0 || lum < 0.2 || lum > 0.95 {
+		return 0
+	}
+
+	dr := r/mag - 0.78
+	dg := g/mag - 0.57
+	db := b/mag - 0.44
+	skin := 1 - float32(math.Sqrt(float64(dr*dr+dg*dg+db*db)))
+
+	if skin < threshold {
+		return 0
+	}
+	return (skin - threshold) / (1 - threshold)
+}
+
+// saturationScore returns the saturation of the given color,
+// in the range [0, 1]. Very dark and very light colors and
+// weakly saturated ones score 0.
+func saturationScore(r, g, b, lum float32) float32 {
+	const threshold = 0.4
+
+	max := float32(math.Max(float64(r), math.Max(float64(g), float64(b))))
+	min := float32(math.Min(float64(r), math.Min(float64(g), float64(b))))
+
+	if max == 0 || lum < 0.05 || lum > 0.9 {
+		return 0
+	}
+
+	sat := (max - min) / max
+	if sat < threshold {
+		return 0
+	}
+	return (sat - threshold) / (1 - threshold)
+}
+
+// integral returns the integral image of the given values.
+func integral(v []float32, w, h int) []float64 {
+	s := w + 1
+	ii := make([]float64, s*(h+1))
+
+	for y := 0; y < h; y++ {
+		var row float64
+		for x := 0; x < w; x++ {
+			row += float64(v[y*w+x])
+			ii[(y+1)*s+x+1] = ii[y*s+x+1] + row
+		}
+	}
+
+	return ii
+}
+
+// at returns the value at (x, y), replicating the edges.
+func at(v []float32, w, h, x, y int) float32 {
+	return v[clamp(y, 0, h-1)*w+clamp(x, 0, w-1)]
+}
+
+func normalize(v, max float64) float64 {
+	if max <= 0 {
+		return 0
+	}
+	return v / max
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,40 @@
+package lib
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func Test_SmartRect(t *testing.T) {
+	// A flat gray image with a detailed, saturated patch near its right edge.
+	img := image.NewRGBA(image.Rect(0, 0, 400, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 400; x++ {
+			img.Set(x, y, color.RGBA{0x80, 0x80, 0x80, 0xff})
+		}
+	}
+
+	for y := 20; y < 80; y++ {
+		for x := 320; x < 380; x++ {
+			if (x/4+y/4)%2 == 0 {
+				img.Set(x, y, color.RGBA{0xff, 0x20, 0x20, 0xff})
+			} else {
+				img.Set(x, y, color.RGBA{0x20, 0x20, 0xff, 0xff})
+			}
+		}
+	}
+
+	r := SmartRect(img, image.Pt(100, 100))
+	if r.Dx() != 100 || r.Dy() != 100 {
+		t.Fatalf("Unexpected size: %v", r)
+	}
+
+	if !image.Rect(320, 20, 380, 80).In(r) {
+		t.Fatalf("Crop %v does not contain the detailed area", r)
+	}
+
+	if r := Place(img, image.Pt(100, 100), Center); r != image.Rect(150, 0, 250, 100) {
+		t.Fatalf("Unexpected centered crop: %v", r)
+	}
+}
@@ -0,0 +1,285 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	croplib "github.com/jteeuwen/imgtools/imgcrop/lib"
+	"github.com/jteeuwen/imgtools/lib"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// config holds the parsed command line arguments.
+type config struct {
+	file      string
+	format    string
+	options   string
+	gravity   croplib.Gravity
+	rect      string
+	aspect    string
+	percent   string
+	trim      string
+	tolerance int
+	pad       string
+	color     color.Color
+}
+
+func main() {
+	cfg := parseArgs()
+	img := load(cfg.file)
+
+	err := lib.With(func() {
+		img = trim(img, cfg)
+		img = crop(img, cfg)
+		img = pad(img, cfg)
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	err = lib.Encode(os.Stdout, cfg.format, img, cfg.options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Encode image: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// trim removes uniform borders from the image, if requested.
+func trim(img image.Image, cfg *config) image.Image {
+	switch strings.ToLower(cfg.trim) {
+	case "":
+		return img
+	case "auto":
+		return croplib.Trim(img, cfg.tolerance)
+	}
+
+	ref, err := lib.ParseColor(cfg.trim)
+	lib.Check(err)
+
+	return croplib.Crop(img, croplib.TrimRect(img, ref, cfg.tolerance))
+}
+
+// crop crops the image by rectangle, aspect ratio or percentage.
+func crop(img image.Image, cfg *config) image.Image {
+	b := img.Bounds()
+
+	switch {
+	case len(cfg.rect) > 0:
+		v := parseInts(cfg.rect, ",", 4)
+		rect := image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3])
+		return croplib.Crop(img, rect.Add(b.Min))
+
+	case len(cfg.aspect) > 0:
+		v := parseInts(cfg.aspect, ":", 2)
+		return croplib.Crop(img, croplib.AspectRect(img, v[0], v[1], cfg.gravity))
+
+	case len(cfg.percent) > 0:
+		list := strings.Split(cfg.percent, ",")
+		if len(list) == 1 {
+			list = append(list, list[0])
+		}
+
+		px, err := strconv.ParseFloat(strings.TrimSpace(list[0]), 64)
+		lib.Check(err)
+		py, err := strconv.ParseFloat(strings.TrimSpace(list[1]), 64)
+		lib.Check(err)
+
+		return croplib.Crop(img, croplib.PercentRect(img, px, py, cfg.gravity))
+	}
+
+	return img
+}
+
+// pad places the image on a larger canvas, if requested.
+func pad(img image.Image, cfg *config) image.Image {
+	if len(cfg.pad) == 0 {
+		return img
+	}
+
+	v := parseInts(cfg.pad, "x", 2)
+	return croplib.Pad(img, v[0], v[1], cfg.color, cfg.gravity)
+}
+
+// parseInts parses a list of exactly n integers, separated by sep.
+// It panics if the value is invalid.
+func parseInts(value, sep string, n int) []int {
+	list := strings.Split(value, sep)
+	if len(list) != n {
+		panic(fmt.Sprintf("Invalid value %q; expected %d values separated by %q", value, n, sep))
+	}
+
+	out := make([]int, n)
+	for i, v := range list {
+		x, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			panic(fmt.Sprintf("Invalid value %q: %v", value, err))
+		}
+		out[i] = x
+	}
+
+	return out
+}
+
+// load loads the given image.
+func load(input string) image.Image {
+	var fd io.ReadCloser
+	var err error
+
+	if len(input) == 0 {
+		fd = os.Stdin
+	} else {
+		fd, err = os.Open(input)
+		defer fd.Close()
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Open input file: %v\n", err)
+		os.Exit(1)
+	}
+
+	img, _, err := lib.Decode(fd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Decode image: %v\n", err)
+		os.Exit(1)
+	}
+
+	return img
+}
+
+// parseArgs parses command line arguments.
+func parseArgs() *config {
+	var err error
+	var cfg config
+
+	target := flag.String("type", "png", "")
+	optstr := flag.String("options", "", "")
+	gravity := flag.String("gravity", "center", "")
+	rect := flag.String("rect", "", "")
+	aspect := flag.String("aspect", "", "")
+	percent := flag.String("percent", "", "")
+	trim := flag.String("trim", "", "")
+	tolerance := flag.Int("tolerance", 0, "")
+	pad := flag.String("pad", "", "")
+	padcolor := flag.String("color", "transparent", "")
+	version := flag.Bool("version", false, "")
+
+	flag.Usage = usage
+	flag.Parse()
+
+	if *version {
+		fmt.Printf("%s\n", Version())
+		os.Exit(0)
+	}
+
+	if !lib.Supported(*target) {
+		fmt.Fprintf(os.Stderr, "Unsupported image format: %s\n", *target)
+		os.Exit(1)
+	}
+
+	cfg.gravity, err = croplib.ParseGravity(*gravity)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	cfg.color, err = lib.ParseColor(*padcolor)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	n := 0
+	for _, v := range []string{*rect, *aspect, *percent} {
+		if len(v) > 0 {
+			n++
+		}
+	}
+
+	if n > 1 {
+		fmt.Fprintf(os.Stderr, "Only one of -rect, -aspect or -percent can be used.\n")
+		os.Exit(1)
+	}
+
+	cfg.format = *target
+	cfg.options = *optstr
+	cfg.rect = *rect
+	cfg.aspect = *aspect
+	cfg.percent = *percent
+	cfg.trim = *trim
+	cfg.tolerance = *tolerance
+	cfg.pad = *pad
+
+	if flag.NArg() > 0 {
+		cfg.file = flag.Args()[0]
+	}
+
+	return &cfg
+}
+
+func usage() {
+	fmt.Printf(`Usage: %s [options] <path>
+   or: cat <path> | %s [options]
+
+ -version
+    Displays version information.
+
+ -rect <x,y,w,h>
+    Crop the image to the given rectangle. The position is relative
+    to the top-left corner of the image.
+
+ -aspect <W:H>
+    Crop the image to the largest area with the given aspect ratio.
+    For example: -aspect 16:9
+
+ -percent <P> or <Px,Py>
+    Crop the image to the given percentage of its width and height.
+
+ -gravity <name>
+    Position of the cropped area for -aspect and -percent, or the
+    position of the image on the canvas for -pad. Defaults to center.
+    Known values are: center, north, northeast, east, southeast,
+    south, southwest, west, northwest and smart.
+
+    The smart gravity selects the most interesting area of the image,
+    based on edges, entropy, skin tones and saturated colors. For -pad,
+    it is the same as center.
+
+ -trim <mode>
+    Remove uniform borders from the image. The mode is one of:
+
+    * auto: Trim pixels matching the color of the top-left pixel.
+    * transparent: Trim fully transparent pixels.
+    * <color>: Trim pixels matching the given color. E.g.: #ffffff
+
+ -tolerance <N>
+    The largest difference (0-255) in any color channel for which
+    a pixel still counts as border during -trim. Defaults to 0.
+
+ -pad <WxH>
+    Place the image on a canvas of the given size. For example:
+    -pad 640x480
+
+ -color <color>
+    The color of the canvas for -pad. Defaults to transparent.
+
+ -type <name>
+    Name of the output image format: %s
+    Defaults to png.
+
+ -options <string>
+    A semi-colon-separated list of encoder options. See imgconv
+    for details.
+
+    Operations are applied in the order: trim, crop and pad.
+
+`, AppName, AppName, strings.Join(lib.Formats(), ", "))
+}
@@ -4,7 +4,6 @@
 package lib
 
 import (
-	"image/color"
 	"image/draw"
 	"math"
 )
@@ -14,159 +13,210 @@ type pixel struct {
 	average    uint8
 	lightness  uint8
 	luminosity uint8
+	x, y       int    // Position, relative to the top-left of the image.
+	frame      *frame // The image being processed.
 }
 
 // Apply the given color mapping to the specified image buffers.
+// This runs a Program with a single rule.
 func Apply(from, to *Rule, src, dst draw.Image) {
-	var x, y int
-	var r, g, b, a uint32
-	var sc color.Color
-	var dc color.RGBA
-	var pix pixel
-
-	rect := src.Bounds()
-
-	for y = 0; y < rect.Dy(); y++ {
-		for x = 0; x < rect.Dx(); x++ {
-			sc = src.At(x, y)
-
-			r, g, b, a = sc.RGBA()
-			pix.r = uint8(r >> 8)
-			pix.g = uint8(g >> 8)
-			pix.b = uint8(b >> 8)
-			pix.a = uint8(a >> 8)
-
-			// Check if the pixel matches the filter rule.
-			if !(match(pix.r, from.R) && match(pix.g, from.G) && match(pix.b, from.B) && match(pix.a, from.A)) {
-				dst.Set(x, y, sc)
-				continue
-			}
+	p := Program{stages: []stage{rules{newRule(RGB, from, to, nil)}}}
+	p.draw(src, dst)
+}
+
+// newRule compiles the given color mapping, with optional conditions.
+func newRule(s Space, from, to *Rule, conds []condition) *rule {
+	rl := &rule{space: s, from: from, to: to, conds: conds}
 
-			// Compute three different types of grayscale conversion.
-			// These can be applied by named references.
-			pix.average = uint8(((r + g + b) / 3) >> 8)
-			pix.lightness = uint8(((min(min(r, g), b) + max(max(r, g), b)) / 2) >> 8)
-
-			// For luminosity it is necessary to apply an inverse of the gamma
-			// function for the color space before calculating the inner product.
-			// Then you apply the gamma function to the reduced value. Failure to
-			// incorporate the gamma function can result in errors of up to 20%.
-			//
-			// For typical computer stuff, the color space is sRGB. The right
-			// numbers for sRGB are approx. 0.21, 0.72, 0.07. Gamma for sRGB
-			// is a composite function that approximates exponentiation by 1/2.2
-			//
-			// This is a rather expensive operation, but gives a much more accurate
-			// and satisfactory result than the average and lightness versions.
-			pix.luminosity = gammaSRGB(
-				0.212655*invGammaSRGB(pix.r) +
-					0.715158*invGammaSRGB(pix.g) +
-					0.072187*invGammaSRGB(pix.b))
-
-			// Transform color.
-			dc.R = transform(&pix, pix.r, to.R)
-			dc.G = transform(&pix, pix.g, to.G)
-			dc.B = transform(&pix, pix.b, to.B)
-			dc.A = transform(&pix, pix.a, to.A)
-
-			// Set new pixel.
-			dst.Set(x, y, dc)
+	need := func(n Name) {
+		switch n {
+		case NameAverage, NameLightness:
+			rl.derived = true
+		case NameLuminosity:
+			rl.derived = true
+			rl.luminosity = true
+		case NameMask:
+			rl.usesMask = true
 		}
 	}
+
+	for _, c := range conds {
+		need(c.name)
+	}
+
+	for _, c := range []Channel{to.R, to.G, to.B, to.A} {
+		switch tt := c.(type) {
+		case Name:
+			need(tt)
+		case *Expr:
+			tt.walk(func(e *Expr) {
+				if e.Op == "name" {
+					need(e.Name)
+				}
+			})
+		}
+	}
+
+	return rl
+}
+
+// derive computes the grayscale conversions of the pixel's color.
+// These can be applied by named references. The luminosity is only
+// computed if requested.
+func (pix *pixel) derive(luminosity bool) {
+	r := uint32(pix.r) * 0x101
+	g := uint32(pix.g) * 0x101
+	b := uint32(pix.b) * 0x101
+
+	pix.average = uint8(((r + g + b) / 3) >> 8)
+	pix.lightness = uint8(((min(min(r, g), b) + max(max(r, g), b)) / 2) >> 8)
+
+	if !luminosity {
+		return
+	}
+
+	// For luminosity it is necessary to apply an inverse of the gamma
+	// function for the color space before calculating the inner product.
+	// Then you apply the gamma function to the reduced value. Failure to
+	// incorporate the gamma function can result in errors of up to 20%.
+	//
+	// For typical computer stuff, the color space is sRGB. The right
+	// numbers for sRGB are approx. 0.21, 0.72, 0.07. Gamma for sRGB
+	// is a composite function that approximates exponentiation by 1/2.2
+	//
+	// This is a rather expensive operation, but gives a much more accurate
+	// and satisfactory result than the average and lightness versions.
+	pix.luminosity = gammaSRGB(
+		0.212655*invGammaSRGB(pix.r) +
+			0.715158*invGammaSRGB(pix.g) +
+			0.072187*invGammaSRGB(pix.b))
 }
 
 // transform transforms a single channel using the specified mapping.
-func transform(pix *pixel, curr uint8, to Channel) uint8 {
+// The result is limited to the range of the channel.
+func transform(pix *pixel, curr float64, to Channel, cr *channelRange) float64 {
 	switch tt := to.(type) {
 	case Number:
+		v := tt.Value
+
 		switch tt.Operator {
-		case "+":
+		case "+", "-":
 			if tt.Percentage {
-				perc := float64(curr) * 0.01
-				v := int(curr) + int(perc*float64(tt.Value))
-				if v > 0xff {
-					v = 0xff
-				}
-				return uint8(v)
+				v *= curr * 0.01
 			}
 
-			v := int(curr) + int(tt.Value)
-			if v > 0xff {
-				v = 0xff
+			if cr.integer {
+				v = math.Trunc(v)
 			}
-			return uint8(v)
 
-		case "-":
-			if tt.Percentage {
-				perc := float64(curr) * 0.01
-				v := int(curr) - int(perc*float64(tt.Value))
-				if v < 0 {
-					v = 0
-				}
-				return uint8(v)
+			if tt.Operator == "-" {
+				v = -v
 			}
 
-			v := int(curr) - int(tt.Value)
-			if v < 0 {
-				v = 0
-			}
-			return uint8(v)
+			return cr.clamp(curr + v)
 
 		default:
 			if tt.Percentage {
-				perc := float64(curr) * 0.01
-				v := int(perc * float64(tt.Value))
-				if v > 0xff {
-					v = 0xff
+				v *= curr * 0.01
+
+				if cr.integer {
+					v = math.Trunc(v)
 				}
-				return uint8(v)
 			}
 
-			return tt.Value
+			return cr.clamp(v)
 		}
 
 	case Name:
-		switch tt {
-		case NameR:
-			return pix.r
-		case NameG:
-			return pix.g
-		case NameB:
-			return pix.b
-		case NameA:
-			return pix.a
-		case NameLightness:
-			return pix.lightness
-		case NameLuminosity:
-			return pix.luminosity
-		case NameAverage:
-			return pix.average
+		return cr.clamp(pix.value(tt))
+
+	case *Expr:
+		// Results like 0/0 are not a number, and yield the lowest value.
+		v := tt.eval(pix, cr)
+		if math.IsNaN(v) {
+			return cr.min
 		}
+		return cr.clamp(v)
 	}
 
 	// wildcard -- return original value.
 	return curr
 }
 
-// match checks if the given channel value mathes the given channel rule.
-func match(v uint8, c Channel) bool {
-	num, ok := c.(Number)
-	if !ok {
-		return true // wildcard
+// value returns the value of the given named reference for the pixel.
+func (pix *pixel) value(n Name) float64 {
+	switch n {
+	case NameR:
+		return float64(pix.r)
+	case NameG:
+		return float64(pix.g)
+	case NameB:
+		return float64(pix.b)
+	case NameA:
+		return float64(pix.a)
+	case NameLightness:
+		return float64(pix.lightness)
+	case NameLuminosity:
+		return float64(pix.luminosity)
+	case NameAverage:
+		return float64(pix.average)
+	case NameHSLHue, NameHSLSaturation, NameHSLLightness:
+		return pix.colors(HSL)[n-NameHSLHue]
+	case NameHSVHue, NameHSVSaturation, NameHSVValue:
+		return pix.colors(HSV)[n-NameHSVHue]
+	case NameLabL, NameLabA, NameLabB:
+		return pix.colors(Lab)[n-NameLabL]
+	case NameX:
+		return float64(pix.x)
+	case NameY:
+		return float64(pix.y)
+	case NameWidth:
+		return float64(pix.frame.width)
+	case NameHeight:
+		return float64(pix.frame.height)
+	case NameMask:
+		return float64(pix.frame.maskValue(pix.x, pix.y))
 	}
 
-	switch num.Operator {
-	case "<":
-		return v < num.Value
-	case "<=":
-		return v <= num.Value
-	case ">":
-		return v > num.Value
-	case ">=":
-		return v >= num.Value
+	return 0
+}
+
+// match checks if the given channel value mathes the given channel rule.
+func match(v float64, c Channel) bool {
+	switch tt := c.(type) {
+	case Number:
+		switch tt.Operator {
+		case "<":
+			return v < tt.Value
+		case "<=":
+			return v <= tt.Value
+		case ">":
+			return v > tt.Value
+		case ">=":
+			return v >= tt.Value
+		}
+
+		return v == tt.Value
+
+	case Range:
+		if tt.Min.Value > tt.Max.Value {
+			return v >= tt.Min.Value || v <= tt.Max.Value
+		}
+		return v >= tt.Min.Value && v <= tt.Max.Value
+
+	case Set:
+		for _, num := range tt {
+			if v == num.Value {
+				return true
+			}
+		}
+		return false
+
+	case Not:
+		return !match(v, tt.Channel)
 	}
 
-	return v == num.Value
+	return true // wildcard
 }
 
 // sRGB "gamma" function (approx 2.2)
@@ -182,12 +232,21 @@ func gammaSRGB(v float64) uint8 {
 
 // Inverse of gamma_sRGB "gamma" function. (approx 2.2)
 func invGammaSRGB(ic uint8) float64 {
-	c := float64(ic) / 255
-	if c <= 0.04045 {
-		return c / 12.92
-	}
+	return invGammaTable[ic]
+}
+
+// invGammaTable holds the inverse gamma for all 8 bit values.
+var invGammaTable [256]float64
 
-	return math.Pow(((c + 0.055) / (1.055)), 2.4)
+func init() {
+	for i := range invGammaTable {
+		c := float64(i) / 255
+		if c <= 0.04045 {
+			invGammaTable[i] = c / 12.92
+		} else {
+			invGammaTable[i] = math.Pow(((c + 0.055) / (1.055)), 2.4)
+		}
+	}
 }
 
 func min(a, b uint32) uint32 {
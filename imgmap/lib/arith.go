@@ -0,0 +1,332 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package lib
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Expr is an arithmetic expression, which computes a destination
+// channel value from numbers and named references. For example:
+//
+//	#r*0.5 + #b*0.5
+//	255 - #g
+//	clamp(#L * 1.2)
+//
+// Expressions are checked when they are parsed. Evaluating one
+// can not fail; a result which is not a number yields the lowest
+// value of the channel.
+type Expr struct {
+	Op    string  // num, name, neg, +, -, *, / or a function name.
+	Value float64 // Value of a number.
+	Name  Name    // Named reference.
+	Args  []*Expr // Operands, or function arguments.
+}
+
+// functions lists the known functions, along with
+// the minimum and maximum number of arguments they take.
+// A maximum of -1 means there is no limit.
+var functions = map[string][2]int{
+	"min":   {2, -1},
+	"max":   {2, -1},
+	"clamp": {1, 3},
+	"abs":   {1, 1},
+	"pow":   {2, 2},
+	"floor": {1, 1},
+	"mod":   {2, 2},
+}
+
+// parseExpr parses an arithmetic expression from the given input string.
+func parseExpr(data string) (*Expr, error) {
+	p := exprParser{data: data}
+
+	e, err := p.sum()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid expression %q: %v", data, err)
+	}
+
+	if p.skip(); p.pos < len(p.data) {
+		return nil, fmt.Errorf("Invalid expression %q: unexpected %q", data, p.data[p.pos:])
+	}
+
+	return e, nil
+}
+
+// exprParser is a recursive descent parser for arithmetic expressions.
+//
+//	sum     = product { ("+" | "-") product } .
+//	product = unary { ("*" | "/") unary } .
+//	unary   = [ "-" | "+" ] primary .
+//	primary = number | name | "(" sum ")" | function "(" sum { "," sum } ")" .
+type exprParser struct {
+	data string
+	pos  int
+}
+
+// skip advances past any whitespace.
+func (p *exprParser) skip() {
+	for p.pos < len(p.data) && strings.IndexByte(" \t\r\n", p.data[p.pos]) > -1 {
+		p.pos++
+	}
+}
+
+// peek returns the next non-whitespace character, or 0 at the end.
+func (p *exprParser) peek() byte {
+	if p.skip(); p.pos < len(p.data) {
+		return p.data[p.pos]
+	}
+	return 0
+}
+
+// expect consumes the character c, or returns an error.
+func (p *exprParser) expect(c byte) error {
+	if p.peek() != c {
+		return fmt.Errorf("expected %q at offset %d", c, p.pos)
+	}
+	p.pos++
+	return nil
+}
+
+// word returns the next run of letters, digits, periods and hash signs.
+func (p *exprParser) word() string {
+	start := p.pos
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if !(c == '.' || c == '#' || c == '_' || c >= '0' && c <= '9' ||
+			c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			break
+		}
+		p.pos++
+	}
+	return p.data[start:p.pos]
+}
+
+// number returns the next numeric literal. Unlike word, this includes
+// the sign of an exponent, as in 1e-3. Hexadecimal literals have no
+// exponent, so 0x1e+2 is the sum of 0x1e and 2.
+func (p *exprParser) number() string {
+	start := p.pos
+	for {
+		p.word()
+
+		word := p.data[start:p.pos]
+		if strings.HasPrefix(word, "0x") || p.pos+1 >= len(p.data) {
+			break
+		}
+
+		last, next := word[len(word)-1], p.data[p.pos]
+		if last != 'e' && last != 'E' || next != '+' && next != '-' {
+			break
+		}
+		p.pos++
+	}
+	return p.data[start:p.pos]
+}
+
+func (p *exprParser) sum() (*Expr, error) {
+	e, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			return e, nil
+		}
+		p.pos++
+
+		rhs, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+
+		e = &Expr{Op: string(op), Args: []*Expr{e, rhs}}
+	}
+}
+
+func (p *exprParser) product() (*Expr, error) {
+	e, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' {
+			return e, nil
+		}
+		p.pos++
+
+		rhs, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		if op == '/' && rhs.Op == "num" && rhs.Value == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+
+		e = &Expr{Op: string(op), Args: []*Expr{e, rhs}}
+	}
+}
+
+func (p *exprParser) unary() (*Expr, error) {
+	switch p.peek() {
+	case '+':
+		p.pos++
+		return p.primary()
+
+	case '-':
+		p.pos++
+		e, err := p.primary()
+		if err != nil {
+			return nil, err
+		}
+		return &Expr{Op: "neg", Args: []*Expr{e}}, nil
+	}
+
+	return p.primary()
+}
+
+func (p *exprParser) primary() (*Expr, error) {
+	c := p.peek()
+
+	switch {
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+
+	case c == '(':
+		p.pos++
+		e, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(')')
+
+	case c == '#':
+		word := p.word()
+		name, ok := names[word]
+		if !ok {
+			return nil, fmt.Errorf("unknown named reference %s", word)
+		}
+		return &Expr{Op: "name", Name: name}, nil
+
+	case c == '.' || c >= '0' && c <= '9':
+		word := p.number()
+		if p.peek() == '%' {
+			return nil, fmt.Errorf("percentages are not valid in an expression")
+		}
+
+		v, err := parseNumber(word)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", word)
+		}
+		return &Expr{Op: "num", Value: v}, nil
+	}
+
+	word := p.word()
+	if len(word) == 0 {
+		return nil, fmt.Errorf("unexpected %q at offset %d", c, p.pos)
+	}
+
+	arity, ok := functions[word]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", word)
+	}
+
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+
+	e := &Expr{Op: word}
+	for {
+		arg, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+
+		e.Args = append(e.Args, arg)
+
+		if p.peek() != ',' {
+			break
+		}
+		p.pos++
+	}
+
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+
+	n := len(e.Args)
+	if n < arity[0] || arity[1] > -1 && n > arity[1] || word == "clamp" && n == 2 {
+		return nil, fmt.Errorf("wrong number of arguments for %s: %d", word, n)
+	}
+
+	return e, nil
+}
+
+// walk calls fn for e and all of its sub expressions.
+func (e *Expr) walk(fn func(*Expr)) {
+	fn(e)
+	for _, a := range e.Args {
+		a.walk(fn)
+	}
+}
+
+// eval computes the value of the expression for the given pixel.
+// The range of the destination channel is used by clamp with a
+// single argument.
+func (e *Expr) eval(pix *pixel, cr *channelRange) float64 {
+	switch e.Op {
+	case "num":
+		return e.Value
+	case "name":
+		return pix.value(e.Name)
+	case "neg":
+		return -e.Args[0].eval(pix, cr)
+	case "abs":
+		return math.Abs(e.Args[0].eval(pix, cr))
+	case "floor":
+		return math.Floor(e.Args[0].eval(pix, cr))
+	}
+
+	a := e.Args[0].eval(pix, cr)
+
+	switch e.Op {
+	case "+":
+		return a + e.Args[1].eval(pix, cr)
+	case "-":
+		return a - e.Args[1].eval(pix, cr)
+	case "*":
+		return a * e.Args[1].eval(pix, cr)
+	case "/":
+		return a / e.Args[1].eval(pix, cr)
+	case "pow":
+		return math.Pow(a, e.Args[1].eval(pix, cr))
+	case "mod":
+		return math.Mod(a, e.Args[1].eval(pix, cr))
+
+	case "min":
+		for _, arg := range e.Args[1:] {
+			a = math.Min(a, arg.eval(pix, cr))
+		}
+
+	case "max":
+		for _, arg := range e.Args[1:] {
+			a = math.Max(a, arg.eval(pix, cr))
+		}
+
+	case "clamp":
+		lo, hi := cr.min, cr.max
+		if len(e.Args) == 3 {
+			lo, hi = e.Args[1].eval(pix, cr), e.Args[2].eval(pix, cr)
+		}
+		a = math.Max(lo, math.Min(hi, a))
+	}
+
+	return a
+}
@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func Test_Expressions(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	img.SetRGBA(0, 0, color.RGBA{200, 100, 50, 255})
+
+	tests := []struct {
+		expr string
+		want color.RGBA
+	}{
+		{"? ? ? ?  255-#r 255-#g 255-#b ?", color.RGBA{55, 155, 205, 255}},
+		{"? ? ? ?  #r*0.5+#b*0.5 ? ? ?", color.RGBA{125, 100, 50, 255}},
+		{"? ? ? ?  ((#r + #b) * 0.5) ? ? ?", color.RGBA{125, 100, 50, 255}},
+		{"? ? ? ?  #r+#g*2 ? ? ?", color.RGBA{255, 100, 50, 255}},
+		{"? ? ? ?  -#r+10 ? ? ?", color.RGBA{0, 100, 50, 255}},
+		{"? ? ? ?  -#r +#r ? ?", color.RGBA{0, 200, 50, 255}},
+		{"? ? ? ?  min(#r,#g,#b) max(#r,#g) abs(#b-#g) ?", color.RGBA{50, 200, 50, 255}},
+		{"? ? ? ?  clamp(#r*2)-10 clamp(#g,0,60) pow(#b/255,2)*255 ?", color.RGBA{245, 60, 10, 255}},
+		{"? ? ? ?  0x10*2/4 1.5e1 ? ?", color.RGBA{8, 15, 50, 255}},
+		{"? ? ? ?  #r*1e-3*500 2.5E+1+#g 0x1e+2 ?", color.RGBA{100, 125, 32, 255}},
+		{"hsl ? ? ? ?  #hsl.h+180 ? ? ?", color.RGBA{50, 150, 200, 255}},
+	}
+
+	for _, tt := range tests {
+		got := compile(t, tt.expr).Run(img).RGBAAt(0, 0)
+		if got != tt.want {
+			t.Errorf("%q: %v; want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func Test_ExpressionNaN(t *testing.T) {
+	// Expressions which are not a number on black yield the lowest value
+	// of the channel. An infinite result is clipped to the highest.
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	img.SetRGBA(0, 0, color.RGBA{0, 0, 0, 255})
+
+	tests := []struct {
+		expr string
+		want color.RGBA
+	}{
+		{"? ? ? ?  #r/#g pow(#r-100,0.5) mod(#r,#g) ?", color.RGBA{0, 0, 0, 255}},
+		{"? ? ? ?  1/#r (#r/#g)+255 ? ?", color.RGBA{255, 0, 0, 255}},
+		{"hsl ? ? ? ?  #r/#g 100 50 ?", color.RGBA{255, 0, 0, 255}},
+	}
+
+	for _, tt := range tests {
+		got := compile(t, tt.expr).Run(img).RGBAAt(0, 0)
+		if got != tt.want {
+			t.Errorf("%q: %v; want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func Test_ExpressionErrors(t *testing.T) {
+	for _, expr := range []string{
+		"? ? ? ?  foo(#r) ? ? ?",
+		"? ? ? ?  =#r ? ? ?",
+		"-#r ? ? ?  ? ? ? ?",
+		">#r ? ? ?  ? ? ? ?",
+		"? ? ? ?  abs(#r,#g) ? ? ?",
+		"? ? ? ?  clamp(#r,1) ? ? ?",
+		"? ? ? ?  min(#r) ? ? ?",
+		"? ? ? ?  (#r+1 ? ? ?",
+		"? ? ? ?  #r+1) ? ? ?",
+		"? ? ? ?  #r*50% ? ? ?",
+		"? ? ? ?  #r/0 ? ? ?",
+		"? ? ? ?  #q+1 ? ? ?",
+		"? ? ? ?  #r+ ? ? ?",
+		"? ? ? ?  #r*1e- ? ? ?",
+		"? ? ? ?  #r*1e-x ? ? ?",
+		"? ? ? ?  #r #g ? ? ?",
+		"#r+1 ? ? ?  ? ? ? ?",
+	} {
+		if _, err := parseLine([]byte(expr)); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
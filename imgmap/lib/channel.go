@@ -5,20 +5,49 @@ package lib
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 )
 
 // Channel represents a color channel value.
-// This can be a number, wildcard or named reference.
+// This can be a number, wildcard, named reference or expression.
 type Channel interface{}
 
 // parseChannel parses a channel value from the given input string.
+// Anything which is not a plain value is parsed as an expression.
 func parseChannel(data string) (Channel, error) {
+	c, err := parseValue(data)
+	if err == nil {
+		return c, nil
+	}
+
+	if strings.ContainsAny(data, "+-*/()") || strings.IndexByte(data, '#') > 0 {
+		return parseExpr(data)
+	}
+
+	return nil, err
+}
+
+// parseValue parses a number, wildcard, named reference or condition.
+func parseValue(data string) (Channel, error) {
 	var operator string
 	var percentage bool
 
-	base := 10
+	switch {
+	case strings.HasPrefix(data, "!"):
+		c, err := parseValue(data[1:])
+		if err != nil {
+			return nil, err
+		}
+		return Not{c}, nil
+
+	case strings.HasPrefix(data, "{"):
+		return parseSet(data)
+
+	case strings.Contains(data, ".."):
+		return parseRange(data)
+	}
 
 	switch {
 	case strings.HasPrefix(data, "-"):
@@ -27,6 +56,9 @@ func parseChannel(data string) (Channel, error) {
 	case strings.HasPrefix(data, "+"):
 		operator = "+"
 		data = data[1:]
+	case strings.HasPrefix(data, "="):
+		operator = "="
+		data = data[1:]
 	case strings.HasPrefix(data, ">="):
 		operator = ">="
 		data = data[2:]
@@ -41,34 +73,26 @@ func parseChannel(data string) (Channel, error) {
 		data = data[1:]
 	}
 
-	if len(data) > 2 && strings.HasPrefix(data, "0x") {
-		base = 16
-		data = data[2:]
+	if len(data) == 0 {
+		return nil, fmt.Errorf("Invalid channel value: %s", data)
 	}
 
 	switch {
 	case data[0] == '?':
 		return Wildcard{}, nil
 
-	case len(data) == 2 && data[0] == '#':
-		switch data[1] {
-		case 'r':
-			return NameR, nil
-		case 'g':
-			return NameG, nil
-		case 'b':
-			return NameB, nil
-		case 'a':
-			return NameA, nil
-		case 'A':
-			return NameAverage, nil
-		case 'l':
-			return NameLightness, nil
-		case 'L':
-			return NameLuminosity, nil
-		default:
+	case data[0] == '#':
+		name, ok := names[data]
+		if !ok {
 			return nil, fmt.Errorf("Invalid named reference: %s", data)
 		}
+
+		// A named reference has no operator. In a destination,
+		// "-#r" is an expression, which parseChannel handles.
+		if len(operator) > 0 {
+			return nil, fmt.Errorf("Operator %q is not valid before named reference %s", operator, data)
+		}
+		return name, nil
 	}
 
 	if data[len(data)-1] == '%' {
@@ -76,22 +100,94 @@ func parseChannel(data string) (Channel, error) {
 		data = data[:len(data)-1]
 	}
 
-	if len(data) == 0 {
-		return nil, fmt.Errorf("Invalid channel value: %s", data)
-	}
-
-	n, err := strconv.ParseUint(data, base, 8)
+	value, err := parseNumber(data)
 	if err != nil {
-		return nil, fmt.Errorf("ParseChannel: %v", err)
+		return nil, err
 	}
 
 	return Number{
 		Operator:   operator,
-		Value:      uint8(n),
+		Value:      value,
 		Percentage: percentage,
 	}, nil
 }
 
+// parseSet parses a set of numbers of the form "{a,b,c}".
+func parseSet(data string) (Channel, error) {
+	if !strings.HasSuffix(data, "}") {
+		return nil, fmt.Errorf("Invalid set: %s", data)
+	}
+
+	list, err := splitFields(data[1 : len(data)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(list) == 0 {
+		return nil, fmt.Errorf("Invalid set: %s", data)
+	}
+
+	set := make(Set, len(list))
+	for i, v := range list {
+		c, err := parseValue(v)
+		if err != nil {
+			return nil, err
+		}
+
+		num, ok := c.(Number)
+		if !ok {
+			return nil, fmt.Errorf("Invalid set value %s in %s", v, data)
+		}
+
+		set[i] = num
+	}
+
+	return set, nil
+}
+
+// parseRange parses a range of numbers of the form "a..b".
+func parseRange(data string) (Channel, error) {
+	idx := strings.Index(data, "..")
+	bounds := [2]string{data[:idx], data[idx+2:]}
+
+	var r [2]Number
+	for i, v := range bounds {
+		c, err := parseValue(v)
+		if err != nil {
+			return nil, err
+		}
+
+		num, ok := c.(Number)
+		if !ok {
+			return nil, fmt.Errorf("Invalid range: %s", data)
+		}
+
+		r[i] = num
+	}
+
+	return Range{r[0], r[1]}, nil
+}
+
+// parseNumber parses a decimal number, which may have a sign and a
+// fraction, or a hexadecimal integer with a 0x prefix. NaN and
+// infinities are not valid channel values.
+func parseNumber(data string) (float64, error) {
+	if len(data) > 2 && strings.HasPrefix(data, "0x") {
+		n, err := strconv.ParseUint(data[2:], 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid channel value: %s", data)
+		}
+		return float64(n), nil
+	}
+
+	n, err := strconv.ParseFloat(data, 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("Invalid channel value: %s", data)
+	}
+
+	return n, nil
+}
+
 // Wildcard represents a channel as a wildcard value.
 type Wildcard struct{}
 
@@ -107,12 +203,67 @@ const (
 	NameLightness
 	NameLuminosity
 	NameAverage
+	NameHSLHue
+	NameHSLSaturation
+	NameHSLLightness
+	NameHSVHue
+	NameHSVSaturation
+	NameHSVValue
+	NameLabL
+	NameLabA
+	NameLabB
+	NameX
+	NameY
+	NameWidth
+	NameHeight
+	NameMask
 )
 
+// names maps the named references onto their names.
+// Names are case sensitive.
+var names = map[string]Name{
+	"#r":     NameR,
+	"#g":     NameG,
+	"#b":     NameB,
+	"#a":     NameA,
+	"#l":     NameLightness,
+	"#L":     NameLuminosity,
+	"#A":     NameAverage,
+	"#hsl.h": NameHSLHue,
+	"#hsl.s": NameHSLSaturation,
+	"#hsl.l": NameHSLLightness,
+	"#hsv.h": NameHSVHue,
+	"#hsv.s": NameHSVSaturation,
+	"#hsv.v": NameHSVValue,
+	"#lab.l": NameLabL,
+	"#lab.a": NameLabA,
+	"#lab.b": NameLabB,
+	"#x":     NameX,
+	"#y":     NameY,
+	"#w":     NameWidth,
+	"#h":     NameHeight,
+	"#m":     NameMask,
+}
+
 // Number represents a numeric value.
 // It can carry an operator and/or percentile token.
 type Number struct {
-	Operator   string // <empty>, +, -, <, <=, >, >=
-	Value      uint8
+	Operator   string // <empty>, =, +, -, <, <=, >, >=
+	Value      float64
 	Percentage bool
 }
+
+// Range matches channel values from Min to Max, inclusive.
+// For channels which wrap around, like the hue, Min may exceed Max.
+// The range then includes the values at both ends of the channel.
+type Range struct {
+	Min, Max Number
+}
+
+// Set matches any of the listed channel values.
+type Set []Number
+
+// Not matches all channel values which its Channel does not match.
+type Not struct {
+	Channel Channel
+}
@@ -7,123 +7,330 @@ import (
 	"bytes"
 	"fmt"
 	"image/draw"
+	"math"
+	"strings"
 )
 
-var (
-	semicolon = []byte{';'}
-	tab       = []byte{'\t'}
-	space     = []byte{' '}
-)
+var semicolon = []byte{';'}
 
 // Parse parses a single color map expression and
 // applies it to the source and destination images.
+// It returns false if the expression is empty.
 func Parse(expr []byte, src, dst draw.Image) (bool, error) {
+	rl, err := parseLine(expr)
+	if err != nil || rl == nil {
+		return false, err
+	}
+
+	p := Program{stages: []stage{rules{rl}}}
+	p.draw(src, dst)
+	return true, nil
+}
+
+// parseLine parses a single color map expression into a rule.
+// It returns nil if the line holds no expression.
+func parseLine(expr []byte) (*rule, error) {
 	// Strip whitespace and code comments.
 	if idx := bytes.Index(expr, semicolon); idx > -1 {
 		expr = expr[:idx]
 	}
 
-	expr = bytes.Replace(expr, tab, space, -1)
-
 	// Split into source and destination Rule mappings.
-	list := splitBytes(expr, space)
-	if len(list) != 8 {
-		return false, nil
+	list, err := splitFields(string(expr))
+	if err != nil {
+		return nil, err
 	}
 
-	left := bytes.Join(list[:4], space)
-	right := bytes.Join(list[4:], space)
+	if len(list) == 0 {
+		return nil, nil
+	}
 
-	from, err := ParseRule(string(left))
-	if err != nil {
-		return false, err
+	// Optional conditions on named references and regions,
+	// following the destination color.
+	var conds []condition
+	var regions []region
+	for i, v := range list {
+		if v != "if" {
+			continue
+		}
+
+		if i == len(list)-1 {
+			return nil, fmt.Errorf("Missing condition after \"if\"")
+		}
+
+		for _, v := range list[i+1:] {
+			if isRegion(v) {
+				r, err := parseRegion(v)
+				if err != nil {
+					return nil, err
+				}
+				regions = append(regions, r)
+				continue
+			}
+
+			cond, err := parseCondition(v)
+			if err != nil {
+				return nil, err
+			}
+			conds = append(conds, cond)
+		}
+
+		list = list[:i]
+		break
+	}
+
+	// An optional leading color space name.
+	cs := RGB
+	if len(list) > 0 {
+		if v, ok := parseSpace(list[0]); ok {
+			cs = v
+			list = list[1:]
+		} else if len(list) == 9 {
+			return nil, fmt.Errorf("Unknown color space %q; expected one of rgb, hsl, hsv or lab", list[0])
+		}
 	}
 
-	to, err := ParseRule(string(right))
+	var from *Rule
+	var fz *fuzzy
+
+	if len(list) > 4 && strings.HasPrefix(list[0], "~") {
+		// A fuzzy source color, which matches any channel values.
+		fz, err = parseFuzzy(list[:len(list)-4])
+		if err != nil {
+			return nil, err
+		}
+
+		from = &Rule{Wildcard{}, Wildcard{}, Wildcard{}, Wildcard{}}
+		list = list[len(list)-4:]
+
+	} else if len(list) != 8 {
+		return nil, fmt.Errorf("Invalid expression %q; expected a source and destination color of 4 values each", expr)
+
+	} else {
+		from, err = ParseRule(strings.Join(list[:4], " "))
+		if err != nil {
+			return nil, err
+		}
+
+		list = list[4:]
+	}
+
+	to, err := ParseRule(strings.Join(list, " "))
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	err = validFrom(from)
+	err = validFrom(cs, from)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	err = validTo(to)
+	err = validTo(cs, to)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	Apply(from, to, src, dst)
-	return true, nil
+	rl := newRule(cs, from, to, conds)
+	rl.fuzzy = fz
+	rl.regions = regions
+
+	for _, r := range regions {
+		if r.shape == "mask" {
+			rl.usesMask = true
+		}
+	}
+
+	return rl, nil
 }
 
-// validFrom returns false if the given Rule does not contain values
-// which make no sense in this context.
-func validFrom(r *Rule) error {
-	if err := validFromChannel(r.R); err != nil {
-		return err
+// parseCondition parses a condition on a named reference, like "#L<50"
+// or "#hsl.s=20%..80%". It accepts the same conditions as a source
+// channel, except for the wildcard.
+func parseCondition(data string) (condition, error) {
+	var cond condition
+
+	idx := strings.IndexAny(data, "=!<>{")
+	if idx < 0 {
+		return cond, fmt.Errorf("Invalid condition %q; expected a named reference, followed by a comparison", data)
+	}
+
+	name, ok := names[data[:idx]]
+	if !ok {
+		return cond, fmt.Errorf("Invalid named reference: %s", data[:idx])
 	}
 
-	if err := validFromChannel(r.G); err != nil {
-		return err
+	c, err := parseValue(data[idx:])
+	if err != nil {
+		return cond, err
+	}
+
+	if _, ok := c.(Wildcard); ok {
+		return cond, fmt.Errorf("Invalid condition %q; a wildcard always matches", data)
 	}
 
-	if err := validFromChannel(r.B); err != nil {
-		return err
+	if err := validFromChannel(&c, nameRange(name)); err != nil {
+		return cond, err
 	}
 
-	return validFromChannel(r.A)
+	cond.name = name
+	cond.match = c
+	return cond, nil
 }
 
-func validFromChannel(c Channel) error {
-	switch tt := c.(type) {
+// channels returns pointers to the channels of r, in order.
+func (r *Rule) channels() [4]*Channel {
+	return [4]*Channel{&r.R, &r.G, &r.B, &r.A}
+}
+
+// validFrom returns an error if the given Rule contains values
+// which make no sense in this context. Numbers are normalized to
+// absolute values in the units of the color space.
+func validFrom(s Space, r *Rule) error {
+	for i, c := range r.channels() {
+		if err := validFromChannel(c, &ranges[s][i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validFromChannel(c *Channel, cr *channelRange) error {
+	switch tt := (*c).(type) {
 	case Number:
-		switch tt.Operator {
-		case "", "<", "<=", ">", ">=":
+		num, err := sourceValue(tt, cr, true)
+		if err != nil {
+			return err
+		}
+		*c = num
+
+	case Range:
+		min, err := sourceValue(tt.Min, cr, false)
+		if err != nil {
+			return err
+		}
+
+		max, err := sourceValue(tt.Max, cr, false)
+		if err != nil {
+			return err
+		}
+
+		if min.Value > max.Value && !cr.wrap {
+			return fmt.Errorf("Invalid range %g..%g; the %s channel does not wrap around", min.Value, max.Value, cr.name)
+		}
+
+		*c = Range{min, max}
+
+	case Set:
+		set := make(Set, len(tt))
+		for i, v := range tt {
+			num, err := sourceValue(v, cr, false)
+			if err != nil {
+				return err
+			}
+			set[i] = num
+		}
+		*c = set
+
+	case Not:
+		switch tt.Channel.(type) {
+		case Number, Range, Set:
 		default:
-			return fmt.Errorf("Operator %q is not valid in a source Rule context.", tt.Operator)
+			return fmt.Errorf("Negation requires a number, range or set.")
 		}
 
-		if tt.Percentage {
-			return fmt.Errorf("Percentages are not valid in a source Rule context.")
+		inner := tt.Channel
+		if err := validFromChannel(&inner, cr); err != nil {
+			return err
 		}
+		*c = Not{inner}
 
 	case Name:
 		return fmt.Errorf("Named reference is not valid in a source Rule context.")
+
+	case *Expr:
+		return fmt.Errorf("Expression is not valid in a source Rule context.")
 	}
 
 	return nil
 }
 
-// validTo returns false if the given Rule does not contain values
-// which make no sense in this context.
-func validTo(r *Rule) error {
-	if err := validToChannel(r.R); err != nil {
-		return err
+// sourceValue normalizes a number in a source Rule context to an
+// absolute value in the units of the channel. Comparison operators
+// are only allowed if compare is true.
+func sourceValue(num Number, cr *channelRange, compare bool) (Number, error) {
+	switch num.Operator {
+	case "", "=":
+		num.Operator = ""
+	case "-":
+		// A negative value, as used by the Lab channels.
+		num.Operator = ""
+		num.Value = -num.Value
+	case "<", "<=", ">", ">=":
+		if !compare {
+			return num, fmt.Errorf("Operator %q is not valid in a range or set.", num.Operator)
+		}
+	default:
+		return num, fmt.Errorf("Operator %q is not valid in a source Rule context.", num.Operator)
 	}
 
-	if err := validToChannel(r.G); err != nil {
-		return err
-	}
+	// Percentages are relative to the range of the channel.
+	if num.Percentage {
+		if math.IsInf(cr.max, 1) {
+			return num, fmt.Errorf("Percentages are not valid for a %s.", cr.name)
+		}
+
+		if num.Value < 0 || num.Value > 100 {
+			return num, fmt.Errorf("Percentage %g%% is outside of the range [0, 100]", num.Value)
+		}
 
-	if err := validToChannel(r.B); err != nil {
-		return err
+		num.Value = cr.min + num.Value*0.01*(cr.max-cr.min)
+		num.Percentage = false
 	}
 
-	return validToChannel(r.A)
+	return num, cr.check(num.Value)
 }
 
-func validToChannel(c Channel) error {
+// validTo returns an error if the given Rule contains values
+// which make no sense in this context.
+func validTo(s Space, r *Rule) error {
+	for i, c := range r.channels() {
+		if err := validToChannel(*c, &ranges[s][i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validToChannel(c Channel, cr *channelRange) error {
+	switch c.(type) {
+	case Range, Set, Not:
+		return fmt.Errorf("Conditions are not valid in a destination Rule context.")
+	}
+
 	num, ok := c.(Number)
-	if ok {
-		switch num.Operator {
-		case "", "+", "-":
-		default:
-			return fmt.Errorf("Operator %q is not valid in a destination Rule context.", num.Operator)
+	if !ok {
+		// wildcard, named reference & expression
+		return nil
+	}
+
+	if num.Percentage || num.Operator == "+" || num.Operator == "-" {
+		if num.Value < 0 {
+			return fmt.Errorf("Invalid channel value: %g", num.Value)
+		}
+	}
+
+	switch num.Operator {
+	case "", "=":
+		if !num.Percentage {
+			return cr.check(num.Value)
+		}
+	case "+", "-":
+		if !num.Percentage && num.Value > cr.max-cr.min {
+			return fmt.Errorf("Value %g exceeds the %s range [%g, %g]", num.Value, cr.name, cr.min, cr.max)
 		}
+	default:
+		return fmt.Errorf("Operator %q is not valid in a destination Rule context.", num.Operator)
 	}
 
-	// wildcard & named reference
 	return nil
 }
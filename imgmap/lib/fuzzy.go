@@ -0,0 +1,276 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package lib
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Metric identifies the distance function used by fuzzy matching.
+type Metric uint8
+
+// Known metrics.
+const (
+	MetricDE2000   Metric = iota // CIE ΔE2000, in Lab units.
+	MetricLab                    // CIE ΔE76: The euclidean distance in Lab.
+	MetricRGB                    // The euclidean distance in RGB: 0-441.
+	MetricWeighted               // The "redmean" weighted distance in RGB: 0-765.
+)
+
+var metricNames = [...]string{"de2000", "lab", "rgb", "weighted"}
+
+func (m Metric) String() string { return metricNames[m] }
+
+// point converts an RGB color with channels in the range [0, 255] to the
+// space the metric measures distances in: Lab or RGB.
+func (m Metric) point(r, g, b float64) [3]float64 {
+	if m == MetricRGB || m == MetricWeighted {
+		return [3]float64{r, g, b}
+	}
+
+	var c [3]float64
+	c[0], c[1], c[2] = rgbToLab(r/255, g/255, b/255)
+	return c
+}
+
+// distance returns the distance between two points, as returned by point.
+func (m Metric) distance(a, b [3]float64) float64 {
+	switch m {
+	case MetricDE2000:
+		return deltaE2000(a, b)
+
+	case MetricWeighted:
+		// Weighs the channels by how sensitive we are to them, which
+		// depends on the amount of red. This is a cheap approximation
+		// of a perceptual distance.
+		rmean := (a[0] + b[0]) / 2
+		return math.Sqrt((2+rmean/256)*sq(a[0]-b[0]) + 4*sq(a[1]-b[1]) + (2+(255-rmean)/256)*sq(a[2]-b[2]))
+	}
+
+	return math.Sqrt(sq(a[0]-b[0]) + sq(a[1]-b[1]) + sq(a[2]-b[2]))
+}
+
+// fuzzy matches pixels within a given distance of a reference color.
+type fuzzy struct {
+	metric    Metric
+	ref       [3]float64 // Reference color, as a point of the metric.
+	max       float64    // Largest distance which matches.
+	inclusive bool       // Whether max itself matches.
+	soft      float64    // Distance from which the change fades out, or -1.
+}
+
+// parseFuzzy parses a fuzzy source color of the form:
+//
+//	~#rrggbb dist<N [metric=de2000|lab|rgb|weighted] [soft[=N]]
+//
+// The distance is required. The other options may be given in any order.
+func parseFuzzy(list []string) (*fuzzy, error) {
+	f := fuzzy{soft: -1}
+
+	r, g, b, err := parseHexColor(list[0][1:])
+	if err != nil {
+		return nil, err
+	}
+
+	var haveDist bool
+	for _, v := range list[1:] {
+		switch {
+		case strings.HasPrefix(v, "dist<"):
+			v = v[5:]
+			if f.inclusive = strings.HasPrefix(v, "="); f.inclusive {
+				v = v[1:]
+			}
+
+			f.max, err = strconv.ParseFloat(v, 64)
+			if err != nil || f.max <= 0 {
+				return nil, fmt.Errorf("Invalid distance: %s", v)
+			}
+			haveDist = true
+
+		case strings.HasPrefix(v, "metric="):
+			f.metric, err = ParseMetric(v[7:])
+			if err != nil {
+				return nil, err
+			}
+
+		case v == "soft":
+			f.soft = 0
+
+		case strings.HasPrefix(v, "soft="):
+			f.soft, err = strconv.ParseFloat(v[5:], 64)
+			if err != nil || f.soft < 0 {
+				return nil, fmt.Errorf("Invalid soft distance: %s", v[5:])
+			}
+
+		default:
+			return nil, fmt.Errorf("Invalid fuzzy match option %q; expected dist<N, metric=M or soft[=N]", v)
+		}
+	}
+
+	if !haveDist {
+		return nil, fmt.Errorf("Missing distance for fuzzy color %s; expected dist<N", list[0])
+	}
+
+	if f.soft >= f.max {
+		return nil, fmt.Errorf("Soft distance %g must be less than the distance %g", f.soft, f.max)
+	}
+
+	f.ref = f.metric.point(r, g, b)
+	return &f, nil
+}
+
+// ParseMetric returns the metric with the given name:
+// de2000, lab, rgb or weighted.
+func ParseMetric(name string) (Metric, error) {
+	for i, v := range metricNames {
+		if v == name {
+			return Metric(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown metric %q; expected one of de2000, lab, rgb or weighted", name)
+}
+
+// parseHexColor parses a color of the form "#rrggbb" or "#rgb".
+func parseHexColor(data string) (r, g, b float64, err error) {
+	if len(data) == 4 && data[0] == '#' {
+		data = string([]byte{'#', data[1], data[1], data[2], data[2], data[3], data[3]})
+	}
+
+	if len(data) != 7 || data[0] != '#' {
+		return 0, 0, 0, fmt.Errorf("Invalid color %q; expected #rrggbb", data)
+	}
+
+	n, err := strconv.ParseUint(data[1:], 16, 32)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("Invalid color %q; expected #rrggbb", data)
+	}
+
+	return float64(n >> 16), float64(n >> 8 & 0xff), float64(n & 0xff), nil
+}
+
+// weight returns the strength with which the rule applies to the pixel.
+// This is 1, or less if the pixel is in the soft edge of the match.
+// It returns false if the pixel does not match. Fully transparent
+// pixels have no color, and never match.
+func (f *fuzzy) weight(pix *pixel) (float64, bool) {
+	if pix.a == 0 {
+		return 0, false
+	}
+
+	r, g, b := pix.unpremultiply()
+	d := f.metric.distance(f.ref, f.metric.point(255*r, 255*g, 255*b))
+
+	if d > f.max || d == f.max && !f.inclusive {
+		return 0, false
+	}
+
+	if f.soft < 0 || d <= f.soft {
+		return 1, true
+	}
+
+	return (f.max - d) / (f.max - f.soft), true
+}
+
+// blend mixes the pixel with its original color orig. A weight
+// of 1 keeps the pixel as-is, a weight of 0 restores orig.
+func (pix *pixel) blend(orig *pixel, w float64) {
+	mix := func(a, b uint8) uint8 {
+		return uint8(float64(a) + w*(float64(b)-float64(a)) + 0.5)
+	}
+
+	pix.r = mix(orig.r, pix.r)
+	pix.g = mix(orig.g, pix.g)
+	pix.b = mix(orig.b, pix.b)
+	pix.a = mix(orig.a, pix.a)
+}
+
+// deltaE2000 returns the CIE ΔE2000 color difference between two
+// colors in Lab. This follows "The CIEDE2000 Color-Difference Formula:
+// Implementation Notes, Supplementary Test Data, and Mathematical
+// Observations", by G. Sharma, W. Wu and E. N. Dalal.
+func deltaE2000(lab1, lab2 [3]float64) float64 {
+	l1, a1, b1 := lab1[0], lab1[1], lab1[2]
+	l2, a2, b2 := lab2[0], lab2[1], lab2[2]
+
+	const pow25_7 = 6103515625 // 25^7
+
+	cbar := (math.Hypot(a1, b1) + math.Hypot(a2, b2)) / 2
+	cbar7 := math.Pow(cbar, 7)
+	g := 0.5 * (1 - math.Sqrt(cbar7/(cbar7+pow25_7)))
+
+	a1 *= 1 + g
+	a2 *= 1 + g
+
+	c1, c2 := math.Hypot(a1, b1), math.Hypot(a2, b2)
+	h1, h2 := hueAngle(a1, b1), hueAngle(a2, b2)
+
+	dl := l2 - l1
+	dc := c2 - c1
+
+	var dh float64
+	if c1*c2 != 0 {
+		dh = h2 - h1
+		if dh > 180 {
+			dh -= 360
+		} else if dh < -180 {
+			dh += 360
+		}
+	}
+	dhh := 2 * math.Sqrt(c1*c2) * math.Sin(rad(dh/2))
+
+	lbar := (l1 + l2) / 2
+	cbar = (c1 + c2) / 2
+
+	hbar := h1 + h2
+	if c1*c2 != 0 {
+		switch {
+		case math.Abs(h1-h2) <= 180:
+			hbar /= 2
+		case hbar < 360:
+			hbar = (hbar + 360) / 2
+		default:
+			hbar = (hbar - 360) / 2
+		}
+	}
+
+	t := 1 - 0.17*math.Cos(rad(hbar-30)) +
+		0.24*math.Cos(rad(2*hbar)) +
+		0.32*math.Cos(rad(3*hbar+6)) -
+		0.20*math.Cos(rad(4*hbar-63))
+
+	dtheta := 30 * math.Exp(-sq((hbar-275)/25))
+	cbar7 = math.Pow(cbar, 7)
+	rc := 2 * math.Sqrt(cbar7/(cbar7+pow25_7))
+
+	sl := 1 + 0.015*sq(lbar-50)/math.Sqrt(20+sq(lbar-50))
+	sc := 1 + 0.045*cbar
+	sh := 1 + 0.015*cbar*t
+	rt := -math.Sin(rad(2*dtheta)) * rc
+
+	dl /= sl
+	dc /= sc
+	dhh /= sh
+
+	return math.Sqrt(dl*dl + dc*dc + dhh*dhh + rt*dc*dhh)
+}
+
+// hueAngle returns the angle of (a, b) in degrees, in the range [0, 360).
+func hueAngle(a, b float64) float64 {
+	if a == 0 && b == 0 {
+		return 0
+	}
+
+	h := math.Atan2(b, a) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+func rad(deg float64) float64 { return deg * math.Pi / 180 }
+
+func sq(v float64) float64 { return v * v }
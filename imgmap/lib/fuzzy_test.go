@@ -0,0 +1,129 @@
+package lib
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func Test_DeltaE2000(t *testing.T) {
+	// Test data from Sharma, Wu and Dalal.
+	tests := []struct {
+		a, b [3]float64
+		want float64
+	}{
+		{[3]float64{50, 2.6772, -79.7751}, [3]float64{50, 0, -82.7485}, 2.0425},
+		{[3]float64{50, -1.3802, -84.2814}, [3]float64{50, 0, -82.7485}, 1.0000},
+		{[3]float64{50, 0, 0}, [3]float64{50, -1, 2}, 2.3669},
+		{[3]float64{50, 2.49, -0.001}, [3]float64{50, -2.49, 0.0011}, 7.2195},
+		{[3]float64{50, 2.5, 0}, [3]float64{73, 25, -18}, 27.1492},
+		{[3]float64{60.2574, -34.0099, 36.2677}, [3]float64{60.4626, -34.1751, 39.4387}, 1.2644},
+		{[3]float64{22.7233, 20.0904, -46.694}, [3]float64{23.0331, 14.973, -42.5619}, 2.0373},
+		{[3]float64{90.9257, -0.5406, -0.9208}, [3]float64{88.6381, -0.8985, -0.7239}, 1.5381},
+	}
+
+	for _, tt := range tests {
+		got := deltaE2000(tt.a, tt.b)
+		if math.Abs(got-tt.want) > 0.0001 {
+			t.Errorf("%v, %v: %.4f; want %.4f", tt.a, tt.b, got, tt.want)
+		}
+
+		if back := deltaE2000(tt.b, tt.a); math.Abs(back-got) > 1e-9 {
+			t.Errorf("%v, %v: not symmetric: %v, %v", tt.a, tt.b, got, back)
+		}
+	}
+}
+
+func Test_FuzzyMatch(t *testing.T) {
+	colors := []color.RGBA{
+		{255, 153, 0, 255}, // Exact match.
+		{250, 150, 10, 255},
+		{240, 140, 20, 255},
+		{255, 0, 0, 255},
+		{0, 0, 0, 255},
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, len(colors), 1))
+	for x, c := range colors {
+		img.SetRGBA(x, 0, c)
+	}
+
+	tests := []struct {
+		expr string
+		want []uint8 // Resulting red channels.
+	}{
+		{"~#ff9900 dist<3  0 ? ? ?", []uint8{0, 0, 240, 255, 0}},
+		{"~#ff9900 dist<6  0 ? ? ?", []uint8{0, 0, 0, 255, 0}},
+		{"~#ff9900 dist<12 metric=rgb  0 ? ? ?", []uint8{0, 0, 240, 255, 0}},
+		{"~#ff9900 metric=lab dist<=5  0 ? ? ?", []uint8{0, 0, 240, 255, 0}},
+		{"hsl ~#f90 dist<3  ? ? 0 ?", []uint8{0, 0, 240, 255, 0}},
+		{"~#000 dist<1  100 ? ? ?  if #g=0", []uint8{255, 250, 240, 255, 100}},
+	}
+
+	for _, tt := range tests {
+		out := compile(t, tt.expr).Run(img)
+		for x, want := range tt.want {
+			if got := out.RGBAAt(x, 0).R; got != want {
+				t.Errorf("%q: pixel %d: red is %d; want %d", tt.expr, x, got, want)
+			}
+		}
+	}
+}
+
+func Test_FuzzySoft(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 1))
+	for x := 0; x < 256; x++ {
+		img.SetRGBA(x, 0, color.RGBA{uint8(x), 0, 0, 255})
+	}
+
+	out := compile(t, "~#000 dist<100 metric=rgb soft=20  ? 255 ? ?").Run(img)
+
+	for x := 0; x < 256; x++ {
+		want := 0.0
+		switch {
+		case x <= 20:
+			want = 255
+		case x < 100:
+			want = 255 * float64(100-x) / 80
+		}
+
+		got := out.RGBAAt(x, 0)
+		if math.Abs(float64(got.G)-want) > 1 || got.R != uint8(x) {
+			t.Fatalf("pixel %d: %v; want green %.1f", x, got, want)
+		}
+	}
+}
+
+func Test_FuzzyTransparent(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.SetRGBA(1, 0, color.RGBA{0, 0, 0, 255})
+
+	out := compile(t, "~#000000 dist<5  255 0 0 255").Run(img)
+
+	if got, want := out.RGBAAt(0, 0), (color.RGBA{}); got != want {
+		t.Errorf("Transparent pixel is %v; want %v", got, want)
+	}
+
+	if got, want := out.RGBAAt(1, 0), (color.RGBA{255, 0, 0, 255}); got != want {
+		t.Errorf("Black pixel is %v; want %v", got, want)
+	}
+}
+
+func Test_FuzzyErrors(t *testing.T) {
+	for _, expr := range []string{
+		"~#ff9900  0 0 0 ?",
+		"~#ff99 dist<5  0 0 0 ?",
+		"~#gg9900 dist<5  0 0 0 ?",
+		"~#ff9900 dist<0  0 0 0 ?",
+		"~#ff9900 dist<x  0 0 0 ?",
+		"~#ff9900 dist<5 metric=xyz  0 0 0 ?",
+		"~#ff9900 dist<5 soft=5  0 0 0 ?",
+		"~#ff9900 dist<5 fuzzy  0 0 0 ?",
+		"~#ff9900 dist<5  0 0 0",
+	} {
+		if _, err := parseLine([]byte(expr)); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
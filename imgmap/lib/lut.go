@@ -0,0 +1,436 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	imglib "github.com/jteeuwen/imgtools/lib"
+	"image"
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LUT is a color lookup table, as used for color grading.
+//
+// A 1D table holds a curve for each channel. A 3D table maps each color
+// onto a new one, using a grid of Size x Size x Size samples. Colors in
+// between the samples are interpolated.
+type LUT struct {
+	Title string
+	Dim   int // 1 or 3.
+	Size  int // Number of samples along each dimension.
+
+	// The input values which map onto the first and last samples.
+	// These default to 0 and 1.
+	DomainMin, DomainMax [3]float64
+
+	// The samples, with channels in the range [0, 1]. 1D tables have Size
+	// entries. 3D tables have Size^3 entries, with red changing fastest,
+	// then green, then blue.
+	Table [][3]float64
+}
+
+// Interpolation selects how colors in between the samples
+// of a 3D LUT are computed.
+type Interpolation uint8
+
+// Known interpolation methods.
+const (
+	// Tetrahedral interpolates between the 4 samples of the tetrahedron
+	// in the grid cell which contains the color. This is more accurate
+	// than trilinear interpolation along the gray axis, and faster.
+	Tetrahedral Interpolation = iota
+
+	// Trilinear interpolates between all 8 samples of the grid cell
+	// which contains the color.
+	Trilinear
+)
+
+var interpolationNames = [...]string{"tetrahedral", "trilinear"}
+
+func (i Interpolation) String() string { return interpolationNames[i] }
+
+// ParseInterpolation returns the interpolation with the given
+// name: tetrahedral or trilinear.
+func ParseInterpolation(name string) (Interpolation, error) {
+	for i, v := range interpolationNames {
+		if v == name {
+			return Interpolation(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown interpolation %q; expected tetrahedral or trilinear", name)
+}
+
+// Limits on the sizes of LUTs, as given by the .cube specification.
+const (
+	max1DSize = 65536
+	max3DSize = 256
+)
+
+// LoadCube reads a LUT in the .cube format, as written by Adobe software
+// and DaVinci Resolve. It holds either a 1D or a 3D table.
+func LoadCube(r io.Reader) (*LUT, error) {
+	l := &LUT{DomainMax: [3]float64{1, 1, 1}}
+	var size1, size3 int
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || text[0] == '#' {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		var err error
+
+		switch fields[0] {
+		case "TITLE":
+			l.Title = strings.Trim(strings.TrimSpace(text[5:]), `"`)
+
+		case "LUT_1D_SIZE":
+			size1, err = parseSize(fields, max1DSize)
+
+		case "LUT_3D_SIZE":
+			size3, err = parseSize(fields, max3DSize)
+
+		case "DOMAIN_MIN":
+			l.DomainMin, err = parseTriple(fields[1:])
+
+		case "DOMAIN_MAX":
+			l.DomainMax, err = parseTriple(fields[1:])
+
+		case "LUT_1D_INPUT_RANGE", "LUT_3D_INPUT_RANGE":
+			// Resolve's variant of the domain, with one range for all channels.
+			var v [3]float64
+			if v, err = parseTriple(append(fields[1:], "0")); err == nil {
+				l.DomainMin = [3]float64{v[0], v[0], v[0]}
+				l.DomainMax = [3]float64{v[1], v[1], v[1]}
+			}
+
+		default:
+			var v [3]float64
+			if v, err = parseTriple(fields); err == nil {
+				l.Table = append(l.Table, v)
+			}
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %v", line, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Read LUT: %v", err)
+	}
+
+	switch {
+	case size1 > 0 && size3 > 0:
+		return nil, fmt.Errorf("LUTs with both a 1D and a 3D table are not supported")
+	case size1 > 0:
+		l.Dim, l.Size = 1, size1
+	case size3 > 0:
+		l.Dim, l.Size = 3, size3
+	default:
+		return nil, fmt.Errorf("Missing LUT_1D_SIZE or LUT_3D_SIZE")
+	}
+
+	if want := l.entries(); len(l.Table) != want {
+		return nil, fmt.Errorf("LUT has %d entries; expected %d", len(l.Table), want)
+	}
+
+	for i := range l.DomainMin {
+		if !(l.DomainMin[i] < l.DomainMax[i]) {
+			return nil, fmt.Errorf("Invalid LUT domain: %g to %g", l.DomainMin[i], l.DomainMax[i])
+		}
+	}
+
+	return l, nil
+}
+
+// LUTFromHald returns the 3D LUT stored in a Hald CLUT image. An image of
+// level L is a square of L^3 x L^3 pixels, which holds a LUT of L^2 samples
+// along each dimension. Applying a LUT to the identity Hald image, and
+// loading the result, yields the same LUT.
+func LUTFromHald(img image.Image) (*LUT, error) {
+	b := img.Bounds()
+
+	level := int(math.Cbrt(float64(b.Dx())) + 0.5)
+	if b.Dx() != b.Dy() || level*level*level != b.Dx() || level < 2 {
+		return nil, fmt.Errorf("Invalid Hald CLUT size %dx%d; expected a square of L^3 pixels", b.Dx(), b.Dy())
+	}
+
+	l := &LUT{
+		Title:     fmt.Sprintf("Hald CLUT level %d", level),
+		Dim:       3,
+		Size:      level * level,
+		DomainMax: [3]float64{1, 1, 1},
+	}
+
+	l.Table = make([][3]float64, 0, l.entries())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bb, _ := img.At(x, y).RGBA()
+			l.Table = append(l.Table, [3]float64{
+				float64(r) / 0xffff, float64(g) / 0xffff, float64(bb) / 0xffff,
+			})
+		}
+	}
+
+	return l, nil
+}
+
+// LoadLUTFile loads a LUT from the given file. This is either a .cube file,
+// or a Hald CLUT image.
+func LoadLUTFile(file string) (*LUT, error) {
+	data, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+
+	if img, _, err := imglib.Decode(bytes.NewReader(data)); err == nil {
+		return LUTFromHald(img)
+	}
+
+	l, err := LoadCube(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	return l, nil
+}
+
+// parseSize parses the size of a LUT table.
+func parseSize(fields []string, max int) (int, error) {
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("Invalid %s", fields[0])
+	}
+
+	n, err := strconv.Atoi(fields[1])
+	if err != nil || n < 2 || n > max {
+		return 0, fmt.Errorf("Invalid %s: %s; expected 2-%d", fields[0], fields[1], max)
+	}
+
+	return n, nil
+}
+
+// parseTriple parses three floating point values.
+func parseTriple(fields []string) ([3]float64, error) {
+	var v [3]float64
+
+	if len(fields) != 3 {
+		return v, fmt.Errorf("Invalid LUT line: %q; expected 3 values", strings.Join(fields, " "))
+	}
+
+	for i, f := range fields {
+		n, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return v, fmt.Errorf("Invalid LUT value: %q", f)
+		}
+		v[i] = n
+	}
+
+	return v, nil
+}
+
+// entries returns the number of samples in the table.
+func (l *LUT) entries() int {
+	if l.Dim == 1 {
+		return l.Size
+	}
+	return l.Size * l.Size * l.Size
+}
+
+// Lookup returns the color the LUT maps the given color onto.
+// The channels are in the range [0, 1], and the result is clipped
+// to that range.
+func (l *LUT) Lookup(c [3]float64, interp Interpolation) [3]float64 {
+	// Map the color onto sample coordinates.
+	n := float64(l.Size - 1)
+	for i := range c {
+		v := (c[i] - l.DomainMin[i]) / (l.DomainMax[i] - l.DomainMin[i])
+		c[i] = math.Max(0, math.Min(n, v*n))
+	}
+
+	var out [3]float64
+	switch {
+	case l.Dim == 1:
+		out = l.lookup1D(c)
+	case interp == Trilinear:
+		out = l.trilinear(c)
+	default:
+		out = l.tetrahedral(c)
+	}
+
+	for i := range out {
+		out[i] = math.Max(0, math.Min(1, out[i]))
+	}
+
+	return out
+}
+
+// cell splits sample coordinate v into the index of the sample before it,
+// and the fraction of the way to the next one.
+func (l *LUT) cell(v float64) (int, float64) {
+	i := int(v)
+	if i >= l.Size-1 {
+		i = l.Size - 2
+	}
+	return i, v - float64(i)
+}
+
+// lookup1D interpolates each channel between two samples of its curve.
+func (l *LUT) lookup1D(c [3]float64) [3]float64 {
+	var out [3]float64
+	for ch := range c {
+		i, f := l.cell(c[ch])
+		out[ch] = l.Table[i][ch] + f*(l.Table[i+1][ch]-l.Table[i][ch])
+	}
+	return out
+}
+
+// corners returns the samples at the 8 corners of the grid cell containing
+// c, and the position of c within it. Corner i is offset by bit 0 of i in
+// red, by bit 1 in green and by bit 2 in blue.
+func (l *LUT) corners(c [3]float64) (s [8][3]float64, fr, fg, fb float64) {
+	r, fr := l.cell(c[0])
+	g, fg := l.cell(c[1])
+	b, fb := l.cell(c[2])
+
+	n := l.Size
+	base := r + n*(g+n*b)
+	s[0] = l.Table[base]
+	s[1] = l.Table[base+1]
+	s[2] = l.Table[base+n]
+	s[3] = l.Table[base+n+1]
+	s[4] = l.Table[base+n*n]
+	s[5] = l.Table[base+n*n+1]
+	s[6] = l.Table[base+n*n+n]
+	s[7] = l.Table[base+n*n+n+1]
+	return
+}
+
+// trilinear interpolates between all corners of the cell containing c.
+func (l *LUT) trilinear(c [3]float64) [3]float64 {
+	s, fr, fg, fb := l.corners(c)
+
+	var out [3]float64
+	for ch := range out {
+		c00 := s[0][ch] + fr*(s[1][ch]-s[0][ch])
+		c10 := s[2][ch] + fr*(s[3][ch]-s[2][ch])
+		c01 := s[4][ch] + fr*(s[5][ch]-s[4][ch])
+		c11 := s[6][ch] + fr*(s[7][ch]-s[6][ch])
+		c0 := c00 + fg*(c10-c00)
+		c1 := c01 + fg*(c11-c01)
+		out[ch] = c0 + fb*(c1-c0)
+	}
+	return out
+}
+
+// tetrahedral interpolates between the corners of the tetrahedron
+// containing c. The cell is split into 6 tetrahedra, which all share
+// the diagonal from the first to the last corner.
+func (l *LUT) tetrahedral(c [3]float64) [3]float64 {
+	s, fr, fg, fb := l.corners(c)
+
+	// The two corners in between, and the weights of all four.
+	var a, b int
+	var w0, w1, w2, w3 float64
+
+	switch {
+	case fr > fg && fg > fb:
+		a, b = 1, 3
+		w0, w1, w2, w3 = 1-fr, fr-fg, fg-fb, fb
+	case fr > fg && fr > fb:
+		a, b = 1, 5
+		w0, w1, w2, w3 = 1-fr, fr-fb, fb-fg, fg
+	case fr > fg:
+		a, b = 4, 5
+		w0, w1, w2, w3 = 1-fb, fb-fr, fr-fg, fg
+	case fb > fg:
+		a, b = 4, 6
+		w0, w1, w2, w3 = 1-fb, fb-fg, fg-fr, fr
+	case fb > fr:
+		a, b = 2, 6
+		w0, w1, w2, w3 = 1-fg, fg-fb, fb-fr, fr
+	default:
+		a, b = 2, 3
+		w0, w1, w2, w3 = 1-fg, fg-fr, fr-fb, fb
+	}
+
+	var out [3]float64
+	for ch := range out {
+		out[ch] = w0*s[0][ch] + w1*s[a][ch] + w2*s[b][ch] + w3*s[7][ch]
+	}
+	return out
+}
+
+// lutStage is a stage which applies a LUT to every pixel.
+// Alpha is left as-is.
+type lutStage struct {
+	lut    *LUT
+	interp Interpolation
+}
+
+// AddLUT appends a LUT to the program, which is applied to every pixel
+// with the given interpolation.
+func (p *Program) AddLUT(l *LUT, interp Interpolation) {
+	p.add(&lutStage{l, interp})
+}
+
+// parseLUT parses a LUT in a map file, of the form:
+//
+//	lut <file> [tetrahedral|trilinear]
+func parseLUT(args []string) (stage, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return nil, fmt.Errorf("Invalid LUT; expected: lut <file> [tetrahedral|trilinear]")
+	}
+
+	l, err := LoadLUTFile(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	st := &lutStage{lut: l}
+	if len(args) > 1 {
+		st.interp, err = ParseInterpolation(args[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return st, nil
+}
+
+func (st *lutStage) len() int       { return 1 }
+func (st *lutStage) usesMask() bool { return false }
+
+func (st *lutStage) run(img *image.RGBA, f *frame) {
+	b := img.Rect
+
+	parallel(b.Dy(), func(min, max int) {
+		for y := b.Min.Y + min; y < b.Min.Y+max; y++ {
+			row := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+
+			for i := 0; i < len(row); i += 4 {
+				pix := pixel{r: row[i], g: row[i+1], b: row[i+2], a: row[i+3]}
+				if pix.a == 0 {
+					continue
+				}
+
+				r, g, bb := pix.unpremultiply()
+				c := st.lut.Lookup([3]float64{r, g, bb}, st.interp)
+
+				row[i+0] = premultiply(c[0], pix.a)
+				row[i+1] = premultiply(c[1], pix.a)
+				row[i+2] = premultiply(c[2], pix.a)
+			}
+		}
+	})
+}
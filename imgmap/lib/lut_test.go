@@ -0,0 +1,266 @@
+package lib
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// cube returns a 3D .cube file of the given size, with each sample
+// computed by fn from its coordinates in the range [0, 1].
+func cube(size int, fn func(r, g, b float64) [3]float64) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "TITLE \"test\"\n# comment\nLUT_3D_SIZE %d\n\n", size)
+
+	n := float64(size - 1)
+	for b := 0; b < size; b++ {
+		for g := 0; g < size; g++ {
+			for r := 0; r < size; r++ {
+				c := fn(float64(r)/n, float64(g)/n, float64(b)/n)
+				fmt.Fprintf(&sb, "%f %f %f\n", c[0], c[1], c[2])
+			}
+		}
+	}
+
+	return sb.String()
+}
+
+func Test_LoadCube(t *testing.T) {
+	l, err := LoadCube(strings.NewReader(cube(3, func(r, g, b float64) [3]float64 {
+		return [3]float64{b, g, r}
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l.Title != "test" || l.Dim != 3 || l.Size != 3 || len(l.Table) != 27 {
+		t.Fatalf("Got %q, %dD, size %d, %d entries", l.Title, l.Dim, l.Size, len(l.Table))
+	}
+
+	// Red changes fastest.
+	if l.Table[1] != [3]float64{0, 0, 0.5} || l.Table[9] != [3]float64{0.5, 0, 0} {
+		t.Fatalf("Entries 1 and 9 are %v and %v", l.Table[1], l.Table[9])
+	}
+
+	l, err = LoadCube(strings.NewReader("LUT_1D_SIZE 2\nDOMAIN_MIN 0 0 0\nDOMAIN_MAX 2 1 1\n1 1 1\n0 0 0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [3]float64{0.5, 0.25, 0.75}
+	if got := l.Lookup([3]float64{1, 0.75, 0.25}, Tetrahedral); !nearTriple(got, want, 1e-9) {
+		t.Fatalf("1D lookup yields %v; want %v", got, want)
+	}
+
+	for _, src := range []string{
+		"",
+		"1 1 1\n",
+		"LUT_3D_SIZE 2\n0 0 0\n",
+		"LUT_3D_SIZE 1\n0 0 0\n",
+		"LUT_1D_SIZE 2\n0 0 0\n1 1\n",
+		"LUT_1D_SIZE 2\n0 0 0\n1 1 x\n",
+		"LUT_1D_SIZE 2\nDOMAIN_MIN 1 1 1\nDOMAIN_MAX 0 0 0\n0 0 0\n1 1 1\n",
+		"LUT_1D_SIZE 2\nLUT_3D_SIZE 2\n0 0 0\n1 1 1\n",
+	} {
+		if _, err := LoadCube(strings.NewReader(src)); err == nil {
+			t.Errorf("%q: expected an error", src)
+		}
+	}
+}
+
+func Test_LUTInterpolation(t *testing.T) {
+	// A curve in each channel, plus some crosstalk.
+	fn := func(r, g, b float64) [3]float64 {
+		return [3]float64{r * r, math.Sqrt(g), 0.5*b + 0.25*r}
+	}
+
+	l, err := LoadCube(strings.NewReader(cube(5, fn)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, interp := range []Interpolation{Tetrahedral, Trilinear} {
+		// Samples are exact.
+		for _, c := range [][3]float64{{0, 0, 0}, {1, 1, 1}, {0.25, 0.5, 0.75}, {1, 0, 0.5}} {
+			if got, want := l.Lookup(c, interp), fn(c[0], c[1], c[2]); !nearTriple(got, want, 1e-6) {
+				t.Errorf("%v: %v yields %v; want %v", interp, c, got, want)
+			}
+		}
+
+		// Colors in between are close.
+		for _, c := range [][3]float64{{0.1, 0.2, 0.3}, {0.9, 0.6, 0.4}, {0.55, 0.55, 0.55}} {
+			if got, want := l.Lookup(c, interp), fn(c[0], c[1], c[2]); !nearTriple(got, want, 0.05) {
+				t.Errorf("%v: %v yields %v; want about %v", interp, c, got, want)
+			}
+		}
+	}
+
+	// Both are exact for a LUT which is linear, like swapping channels.
+	swap, _ := LoadCube(strings.NewReader(cube(2, func(r, g, b float64) [3]float64 {
+		return [3]float64{g, b, r}
+	})))
+
+	for _, interp := range []Interpolation{Tetrahedral, Trilinear} {
+		for _, c := range [][3]float64{{0.1, 0.2, 0.3}, {0.9, 0.6, 0.4}, {0.3, 0.8, 0.3}} {
+			if got, want := swap.Lookup(c, interp), [3]float64{c[1], c[2], c[0]}; !nearTriple(got, want, 1e-9) {
+				t.Errorf("%v: %v yields %v; want %v", interp, c, got, want)
+			}
+		}
+	}
+}
+
+func Test_LUTFromHald(t *testing.T) {
+	// The identity Hald CLUT of level 2: 8x8 pixels, 4 samples per axis.
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := 0; i < 64; i++ {
+		img.SetRGBA(i%8, i/8, color.RGBA{uint8(i % 4 * 85), uint8(i / 4 % 4 * 85), uint8(i / 16 * 85), 255})
+	}
+
+	l, err := LUTFromHald(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l.Dim != 3 || l.Size != 4 {
+		t.Fatalf("Got a %dD LUT of size %d; want 3D, size 4", l.Dim, l.Size)
+	}
+
+	var p Program
+	p.AddLUT(l, Tetrahedral)
+
+	src := testImage()
+	out := p.Run(src)
+
+	b := src.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			want := color.RGBAModel.Convert(src.At(x, y)).(color.RGBA)
+			got := out.RGBAAt(x, y)
+
+			if !near(got.R, want.R, 1) || !near(got.G, want.G, 1) || !near(got.B, want.B, 1) || got.A != want.A {
+				t.Fatalf("Pixel %d,%d is %v; want %v", x, y, got, want)
+			}
+		}
+	}
+
+	if _, err := LUTFromHald(image.NewRGBA(image.Rect(0, 0, 8, 9))); err == nil {
+		t.Fatalf("Expected an error for a non-square image")
+	}
+}
+
+func Test_LUTDirective(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imgmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Inverts all colors.
+	file := filepath.Join(dir, "invert.cube")
+	if err := ioutil.WriteFile(file, []byte("LUT_1D_SIZE 2\n1 1 1\n0 0 0\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A 1x1 Hald image is not a valid LUT, and must not be
+	// read as a .cube file either.
+	hald := filepath.Join(dir, "hald.png")
+	fd, err := os.Create(hald)
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(fd, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+	fd.Close()
+
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	img.SetRGBA(0, 0, color.RGBA{200, 100, 0, 255})
+
+	p := compile(t, "? ? ? ?  ? ? 50 ?\nlut "+file+" trilinear\n55 ? ? ?  ? 0 ? ?")
+	out := p.Run(img)
+
+	if got, want := out.RGBAAt(0, 0), (color.RGBA{55, 0, 205, 255}); got != want {
+		t.Fatalf("Pixel is %v; want %v", got, want)
+	}
+
+	for _, src := range []string{
+		"lut",
+		"lut " + filepath.Join(dir, "missing.cube"),
+		"lut " + file + " nearest",
+		"lut " + file + " trilinear x",
+		"lut " + hald,
+	} {
+		if _, err := Compile(strings.NewReader(src)); err == nil {
+			t.Errorf("%q: expected an error", src)
+		}
+	}
+}
+
+func Test_LUTFileNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imgmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	grades := filepath.Join(dir, "grades")
+	if err := os.Mkdir(grades, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// Inverts all colors.
+	for _, name := range []string{"invert,1.cube", "film grade;2.cube"} {
+		if err := ioutil.WriteFile(filepath.Join(grades, name), []byte("LUT_1D_SIZE 2\n1 1 1\n0 0 0\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	img.SetRGBA(0, 0, color.RGBA{200, 100, 0, 255})
+
+	for _, src := range []string{
+		"lut invert,1.cube trilinear",
+		"lut invert,1.cube ; a comment",
+		"\tlut \"film grade;2.cube\" tetrahedral",
+		"lut \"" + filepath.Join(grades, "invert,1.cube") + "\"",
+	} {
+		// Relative names are relative to the map file.
+		file := filepath.Join(grades, "film.map")
+		if err := ioutil.WriteFile(file, []byte(src+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := CompileFile(file)
+		if err != nil {
+			t.Errorf("%q: %v", src, err)
+			continue
+		}
+
+		if got, want := p.Run(img).RGBAAt(0, 0), (color.RGBA{55, 155, 255, 255}); got != want {
+			t.Errorf("%q: pixel is %v; want %v", src, got, want)
+		}
+
+		// Compile resolves them against the current directory.
+		if _, err := Compile(strings.NewReader(src)); err == nil && !strings.Contains(src, dir) {
+			t.Errorf("%q: expected an error for a name relative to the current directory", src)
+		}
+	}
+}
+
+func nearTriple(a, b [3]float64, tolerance float64) bool {
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > tolerance {
+			return false
+		}
+	}
+	return true
+}
+
+func near(a, b uint8, tolerance int) bool {
+	return int(a)-int(b) <= tolerance && int(b)-int(a) <= tolerance
+}
@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func Test_Conditions(t *testing.T) {
+	colors := []color.RGBA{
+		{0, 0, 0, 255},
+		{128, 64, 0, 255},
+		{150, 200, 50, 255},
+		{200, 200, 200, 255},
+		{255, 0, 0, 255},
+		{255, 255, 255, 255},
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, len(colors), 1))
+	for x, c := range colors {
+		img.SetRGBA(x, 0, c)
+	}
+
+	tests := []struct {
+		expr string
+		want string // Which pixels are expected to match, and become transparent.
+	}{
+		{"100..200 ? ? ?", "-###--"},
+		{"{0,128,255} ? ? ?", "##--##"},
+		{"{0, 255} {0, 255} ? ?", "#---##"},
+		{"!255 ? ? ?", "####--"},
+		{"!100..200 ? ? ?", "#---##"},
+		{"!{0,255} <=200 ? ?", "-###--"},
+		{"50%..100% ? ? ?", "-#####"},
+		{"? ? ? ?   ? ? ? 0   if #L>128", "--##-#"},
+		{"? ? ? ?   ? ? ? 0   if #L=0..128 #A!=0", "-#--#-"},
+		{"? ? ? ?   ? ? ? 0   if #hsl.s>50% #hsl.h=330..30", "-#--#-"},
+		{"hsl 330..30 >=50% ? ?", "-#--#-"},
+		{"hsv ? ? 0..50 ?", "#-----"},
+		{"lab ? 20..100 ? ?", "-#--#-"},
+	}
+
+	for _, tt := range tests {
+		expr := tt.expr
+		if !strings.Contains(expr, " if ") {
+			expr += "   ? ? ? 0"
+		}
+
+		out := compile(t, expr).Run(img)
+
+		for x := range colors {
+			got := out.RGBAAt(x, 0).A == 0
+			if got != (tt.want[x] == '#') {
+				t.Errorf("%q: pixel %d (%v) match is %v", tt.expr, x, colors[x], got)
+			}
+		}
+	}
+}
+
+func Test_ConditionErrors(t *testing.T) {
+	for _, expr := range []string{
+		"200..100 ? ? ?  ? ? ? ?",
+		"0..300 ? ? ?  ? ? ? ?",
+		"<5..10 ? ? ?  ? ? ? ?",
+		"{0,<5} ? ? ?  ? ? ? ?",
+		"{0,? ? ? ?  ? ? ? ?",
+		"{} ? ? ?  ? ? ? ?",
+		"!? ? ? ?  ? ? ? ?",
+		"? ? ? ?  100..200 ? ? ?",
+		"? ? ? ?  {0} ? ? ?",
+		"? ? ? ?  !0 ? ? ?",
+		"? ? ? ?  ? ? ? ?  if",
+		"? ? ? ?  ? ? ? ?  if #L",
+		"? ? ? ?  ? ? ? ?  if #q<5",
+		"? ? ? ?  ? ? ? ?  if #L=?",
+		"? ? ? ?  ? ? ? ?  if #L<300",
+	} {
+		if _, err := parseLine([]byte(expr)); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
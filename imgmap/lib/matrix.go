@@ -0,0 +1,259 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package lib
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ColorMatrix mixes the channels of a color. Each row computes one of the
+// red, green, blue and alpha channels, from the sum of the four input
+// channels times the first four columns, plus the fifth column. Channels
+// are in the range [0, 255], and are not premultiplied by alpha.
+//
+// For example, this matrix swaps red and blue, and makes the result
+// slightly warmer:
+//
+//	0 0 1 0 10
+//	0 1 0 0 0
+//	1 0 0 0 0
+//	0 0 0 1 0
+type ColorMatrix [4][5]float64
+
+// IdentityMatrix leaves all colors as-is.
+var IdentityMatrix = ColorMatrix{
+	{1, 0, 0, 0, 0},
+	{0, 1, 0, 0, 0},
+	{0, 0, 1, 0, 0},
+	{0, 0, 0, 1, 0},
+}
+
+// matrixPreset is a built-in color matrix.
+type matrixPreset struct {
+	arg    bool                        // Whether the preset takes a value.
+	linear bool                        // Whether it works on linear RGB.
+	matrix func(v float64) ColorMatrix // Returns the matrix for value v.
+}
+
+// presets lists the built-in color matrices.
+var presets = map[string]matrixPreset{
+	"sepia": {matrix: rgbMatrix(
+		0.393, 0.769, 0.189,
+		0.349, 0.686, 0.168,
+		0.272, 0.534, 0.131,
+	)},
+
+	// Grayscale, using the luma weights of Rec. 709 (sRGB) and Rec. 601
+	// (SDTV and JPEG), and the plain average of the channels.
+	"grayscale":         {matrix: grayMatrix(0.2126, 0.7152, 0.0722)},
+	"grayscale601":      {matrix: grayMatrix(0.299, 0.587, 0.114)},
+	"grayscale-average": {matrix: grayMatrix(1.0/3, 1.0/3, 1.0/3)},
+
+	// Simulations of color vision deficiencies, from "A Physiologically-based
+	// Model for Simulation of Color Vision Deficiency", by G. M. Machado,
+	// M. M. Oliveira and L. A. F. Fernandes. These apply to linear RGB.
+	"protanopia": {linear: true, matrix: rgbMatrix(
+		0.152286, 1.052583, -0.204868,
+		0.114503, 0.786281, 0.099216,
+		-0.003882, -0.048116, 1.051998,
+	)},
+	"deuteranopia": {linear: true, matrix: rgbMatrix(
+		0.367322, 0.860646, -0.227968,
+		0.280085, 0.672501, 0.047413,
+		-0.011820, 0.042940, 0.968881,
+	)},
+	"tritanopia": {linear: true, matrix: rgbMatrix(
+		1.255528, -0.076749, -0.178779,
+		-0.078411, 0.930809, 0.147602,
+		0.004733, 0.691367, 0.303900,
+	)},
+
+	// Saturation and hue rotation, as defined for feColorMatrix in SVG.
+	"saturation": {arg: true, matrix: saturationMatrix},
+	"hue":        {arg: true, matrix: hueMatrix},
+}
+
+// rgbMatrix returns a preset function for a fixed 3x3 matrix,
+// which mixes the red, green and blue channels.
+func rgbMatrix(v ...float64) func(float64) ColorMatrix {
+	m := IdentityMatrix
+	for i := 0; i < 3; i++ {
+		copy(m[i][:3], v[3*i:3*i+3])
+	}
+	return func(float64) ColorMatrix { return m }
+}
+
+// grayMatrix returns a preset function for a matrix which sets all
+// channels to a weighted sum of red, green and blue.
+func grayMatrix(r, g, b float64) func(float64) ColorMatrix {
+	return rgbMatrix(r, g, b, r, g, b, r, g, b)
+}
+
+// saturationMatrix returns a matrix which scales the saturation by s.
+// Zero yields a grayscale image, and values over 1 increase saturation.
+func saturationMatrix(s float64) ColorMatrix {
+	return rgbMatrix(
+		0.213+0.787*s, 0.715-0.715*s, 0.072-0.072*s,
+		0.213-0.213*s, 0.715+0.285*s, 0.072-0.072*s,
+		0.213-0.213*s, 0.715-0.715*s, 0.072+0.928*s,
+	)(s)
+}
+
+// hueMatrix returns a matrix which rotates the hue by deg degrees.
+func hueMatrix(deg float64) ColorMatrix {
+	c, s := math.Cos(rad(deg)), math.Sin(rad(deg))
+	return rgbMatrix(
+		0.213+0.787*c-0.213*s, 0.715-0.715*c-0.715*s, 0.072-0.072*c+0.928*s,
+		0.213-0.213*c+0.143*s, 0.715+0.285*c+0.140*s, 0.072-0.072*c-0.283*s,
+		0.213-0.213*c-0.787*s, 0.715-0.715*c+0.715*s, 0.072+0.928*c+0.072*s,
+	)(deg)
+}
+
+// matrixStage is a stage which applies a color matrix to every pixel.
+type matrixStage struct {
+	matrix ColorMatrix
+	linear bool // Whether the matrix applies to linear RGB.
+}
+
+// AddMatrix appends a color matrix to the program, which is applied to
+// every pixel. With linear set, the red, green and blue channels are
+// converted to linear RGB before the matrix is applied, and back after.
+// This suits matrices which model light, like color blindness simulations.
+func (p *Program) AddMatrix(m ColorMatrix, linear bool) {
+	p.add(&matrixStage{m, linear})
+}
+
+// parseMatrix parses a color matrix in a map file, of either form:
+//
+//	matrix <preset> [value] [amount=N] [linear]
+//	matrix <20 numbers> [amount=N] [linear]
+//
+// The numbers are the rows of the matrix. The amount blends the
+// matrix with the identity matrix: 0 leaves colors as-is and 1,
+// the default, applies the matrix in full.
+func parseMatrix(args []string) (stage, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("Invalid matrix; expected: matrix <preset> [value] [amount=N] [linear], or matrix <20 numbers>")
+	}
+
+	var st matrixStage
+
+	if preset, ok := presets[args[0]]; ok {
+		var v float64
+		if preset.arg {
+			if len(args) < 2 {
+				return nil, fmt.Errorf("Missing value for matrix preset %s", args[0])
+			}
+
+			var err error
+			if v, err = strconv.ParseFloat(args[1], 64); err != nil {
+				return nil, fmt.Errorf("Invalid value for matrix preset %s: %s", args[0], args[1])
+			}
+			args = args[1:]
+		}
+
+		st.matrix = preset.matrix(v)
+		st.linear = preset.linear
+		args = args[1:]
+	} else {
+		var n int
+		for ; n < len(args) && n < 20; n++ {
+			v, err := strconv.ParseFloat(args[n], 64)
+			if err != nil {
+				break
+			}
+			st.matrix[n/5][n%5] = v
+		}
+
+		if n == 0 {
+			return nil, fmt.Errorf("Unknown matrix preset %q", args[0])
+		}
+
+		if n != 20 {
+			return nil, fmt.Errorf("Matrix has %d values; expected 20: 4 rows of 5", n)
+		}
+		args = args[20:]
+	}
+
+	for _, v := range args {
+		switch {
+		case v == "linear":
+			st.linear = true
+
+		case strings.HasPrefix(v, "amount="):
+			a, err := strconv.ParseFloat(v[7:], 64)
+			if err != nil || a < 0 {
+				return nil, fmt.Errorf("Invalid matrix amount: %s", v[7:])
+			}
+			st.matrix = st.matrix.blend(a)
+
+		default:
+			return nil, fmt.Errorf("Invalid matrix option %q; expected amount=N or linear", v)
+		}
+	}
+
+	return &st, nil
+}
+
+// blend returns the matrix which lies amount of the way from the
+// identity matrix to m.
+func (m ColorMatrix) blend(amount float64) ColorMatrix {
+	out := IdentityMatrix
+	for i := range out {
+		for j := range out[i] {
+			out[i][j] += amount * (m[i][j] - out[i][j])
+		}
+	}
+	return out
+}
+
+// apply returns color c, with channels in the range [0, 1],
+// transformed by the matrix.
+func (m *ColorMatrix) apply(c [4]float64) [4]float64 {
+	var out [4]float64
+	for i, row := range m {
+		out[i] = row[0]*c[0] + row[1]*c[1] + row[2]*c[2] + row[3]*c[3] + row[4]/255
+	}
+	return out
+}
+
+func (st *matrixStage) len() int       { return 1 }
+func (st *matrixStage) usesMask() bool { return false }
+
+func (st *matrixStage) run(img *image.RGBA, f *frame) {
+	b := img.Rect
+
+	parallel(b.Dy(), func(min, max int) {
+		for y := b.Min.Y + min; y < b.Min.Y+max; y++ {
+			row := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+
+			for i := 0; i < len(row); i += 4 {
+				pix := pixel{r: row[i], g: row[i+1], b: row[i+2], a: row[i+3]}
+
+				r, g, bb := pix.unpremultiply()
+				c := [4]float64{r, g, bb, float64(pix.a) / 255}
+
+				if st.linear {
+					c[0], c[1], c[2] = linearSRGB(c[0]), linearSRGB(c[1]), linearSRGB(c[2])
+				}
+
+				c = st.matrix.apply(c)
+
+				if st.linear {
+					c[0], c[1], c[2] = gammaSRGBf(c[0]), gammaSRGBf(c[1]), gammaSRGBf(c[2])
+				}
+
+				a := uint8(math.Max(0, math.Min(1, c[3]))*255 + 0.5)
+				row[i+0] = premultiply(c[0], a)
+				row[i+1] = premultiply(c[1], a)
+				row[i+2] = premultiply(c[2], a)
+				row[i+3] = a
+			}
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+)
+
+func Test_Matrix(t *testing.T) {
+	tests := []struct {
+		op   string
+		in   color.RGBA
+		want color.RGBA
+	}{
+		{"matrix grayscale", color.RGBA{255, 0, 0, 255}, color.RGBA{54, 54, 54, 255}},
+		{"matrix grayscale601", color.RGBA{0, 255, 0, 255}, color.RGBA{150, 150, 150, 255}},
+		{"matrix grayscale-average", color.RGBA{0, 0, 255, 255}, color.RGBA{85, 85, 85, 255}},
+		{"matrix sepia", color.RGBA{100, 100, 100, 255}, color.RGBA{135, 120, 94, 255}},
+		{"matrix sepia amount=0", color.RGBA{100, 100, 100, 255}, color.RGBA{100, 100, 100, 255}},
+		{"matrix saturation 1", color.RGBA{200, 50, 10, 255}, color.RGBA{200, 50, 10, 255}},
+		{"matrix saturation 0", color.RGBA{255, 255, 0, 255}, color.RGBA{237, 237, 237, 255}},
+		{"matrix hue 0", color.RGBA{200, 50, 10, 255}, color.RGBA{200, 50, 10, 255}},
+
+		// Grays are unaffected by color blindness.
+		{"matrix deuteranopia", color.RGBA{128, 128, 128, 255}, color.RGBA{128, 128, 128, 255}},
+
+		// Swap red and blue, add 10 to green and halve alpha.
+		{"matrix 0,0,1,0,0 0,1,0,0,10 1,0,0,0,0 0,0,0,0.5,0", color.RGBA{200, 100, 0, 255}, color.RGBA{0, 55, 100, 128}},
+
+		// Make transparent pixels opaque.
+		{"matrix 1 0 0 0 0  0 1 0 0 0  0 0 1 0 0  0 0 0 0 255", color.RGBA{0, 0, 0, 0}, color.RGBA{0, 0, 0, 255}},
+	}
+
+	for _, tt := range tests {
+		img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+		img.SetRGBA(0, 0, tt.in)
+
+		p := compile(t, tt.op)
+		if got := p.Run(img).RGBAAt(0, 0); got != tt.want {
+			t.Errorf("%q: %v yields %v; want %v", tt.op, tt.in, got, tt.want)
+		}
+	}
+}
+
+func Test_MatrixHue(t *testing.T) {
+	// Rotating the hue a full turn, in steps, keeps the color.
+	var p Program
+	for i := 0; i < 3; i++ {
+		p.AddMatrix(hueMatrix(120), false)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	img.SetRGBA(0, 0, color.RGBA{180, 90, 60, 255})
+
+	got := p.Run(img).RGBAAt(0, 0)
+	if math.Abs(float64(got.R)-180) > 2 || math.Abs(float64(got.G)-90) > 2 || math.Abs(float64(got.B)-60) > 2 {
+		t.Fatalf("Full hue rotation yields %v", got)
+	}
+
+	// A rotation moves red towards green.
+	p = Program{}
+	p.AddMatrix(hueMatrix(120), false)
+	img.SetRGBA(0, 0, color.RGBA{255, 0, 0, 255})
+
+	if got := p.Run(img).RGBAAt(0, 0); got.G <= got.R || got.G <= got.B {
+		t.Fatalf("Rotating red by 120 degrees yields %v", got)
+	}
+}
+
+func Test_MatrixErrors(t *testing.T) {
+	for _, src := range []string{
+		"matrix",
+		"matrix foo",
+		"matrix saturation",
+		"matrix hue x",
+		"matrix sepia amount=-1",
+		"matrix sepia bright",
+		"matrix 1 0 0 0 0",
+		"matrix 1 0 0 0 0 0 1 0 0 0 0 0 1 0 0 0 0 0 1 0 0",
+	} {
+		if _, err := Compile(strings.NewReader(src)); err == nil {
+			t.Errorf("%q: expected an error", src)
+		}
+	}
+}
@@ -0,0 +1,423 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	imglib "github.com/jteeuwen/imgtools/lib"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Palette is a list of opaque colors.
+type Palette []color.RGBA
+
+// maxPaletteColors is the largest number of colors
+// which is taken from an image without a palette.
+const maxPaletteColors = 256
+
+// LoadPalette reads a palette from r. It accepts GIMP palettes (.gpl),
+// JASC palettes (.pal), and lists of hexadecimal colors, like "#ff9900",
+// separated by whitespace or commas. Lines in hex lists which start with
+// ';' or '//' are comments.
+func LoadPalette(r io.Reader) (Palette, error) {
+	var lines []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimSpace(scanner.Text()))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Read palette: %v", err)
+	}
+
+	var pal Palette
+	var err error
+
+	switch {
+	case len(lines) > 0 && lines[0] == "GIMP Palette":
+		pal, err = parseGIMP(lines[1:])
+	case len(lines) > 0 && lines[0] == "JASC-PAL":
+		pal, err = parseJASC(lines[1:])
+	default:
+		pal, err = parseHexList(lines)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pal) == 0 {
+		return nil, fmt.Errorf("Palette has no colors")
+	}
+
+	return pal, nil
+}
+
+// LoadPaletteFile loads a palette from the given file. This is either a
+// palette file as accepted by LoadPalette, or an image. See
+// PaletteFromImage for how colors are taken from images.
+func LoadPaletteFile(file string) (Palette, error) {
+	data, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+
+	if img, _, err := imglib.Decode(bytes.NewReader(data)); err == nil {
+		return PaletteFromImage(img)
+	}
+
+	pal, err := LoadPalette(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	return pal, nil
+}
+
+// PaletteFromImage returns the colors of an image as a palette. Paletted
+// images, like GIFs, yield their palette. Other images yield all distinct
+// colors they contain, in the order they appear, which is useful for small
+// images with color swatches. These may have at most 256 colors. Fully
+// transparent colors are skipped, and alpha is ignored otherwise.
+func PaletteFromImage(img image.Image) (Palette, error) {
+	var pal Palette
+	seen := make(map[color.RGBA]bool)
+
+	add := func(c color.Color) {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		v := color.RGBA{n.R, n.G, n.B, 255}
+		if n.A > 0 && !seen[v] {
+			seen[v] = true
+			pal = append(pal, v)
+		}
+	}
+
+	if p, ok := img.(*image.Paletted); ok {
+		for _, c := range p.Palette {
+			add(c)
+		}
+	} else {
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				add(img.At(x, y))
+
+				if len(pal) > maxPaletteColors {
+					return nil, fmt.Errorf("Palette image has more than %d colors", maxPaletteColors)
+				}
+			}
+		}
+	}
+
+	if len(pal) == 0 {
+		return nil, fmt.Errorf("Palette image has no colors")
+	}
+
+	return pal, nil
+}
+
+// parseGIMP parses the lines of a GIMP palette, after its header.
+// These hold a name and column count, comments, and colors of the
+// form "R G B [name]".
+func parseGIMP(lines []string) (Palette, error) {
+	var pal Palette
+
+	for i, line := range lines {
+		if len(line) == 0 || line[0] == '#' ||
+			strings.HasPrefix(line, "Name:") || strings.HasPrefix(line, "Columns:") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("Invalid GIMP palette color on line %d: %q", i+2, line)
+		}
+
+		c, err := parseRGB(fields[:3])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid GIMP palette color on line %d: %v", i+2, err)
+		}
+
+		pal = append(pal, c)
+	}
+
+	return pal, nil
+}
+
+// parseJASC parses the lines of a JASC palette, after its header.
+// These hold a version, the number of colors, and colors of the
+// form "R G B".
+func parseJASC(lines []string) (Palette, error) {
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("Invalid JASC palette: missing header")
+	}
+
+	n, err := strconv.Atoi(lines[1])
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("Invalid JASC palette color count: %q", lines[1])
+	}
+
+	lines = lines[2:]
+	if len(lines) < n {
+		return nil, fmt.Errorf("JASC palette has %d colors; expected %d", len(lines), n)
+	}
+
+	pal := make(Palette, n)
+	for i := range pal {
+		pal[i], err = parseRGB(strings.Fields(lines[i]))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid JASC palette color on line %d: %v", i+4, err)
+		}
+	}
+
+	return pal, nil
+}
+
+// parseHexList parses lines with hexadecimal colors. These may
+// have a '#' or '0x' prefix.
+func parseHexList(lines []string) (Palette, error) {
+	var pal Palette
+
+	for i, line := range lines {
+		if strings.HasPrefix(line, ";") || strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		list, err := splitFields(line)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range list {
+			v = strings.TrimPrefix(strings.TrimPrefix(v, "0x"), "#")
+
+			r, g, b, err := parseHexColor("#" + v)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid palette color on line %d: %v", i+1, err)
+			}
+
+			pal = append(pal, color.RGBA{uint8(r), uint8(g), uint8(b), 255})
+		}
+	}
+
+	return pal, nil
+}
+
+// parseRGB parses a color from three decimal values.
+func parseRGB(fields []string) (color.RGBA, error) {
+	var v [3]uint8
+
+	if len(fields) != 3 {
+		return color.RGBA{}, fmt.Errorf("expected 3 values; got %d", len(fields))
+	}
+
+	for i, f := range fields {
+		n, err := strconv.ParseUint(f, 10, 8)
+		if err != nil {
+			return color.RGBA{}, fmt.Errorf("invalid color value %q", f)
+		}
+		v[i] = uint8(n)
+	}
+
+	return color.RGBA{v[0], v[1], v[2], 255}, nil
+}
+
+// remap is a stage which maps every pixel onto the nearest color of a
+// palette. Alpha is left as-is.
+type remap struct {
+	palette Palette
+	metric  Metric
+	dither  bool
+	points  [][3]float64 // Palette colors, as points of the metric.
+}
+
+// newRemap returns a stage which remaps images to the given palette.
+func newRemap(pal Palette, m Metric, dither bool) *remap {
+	r := &remap{palette: pal, metric: m, dither: dither}
+
+	r.points = make([][3]float64, len(pal))
+	for i, c := range pal {
+		r.points[i] = m.point(float64(c.R), float64(c.G), float64(c.B))
+	}
+
+	return r
+}
+
+// AddPalette appends a palette remap to the program. Every pixel is
+// replaced by the nearest color of the palette, as measured by m. With
+// dithering, the difference between each pixel and its replacement is
+// spread over its neighbours, using Floyd-Steinberg error diffusion.
+// This approximates the original colors better, at the cost of noise.
+func (p *Program) AddPalette(pal Palette, m Metric, dither bool) {
+	p.add(newRemap(pal, m, dither))
+}
+
+// parsePalette parses a palette remap in a map file, of the form:
+//
+//	palette <file> [metric=M] [dither]
+//
+// The metric defaults to Lab.
+func parsePalette(args []string) (stage, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("Missing palette file; expected: palette <file> [metric=M] [dither]")
+	}
+
+	pal, err := LoadPaletteFile(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	m := MetricLab
+	var dither bool
+
+	for _, v := range args[1:] {
+		switch {
+		case strings.HasPrefix(v, "metric="):
+			m, err = ParseMetric(v[7:])
+			if err != nil {
+				return nil, err
+			}
+
+		case v == "dither":
+			dither = true
+
+		default:
+			return nil, fmt.Errorf("Invalid palette option %q; expected metric=M or dither", v)
+		}
+	}
+
+	return newRemap(pal, m, dither), nil
+}
+
+func (r *remap) len() int       { return 1 }
+func (r *remap) usesMask() bool { return false }
+
+// nearest returns the index of the palette color nearest to the given
+// color, with channels in the range [0, 255].
+func (r *remap) nearest(red, green, blue float64) int {
+	pt := r.metric.point(red, green, blue)
+
+	best, dist := 0, r.metric.distance(pt, r.points[0])
+	for i := 1; i < len(r.points); i++ {
+		if d := r.metric.distance(pt, r.points[i]); d < dist {
+			best, dist = i, d
+		}
+	}
+
+	return best
+}
+
+func (r *remap) run(img *image.RGBA, f *frame) {
+	if r.dither {
+		r.diffuse(img)
+		return
+	}
+
+	b := img.Rect
+
+	parallel(b.Dy(), func(min, max int) {
+		// Images tend to repeat colors, so the nearest palette
+		// color is cached for the colors seen so far.
+		cache := make(map[[3]uint8]int)
+
+		for y := b.Min.Y + min; y < b.Min.Y+max; y++ {
+			row := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+
+			for i := 0; i < len(row); i += 4 {
+				pix := pixel{r: row[i], g: row[i+1], b: row[i+2], a: row[i+3]}
+				if pix.a == 0 {
+					continue
+				}
+
+				cr, cg, cb := pix.unpremultiply()
+				key := [3]uint8{uint8(255*cr + 0.5), uint8(255*cg + 0.5), uint8(255*cb + 0.5)}
+
+				n, ok := cache[key]
+				if !ok {
+					n = r.nearest(float64(key[0]), float64(key[1]), float64(key[2]))
+					cache[key] = n
+				}
+
+				r.set(row[i:i+4], n)
+			}
+		}
+	})
+}
+
+// diffuse remaps img with Floyd-Steinberg dithering. This runs
+// from the top-left to the bottom-right, so it is not parallel.
+func (r *remap) diffuse(img *image.RGBA) {
+	b := img.Rect
+	w := b.Dx()
+
+	// Errors to add to the current and the next row. These
+	// have an extra pixel on either side, to avoid bounds checks.
+	curr := make([][3]float64, w+2)
+	next := make([][3]float64, w+2)
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+
+		for x := 0; x < w; x++ {
+			px := row[4*x : 4*x+4]
+			pix := pixel{r: px[0], g: px[1], b: px[2], a: px[3]}
+			if pix.a == 0 {
+				continue
+			}
+
+			cr, cg, cb := pix.unpremultiply()
+			want := [3]float64{255 * cr, 255 * cg, 255 * cb}
+			for i := range want {
+				want[i] = clamp255(want[i] + curr[x+1][i])
+			}
+
+			n := r.nearest(want[0], want[1], want[2])
+			r.set(px, n)
+
+			c := r.palette[n]
+			got := [3]float64{float64(c.R), float64(c.G), float64(c.B)}
+
+			for i := range want {
+				e := want[i] - got[i]
+				curr[x+2][i] += e * 7 / 16
+				next[x][i] += e * 3 / 16
+				next[x+1][i] += e * 5 / 16
+				next[x+2][i] += e * 1 / 16
+			}
+		}
+
+		curr, next = next, curr
+		for i := range next {
+			next[i] = [3]float64{}
+		}
+	}
+}
+
+// set stores palette color n in px, premultiplied by its alpha.
+func (r *remap) set(px []uint8, n int) {
+	c, a := r.palette[n], px[3]
+	px[0] = premultiply(float64(c.R)/255, a)
+	px[1] = premultiply(float64(c.G)/255, a)
+	px[2] = premultiply(float64(c.B)/255, a)
+}
+
+// clamp255 limits v to the range [0, 255].
+func clamp255(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return v
+}
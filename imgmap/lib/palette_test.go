@@ -0,0 +1,283 @@
+package lib
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testPalette = Palette{
+	{0, 0, 0, 255},
+	{255, 255, 255, 255},
+	{255, 153, 0, 255},
+	{40, 80, 200, 255},
+}
+
+func Test_LoadPalette(t *testing.T) {
+	tests := []string{
+		"GIMP Palette\nName: test\nColumns: 4\n#\n  0   0   0\tBlack\n255 255 255\tWhite\n255 153   0\n 40  80 200 Blue\n",
+		"JASC-PAL\r\n0100\r\n4\r\n0 0 0\r\n255 255 255\r\n255 153 0\r\n40 80 200\r\n",
+		"; comment\n#000000 #ffffff\n\nff9900, 0x2850c8\n",
+		"// comment\n#000 #fff #f90\n#2850c8\n",
+	}
+
+	for _, src := range tests {
+		pal, err := LoadPalette(strings.NewReader(src))
+		if err != nil {
+			t.Errorf("%q: %v", src, err)
+			continue
+		}
+
+		if len(pal) != len(testPalette) {
+			t.Errorf("%q: %v; want %v", src, pal, testPalette)
+			continue
+		}
+
+		for i := range pal {
+			if pal[i] != testPalette[i] {
+				t.Errorf("%q: %v; want %v", src, pal, testPalette)
+				break
+			}
+		}
+	}
+}
+
+func Test_LoadPaletteErrors(t *testing.T) {
+	for _, src := range []string{
+		"",
+		"GIMP Palette\nName: empty\n",
+		"GIMP Palette\n0 0\n",
+		"GIMP Palette\n0 0 256\n",
+		"JASC-PAL\n0100\n3\n0 0 0\n",
+		"JASC-PAL\n0100\nx\n",
+		"#ff99\n",
+		"#gg9900\n",
+	} {
+		if _, err := LoadPalette(strings.NewReader(src)); err == nil {
+			t.Errorf("%q: expected an error", src)
+		}
+	}
+}
+
+func Test_PaletteFromImage(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	img.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 255})
+	img.SetNRGBA(1, 0, color.NRGBA{0, 255, 0, 128})
+	img.SetNRGBA(2, 0, color.NRGBA{255, 0, 0, 255})
+	img.SetNRGBA(0, 1, color.NRGBA{0, 0, 255, 255})
+
+	pal, err := PaletteFromImage(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Palette{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}}
+	if len(pal) != len(want) || pal[0] != want[0] || pal[1] != want[1] || pal[2] != want[2] {
+		t.Fatalf("%v; want %v", pal, want)
+	}
+
+	gif := image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.Black, color.Transparent, color.White})
+	if pal, _ = PaletteFromImage(gif); len(pal) != 2 {
+		t.Fatalf("Paletted image yields %v; want black and white", pal)
+	}
+
+	if _, err := PaletteFromImage(testImage()); err == nil {
+		t.Fatalf("Expected an error for an image with too many colors")
+	}
+}
+
+func Test_PaletteRemap(t *testing.T) {
+	img := testImage()
+
+	for _, m := range []Metric{MetricDE2000, MetricLab, MetricRGB, MetricWeighted} {
+		for _, dither := range []bool{false, true} {
+			var p Program
+			p.AddPalette(testPalette, m, dither)
+			out := p.Run(img)
+
+			b := out.Bounds()
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					got := out.RGBAAt(x, y)
+					in := img.NRGBAAt(x, y)
+
+					if got.A != in.A {
+						t.Fatalf("%v, %v: alpha at %d,%d is %d; want %d", m, dither, x, y, got.A, in.A)
+					}
+
+					// Undo the alpha, to find the palette color.
+					c := color.NRGBAModel.Convert(got).(color.NRGBA)
+					if !inPalette(c, 3) {
+						t.Fatalf("%v, %v: color at %d,%d is %v; not in the palette", m, dither, x, y, c)
+					}
+				}
+			}
+		}
+	}
+}
+
+// inPalette returns true if c is within tolerance of a test palette color.
+func inPalette(c color.NRGBA, tolerance int) bool {
+	near := func(a, b uint8) bool { return int(a)-int(b) <= tolerance && int(b)-int(a) <= tolerance }
+
+	for _, p := range testPalette {
+		if near(c.R, p.R) && near(c.G, p.G) && near(c.B, p.B) {
+			return true
+		}
+	}
+	return false
+}
+
+func Test_PaletteNearest(t *testing.T) {
+	pal := Palette{{0, 0, 0, 255}, {255, 0, 0, 255}, {0, 255, 0, 255}, {128, 128, 128, 255}}
+
+	tests := []struct {
+		c    [3]float64
+		want int
+	}{
+		{[3]float64{10, 10, 10}, 0},
+		{[3]float64{200, 30, 30}, 1},
+		{[3]float64{30, 220, 40}, 2},
+		{[3]float64{140, 120, 130}, 3},
+	}
+
+	for _, m := range []Metric{MetricDE2000, MetricLab, MetricRGB, MetricWeighted} {
+		r := newRemap(pal, m, false)
+		for _, tt := range tests {
+			if got := r.nearest(tt.c[0], tt.c[1], tt.c[2]); got != tt.want {
+				t.Errorf("%v: nearest %v is %v; want %v", m, tt.c, pal[got], pal[tt.want])
+			}
+		}
+	}
+}
+
+func Test_PaletteDither(t *testing.T) {
+	// Dithering a flat gray onto black and white must preserve its
+	// average brightness, which a plain remap can not.
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = 64
+		if i%4 == 3 {
+			img.Pix[i] = 255
+		}
+	}
+
+	bw := Palette{{0, 0, 0, 255}, {255, 255, 255, 255}}
+
+	var p Program
+	p.AddPalette(bw, MetricRGB, true)
+	out := p.Run(img)
+
+	var sum float64
+	for i := 0; i < len(out.Pix); i += 4 {
+		sum += float64(out.Pix[i])
+	}
+
+	if mean := sum / float64(64*64); math.Abs(mean-64) > 1 {
+		t.Fatalf("Mean of dithered image is %.2f; want 64", mean)
+	}
+}
+
+func Test_PaletteDirective(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imgmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "bw.hex")
+	if err := ioutil.WriteFile(file, []byte("#000 #fff\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.SetRGBA(0, 0, color.RGBA{100, 100, 100, 255})
+	img.SetRGBA(1, 0, color.RGBA{150, 150, 150, 255})
+
+	// The palette applies to the result of the rules before it,
+	// and the rules after it see the palette colors.
+	p := compile(t, "? ? ? ?  255-#r 255-#g 255-#b ?\npalette "+file+" metric=rgb ; remap\n255 ? ? ?  ? 0 0 ?")
+	if p.Len() != 3 {
+		t.Fatalf("Len is %d; want 3", p.Len())
+	}
+
+	out := p.Run(img)
+	if got := out.RGBAAt(0, 0); got != (color.RGBA{255, 0, 0, 255}) {
+		t.Fatalf("Pixel 0 is %v; want red", got)
+	}
+
+	if got := out.RGBAAt(1, 0); got != (color.RGBA{0, 0, 0, 255}) {
+		t.Fatalf("Pixel 1 is %v; want black", got)
+	}
+
+	for _, src := range []string{
+		"palette",
+		"palette " + filepath.Join(dir, "missing.gpl"),
+		"palette " + file + " metric=foo",
+		"palette " + file + " smooth",
+	} {
+		if _, err := Compile(strings.NewReader(src)); err == nil {
+			t.Errorf("%q: expected an error", src)
+		}
+	}
+}
+
+func Test_PaletteFileNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imgmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	grades := filepath.Join(dir, "grades")
+	if err := os.Mkdir(grades, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// File names with commas, spaces and semicolons.
+	for _, name := range []string{"bw,1.hex", "bw 2;x.hex"} {
+		if err := ioutil.WriteFile(filepath.Join(grades, name), []byte("#000 #fff\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	img.SetRGBA(0, 0, color.RGBA{200, 200, 200, 255})
+
+	for _, src := range []string{
+		"palette bw,1.hex metric=rgb",
+		"palette bw,1.hex ; a comment",
+		"  palette \"bw 2;x.hex\" metric=rgb dither",
+		"palette \"" + filepath.Join(grades, "bw,1.hex") + "\"",
+	} {
+		// Relative names are relative to the map file.
+		file := filepath.Join(grades, "film.map")
+		if err := ioutil.WriteFile(file, []byte(src+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := CompileFile(file)
+		if err != nil {
+			t.Errorf("%q: %v", src, err)
+			continue
+		}
+
+		if got := p.Run(img).RGBAAt(0, 0); got != (color.RGBA{255, 255, 255, 255}) {
+			t.Errorf("%q: pixel is %v; want white", src, got)
+		}
+
+		// Compile resolves them against the current directory.
+		if _, err := Compile(strings.NewReader(src)); err == nil && !strings.Contains(src, dir) {
+			t.Errorf("%q: expected an error for a name relative to the current directory", src)
+		}
+	}
+
+	if _, err := Compile(strings.NewReader("palette \"bw,1.hex")); err == nil {
+		t.Errorf("Expected an error for a missing closing quote")
+	}
+}
@@ -0,0 +1,426 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Program is a compiled list of color map rules and whole-image operations,
+// like a palette remap.
+//
+// Consecutive rules are applied to an image in a single pass. Every pixel
+// flows through all of them in order, so each rule sees the result of the
+// rules before it. This gives the same result as applying each rule to the
+// whole image in turn, but touches every pixel only once. Other operations
+// process the whole image in between.
+type Program struct {
+	stages []stage
+}
+
+// stage is a step of a program, which processes a whole image.
+type stage interface {
+	// run processes img in place.
+	run(img *image.RGBA, f *frame)
+
+	// len returns the number of rules or operations in the stage.
+	len() int
+
+	// usesMask returns true if the stage refers to the mask.
+	usesMask() bool
+}
+
+// rules is a stage with a list of rules, which are applied to each
+// pixel in turn.
+type rules []*rule
+
+// rule is a single compiled color mapping.
+type rule struct {
+	space    Space
+	from, to *Rule
+	conds    []condition
+	fuzzy    *fuzzy // Fuzzy source color, or nil.
+	regions  []region
+
+	// Whether the conditions or transformation reference the derived
+	// grayscale values.
+	// These are only computed for rules which need them, since the
+	// luminosity in particular is expensive.
+	derived    bool
+	luminosity bool
+
+	// Whether the rule refers to the mask image.
+	usesMask bool
+}
+
+// condition is an additional filter on a named reference.
+type condition struct {
+	name  Name
+	match Channel
+}
+
+// directives maps the names of whole-image operations in a map file
+// onto their parsers. The parsers receive the remaining fields of the line.
+var directives = map[string]func(args []string) (stage, error){
+	"palette":      parsePalette,
+	"lut":          parseLUT,
+	"levels":       parseLevels,
+	"curve":        parseCurve,
+	"autolevels":   parseAutoLevels(false),
+	"autocontrast": parseAutoLevels(true),
+	"equalize":     parseEqualize,
+	"clahe":        parseCLAHE,
+	"matrix":       parseMatrix,
+}
+
+// fileDirectives lists the directives which take a file name as their
+// first argument. See parseDirective.
+var fileDirectives = map[string]bool{
+	"palette": true,
+	"lut":     true,
+}
+
+// Compile parses the color map expressions in r, one per line.
+// Empty lines and comments are skipped. Errors report the line number.
+// Relative file names in the expressions, like that of a palette, are
+// relative to the current directory.
+func Compile(r io.Reader) (*Program, error) {
+	return compileDir(r, "")
+}
+
+// CompileFile parses the color map expressions in the given map file,
+// like Compile. Relative file names in the expressions are relative to
+// the directory of the map file.
+func CompileFile(file string) (*Program, error) {
+	fd, err := os.Open(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+	return compileDir(fd, filepath.Dir(file))
+}
+
+// compileDir parses the color map expressions in r. Relative file names
+// are relative to dir.
+func compileDir(r io.Reader, dir string) (*Program, error) {
+	var p Program
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		st, err := parseDirective(scanner.Bytes(), dir)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %v", line, err)
+		}
+
+		if st != nil {
+			p.add(st)
+			continue
+		}
+
+		rl, err := parseLine(scanner.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %v", line, err)
+		}
+
+		if rl != nil {
+			p.add(rules{rl})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Read expressions: %v", err)
+	}
+
+	return &p, nil
+}
+
+// parseDirective parses a whole-image operation, if the line holds one.
+//
+// For directives which take a file name, the name is taken from the line
+// as-is, up to the next whitespace or comment. It can be quoted to include
+// those as well. A relative name is made relative to dir.
+func parseDirective(expr []byte, dir string) (stage, error) {
+	line := strings.TrimLeft(string(expr), " \t")
+
+	name := line
+	if idx := strings.IndexAny(line, " \t,;"); idx > -1 {
+		name = line[:idx]
+	}
+
+	parse, ok := directives[name]
+	if !ok {
+		return nil, nil
+	}
+
+	rest := line[len(name):]
+
+	var file string
+	if fileDirectives[name] {
+		var err error
+		if file, rest, err = splitFile(rest); err != nil {
+			return nil, err
+		}
+	}
+
+	if idx := strings.IndexByte(rest, ';'); idx > -1 {
+		rest = rest[:idx]
+	}
+
+	args, err := splitFields(rest)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(file) > 0 {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		args = append([]string{file}, args...)
+	}
+
+	return parse(args)
+}
+
+// splitFile splits a file name off the start of data. The name
+// ends at whitespace or a comment, unless it is in double quotes.
+func splitFile(data string) (file, rest string, err error) {
+	data = strings.TrimLeft(data, " \t")
+
+	if strings.HasPrefix(data, `"`) {
+		end := strings.IndexByte(data[1:], '"')
+		if end == -1 {
+			return "", "", fmt.Errorf("Missing closing quote in file name %s", data)
+		}
+		return data[1 : end+1], data[end+2:], nil
+	}
+
+	end := strings.IndexAny(data, " \t;")
+	if end == -1 {
+		end = len(data)
+	}
+
+	return data[:end], data[end:], nil
+}
+
+// add appends a stage to the program. Consecutive rules are merged
+// into a single stage.
+func (p *Program) add(st stage) {
+	if rs, ok := st.(rules); ok && len(p.stages) > 0 {
+		if last, ok := p.stages[len(p.stages)-1].(rules); ok {
+			p.stages[len(p.stages)-1] = append(last, rs...)
+			return
+		}
+	}
+
+	p.stages = append(p.stages, st)
+}
+
+// Len returns the number of rules and operations in the program.
+func (p *Program) Len() int {
+	var n int
+	for _, st := range p.stages {
+		n += st.len()
+	}
+	return n
+}
+
+// UsesMask returns true if any rule refers to the mask image.
+func (p *Program) UsesMask() bool {
+	for _, st := range p.stages {
+		if st.usesMask() {
+			return true
+		}
+	}
+	return false
+}
+
+// Run applies the program to img, and returns the result as a new image.
+// The image is divided into bands of rows, which are processed in parallel.
+// Rules which refer to the mask treat it as empty.
+func (p *Program) Run(img image.Image) *image.RGBA {
+	dst, _ := p.RunMask(img, nil)
+	return dst
+}
+
+// RunMask applies the program to img, like Run, with the given mask.
+// The mask is converted to grayscale, and must be as large as img.
+// It may be nil, in which case it is treated as empty.
+func (p *Program) RunMask(img, mask image.Image) (*image.RGBA, error) {
+	b := img.Bounds()
+
+	f, err := newFrame(b, mask)
+	if err != nil {
+		return nil, err
+	}
+
+	dst := image.NewRGBA(b)
+
+	parallel(b.Dy(), func(min, max int) {
+		for y := b.Min.Y + min; y < b.Min.Y+max; y++ {
+			row := dst.Pix[dst.PixOffset(b.Min.X, y):dst.PixOffset(b.Max.X, y)]
+			readRow(row, img, b.Min.X, y)
+		}
+	})
+
+	for _, st := range p.stages {
+		st.run(dst, f)
+	}
+
+	return dst, nil
+}
+
+// draw runs the program on src and stores the result in dst.
+func (p *Program) draw(src, dst draw.Image) {
+	b := src.Bounds()
+	draw.Draw(dst, b, p.Run(src), b.Min, draw.Src)
+}
+
+func (rs rules) len() int { return len(rs) }
+
+func (rs rules) usesMask() bool {
+	for _, rl := range rs {
+		if rl.usesMask {
+			return true
+		}
+	}
+	return false
+}
+
+// run applies all rules to each pixel of img in a single pass.
+func (rs rules) run(img *image.RGBA, f *frame) {
+	b := img.Rect
+
+	parallel(b.Dy(), func(min, max int) {
+		for y := min; y < max; y++ {
+			row := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):img.PixOffset(b.Max.X, b.Min.Y+y)]
+
+			for i := 0; i < len(row); i += 4 {
+				pix := pixel{x: i / 4, y: y, frame: f}
+				rs.pixel(row[i:i+4], &pix)
+			}
+		}
+	})
+}
+
+// pixel runs all rules on the color in px, in the layout of image.RGBA.
+func (rs rules) pixel(px []uint8, pix *pixel) {
+	pix.r, pix.g, pix.b, pix.a = px[0], px[1], px[2], px[3]
+
+	for _, rl := range rs {
+		rl.apply(pix)
+	}
+
+	px[0] = pix.r
+	px[1] = pix.g
+	px[2] = pix.b
+	px[3] = pix.a
+}
+
+// apply transforms pix, if it matches the rule's filter.
+func (rl *rule) apply(pix *pixel) {
+	from, to := rl.from, rl.to
+
+	for i := range rl.regions {
+		if !rl.regions[i].contains(pix) {
+			return
+		}
+	}
+
+	weight := 1.0
+	if rl.fuzzy != nil {
+		var ok bool
+		if weight, ok = rl.fuzzy.weight(pix); !ok {
+			return
+		}
+	}
+
+	c := pix.colors(rl.space)
+
+	if !(match(c[0], from.R) && match(c[1], from.G) && match(c[2], from.B) && match(c[3], from.A)) {
+		return
+	}
+
+	if rl.derived {
+		pix.derive(rl.luminosity)
+	}
+
+	for _, cond := range rl.conds {
+		if !match(pix.value(cond.name), cond.match) {
+			return
+		}
+	}
+
+	orig := *pix
+	cr := &ranges[rl.space]
+	c[0] = transform(pix, c[0], to.R, &cr[0])
+	c[1] = transform(pix, c[1], to.G, &cr[1])
+	c[2] = transform(pix, c[2], to.B, &cr[2])
+	c[3] = transform(pix, c[3], to.A, &cr[3])
+
+	pix.setColors(rl.space, c)
+
+	if weight < 1 {
+		pix.blend(&orig, weight)
+	}
+}
+
+// readRow stores row y of img, starting at column x, in row. Colors are
+// stored as 8 bit, alpha-premultiplied values, like in image.RGBA.
+func readRow(row []uint8, img image.Image, x, y int) {
+	switch src := img.(type) {
+	case *image.RGBA:
+		i := src.PixOffset(x, y)
+		copy(row, src.Pix[i:i+len(row)])
+
+	case *image.NRGBA:
+		i := src.PixOffset(x, y)
+		for j := 0; j < len(row); j += 4 {
+			a := uint32(src.Pix[i+j+3]) * 0x101
+			row[j+0] = uint8(uint32(src.Pix[i+j+0]) * 0x101 * a / 0xffff >> 8)
+			row[j+1] = uint8(uint32(src.Pix[i+j+1]) * 0x101 * a / 0xffff >> 8)
+			row[j+2] = uint8(uint32(src.Pix[i+j+2]) * 0x101 * a / 0xffff >> 8)
+			row[j+3] = uint8(a >> 8)
+		}
+
+	default:
+		for j := 0; j < len(row); j += 4 {
+			r, g, b, a := img.At(x+j/4, y).RGBA()
+			row[j+0] = uint8(r >> 8)
+			row[j+1] = uint8(g >> 8)
+			row[j+2] = uint8(b >> 8)
+			row[j+3] = uint8(a >> 8)
+		}
+	}
+}
+
+// parallel divides n rows into bands and calls fn for each of them
+// in a separate goroutine. It returns once all bands are done.
+func parallel(n int, fn func(min, max int)) {
+	jobs := runtime.NumCPU()
+	if jobs > n {
+		jobs = n
+	}
+
+	c := make(chan int, jobs)
+
+	for i := 0; i < jobs; i++ {
+		go func(min, max int) {
+			fn(min, max)
+			c <- 1
+		}(i*n/jobs, (i+1)*n/jobs)
+	}
+
+	for i := 0; i < jobs; i++ {
+		<-c
+	}
+}
@@ -0,0 +1,118 @@
+package lib
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// testImage returns an image with a range of colors and alpha values.
+func testImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(3, 5, 35, 29))
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 7), uint8(y * 11), uint8(x * y), uint8(255 - x)})
+		}
+	}
+	return img
+}
+
+// opaque hides the concrete type of an image, which
+// forces the generic code paths.
+type opaque struct{ image.Image }
+
+func compile(t *testing.T, src string) *Program {
+	p, err := Compile(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func sameRGBA(t *testing.T, a, b *image.RGBA) {
+	if a.Rect != b.Rect {
+		t.Fatalf("bounds %v; want %v", a.Rect, b.Rect)
+	}
+
+	for i := range a.Pix {
+		if a.Pix[i] != b.Pix[i] {
+			t.Fatalf("byte %d is %d; want %d", i, a.Pix[i], b.Pix[i])
+		}
+	}
+}
+
+func Test_ProgramSinglePass(t *testing.T) {
+	rules := []string{
+		"? ? ? ?     #L #L #L ?",
+		">100 ? ? ?  -20% +10 ? 255",
+		"? <50 ? ?   #b ? #r ?",
+		"? ? ? ?     #l ? #A ?",
+	}
+
+	// Running all rules at once must match applying them one at a time.
+	want := compile(t, rules[0]).Run(testImage())
+	for _, r := range rules[1:] {
+		want = compile(t, r).Run(want)
+	}
+
+	got := compile(t, strings.Join(rules, "\n")).Run(testImage())
+	sameRGBA(t, got, want)
+}
+
+func Test_ProgramFastPaths(t *testing.T) {
+	p := compile(t, "? ? ? ?   #r #b #g ?\n>50 ? ? ?  +10 ? ? ?")
+
+	img := testImage()
+	sameRGBA(t, p.Run(img), p.Run(opaque{img}))
+
+	rgba := image.NewRGBA(img.Rect)
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			rgba.Set(x, y, img.At(x, y))
+		}
+	}
+	sameRGBA(t, p.Run(rgba), p.Run(opaque{rgba}))
+}
+
+func Test_CompileErrors(t *testing.T) {
+	p := compile(t, "; comment\n\n? ? ? ?  0 0 0 255 ; black\n")
+	if p.Len() != 1 {
+		t.Fatalf("compiled %d rules; want 1", p.Len())
+	}
+
+	for _, src := range []string{
+		"? ? ? ?  0 0 0",
+		"? ? ? ?  0 0 0 255\n+10 ? ? ?  0 0 0 255",
+		"? ? ? ?  0 0 0 255\n\n? ? ? ?  #q 0 0 255",
+	} {
+		_, err := Compile(strings.NewReader(src))
+		if err == nil {
+			t.Fatalf("Compile(%q) succeeded; want an error", src)
+		}
+
+		line := strings.Count(src, "\n") + 1
+		if !strings.HasPrefix(err.Error(), fmt.Sprintf("Line %d:", line)) {
+			t.Fatalf("Compile(%q): %v; want an error for line %d", src, err, line)
+		}
+	}
+}
+
+func Test_NonFiniteValues(t *testing.T) {
+	for _, src := range []string{
+		"NaN ? ? ?  0 ? ? ?",
+		"? ? ? ?  NaN ? ? ?",
+		"Inf ? ? ?  0 ? ? ?",
+		"? ? ? ?  +Inf ? ? ?",
+		"? ? ? ?  -infinity ? ? ?",
+		">inf ? ? ?  0 ? ? ?",
+		"? ? ? ?  #r*Inf ? ? ?",
+		"? ? ? ?  0 ? ? ?  if #r<NaN",
+		"hsl ? ? ? ?  ? NaN% ? ?",
+	} {
+		if _, err := Compile(strings.NewReader(src)); err == nil {
+			t.Errorf("%q: expected an error", src)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package lib
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"strings"
+)
+
+// frame describes the image a program runs on.
+type frame struct {
+	width, height int
+	mask          *image.Gray // Optional mask, aligned with the image.
+}
+
+// newFrame returns the frame for an image with the given bounds and
+// an optional mask. The mask is converted to grayscale, and its top-left
+// corner is aligned with that of the image.
+func newFrame(b image.Rectangle, mask image.Image) (*frame, error) {
+	f := &frame{width: b.Dx(), height: b.Dy()}
+	if mask == nil {
+		return f, nil
+	}
+
+	mb := mask.Bounds()
+	if mb.Dx() != f.width || mb.Dy() != f.height {
+		return nil, fmt.Errorf("Mask size %dx%d does not match the image size %dx%d",
+			mb.Dx(), mb.Dy(), f.width, f.height)
+	}
+
+	f.mask = image.NewGray(image.Rect(0, 0, f.width, f.height))
+	draw.Draw(f.mask, f.mask.Rect, mask, mb.Min, draw.Src)
+	return f, nil
+}
+
+// maskValue returns the mask value at the given pixel, or 0 without a mask.
+func (f *frame) maskValue(x, y int) uint8 {
+	if f.mask == nil {
+		return 0
+	}
+	return f.mask.Pix[y*f.mask.Stride+x]
+}
+
+// region restricts a rule to part of the image.
+type region struct {
+	shape  string   // rect, circle or mask.
+	args   []Number // Position and size, in pixels or percentages.
+	negate bool     // Whether the rule applies outside of the region.
+}
+
+// shapes lists the known region shapes, with their number of arguments.
+var shapes = map[string]int{
+	"rect":   4, // x, y, width, height
+	"circle": 3, // center x, center y, radius
+	"mask":   0,
+}
+
+// isRegion returns true if data looks like a region clause.
+func isRegion(data string) bool {
+	data = strings.TrimPrefix(data, "!")
+	return data == "mask" || strings.HasPrefix(data, "rect(") || strings.HasPrefix(data, "circle(")
+}
+
+// parseRegion parses a region clause, like "rect(0,0,50%,100%)",
+// "circle(100,100,20)" or "mask". A leading "!" selects everything
+// outside of the region.
+func parseRegion(data string) (region, error) {
+	var r region
+
+	if strings.HasPrefix(data, "!") {
+		r.negate = true
+		data = data[1:]
+	}
+
+	r.shape = data
+	var args string
+
+	if idx := strings.IndexByte(data, '('); idx > -1 {
+		if !strings.HasSuffix(data, ")") {
+			return r, fmt.Errorf("Invalid region: %s", data)
+		}
+
+		r.shape = data[:idx]
+		args = data[idx+1 : len(data)-1]
+	}
+
+	list, err := splitFields(args)
+	if err != nil {
+		return r, err
+	}
+
+	if n, ok := shapes[r.shape]; !ok || len(list) != n {
+		return r, fmt.Errorf("Invalid region %q; expected rect(x,y,w,h), circle(x,y,r) or mask", data)
+	}
+
+	for i, v := range list {
+		c, err := parseValue(v)
+		if err != nil {
+			return r, err
+		}
+
+		num, ok := c.(Number)
+		if !ok {
+			return r, fmt.Errorf("Invalid region value %s in %s", v, data)
+		}
+
+		switch num.Operator {
+		case "":
+		case "-":
+			num.Value = -num.Value
+		default:
+			return r, fmt.Errorf("Operator %q is not valid in a region.", num.Operator)
+		}
+
+		// Sizes can not be negative.
+		if num.Value < 0 && i > 1 {
+			return r, fmt.Errorf("Invalid region size %g in %s", num.Value, data)
+		}
+
+		num.Operator = ""
+		r.args = append(r.args, num)
+	}
+
+	return r, nil
+}
+
+// contains returns true if the pixel lies in the region, or outside
+// of it for negated regions.
+func (r *region) contains(pix *pixel) bool {
+	f := pix.frame
+
+	// arg returns argument i in pixels. Percentages are relative to
+	// the size of the image along the axis: horizontal for even
+	// arguments, vertical for odd ones, and the smaller of both for
+	// a circle's radius.
+	arg := func(i int) float64 {
+		num := r.args[i]
+		if !num.Percentage {
+			return num.Value
+		}
+
+		size := f.width
+		if i%2 == 1 {
+			size = f.height
+		}
+
+		if r.shape == "circle" && i == 2 && f.height < size {
+			size = f.height
+		}
+
+		return num.Value * 0.01 * float64(size)
+	}
+
+	var in bool
+	x, y := float64(pix.x)+0.5, float64(pix.y)+0.5
+
+	switch r.shape {
+	case "rect":
+		rx, ry := arg(0), arg(1)
+		in = x >= rx && y >= ry && x < rx+arg(2) && y < ry+arg(3)
+
+	case "circle":
+		dx, dy, radius := x-arg(0), y-arg(1), arg(2)
+		in = dx*dx+dy*dy <= radius*radius
+
+	case "mask":
+		in = f.maskValue(pix.x, pix.y) > 0
+	}
+
+	return in != r.negate
+}
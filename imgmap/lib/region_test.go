@@ -0,0 +1,170 @@
+package lib
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// regionPixels runs expr on a black 8x6 image with the given mask,
+// and returns a map of the pixels which turned white.
+func regionPixels(t *testing.T, expr string, mask image.Image) string {
+	img := image.NewRGBA(image.Rect(10, 20, 18, 26))
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 255
+	}
+
+	out, err := compile(t, expr).RunMask(img, mask)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	b := out.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		var line []byte
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if out.RGBAAt(x, y).R > 0 {
+				line = append(line, '#')
+			} else {
+				line = append(line, '.')
+			}
+		}
+		lines = append(lines, string(line))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func Test_Regions(t *testing.T) {
+	mask := image.NewGray(image.Rect(0, 0, 8, 6))
+	for x := 0; x < 8; x++ {
+		mask.SetGray(x, x%6, color.Gray{1})
+	}
+
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"? ? ? ?  255 ? ? ?  if rect(1,2,3,2)", `
+........
+........
+.###....
+.###....
+........
+........`},
+		{"? ? ? ?  255 ? ? ?  if rect(50%,0,50%,50%)", `
+....####
+....####
+....####
+........
+........
+........`},
+		{"? ? ? ?  255 ? ? ?  if !rect(1,1,6,4)", `
+########
+#......#
+#......#
+#......#
+#......#
+########`},
+		{"? ? ? ?  255 ? ? ?  if circle(4,3,2)", `
+........
+...##...
+..####..
+..####..
+...##...
+........`},
+		{"? ? ? ?  255 ? ? ?  if circle(0,0,50%) rect(0,0,100%,2)", `
+###.....
+###.....
+........
+........
+........
+........`},
+		{"? ? ? ?  255 ? ? ?  if mask", `
+#.....#.
+.#.....#
+..#.....
+...#....
+....#...
+.....#..`},
+		{"? ? ? ?  255 ? ? ?  if !mask #y<1", `
+.#####.#
+........
+........
+........
+........
+........`},
+		{"? ? ? ?  #m*255 ? ? ?  if #x>=4", `
+......#.
+.......#
+........
+........
+....#...
+.....#..`},
+		{"? ? ? ?  mod(floor(#x/2)+floor(#y/2),2)*255 ? ? ?", `
+..##..##
+..##..##
+##..##..
+##..##..
+..##..##
+..##..##`},
+		{"? ? ? ?  #x*255/(#w-1) ? ? ?  if #y=0..1", `
+.#######
+.#######
+........
+........
+........
+........`},
+	}
+
+	for _, tt := range tests {
+		got := regionPixels(t, tt.expr, mask)
+		if want := strings.TrimPrefix(tt.want, "\n"); got != want {
+			t.Errorf("%q:\n%s\nwant:\n%s", tt.expr, got, want)
+		}
+	}
+}
+
+func Test_RegionMask(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 6))
+
+	p := compile(t, "? ? ? ?  255 ? ? ?  if !mask")
+	if !p.UsesMask() {
+		t.Fatalf("UsesMask is false")
+	}
+
+	if compile(t, "? ? ? ?  255 ? ? ?  if rect(0,0,1,1)").UsesMask() {
+		t.Fatalf("UsesMask is true without a mask reference")
+	}
+
+	if !compile(t, "? ? ? ?  #m ? ? ?").UsesMask() {
+		t.Fatalf("UsesMask is false for #m")
+	}
+
+	if _, err := p.RunMask(img, image.NewGray(image.Rect(0, 0, 8, 5))); err == nil {
+		t.Fatalf("Expected an error for a mask of the wrong size")
+	}
+
+	// Without a mask, nothing is in it.
+	if got := p.Run(img).RGBAAt(3, 3).R; got != 255 {
+		t.Fatalf("Pixel outside of the missing mask is %d; want 255", got)
+	}
+}
+
+func Test_RegionErrors(t *testing.T) {
+	for _, expr := range []string{
+		"? ? ? ?  ? ? ? ?  if rect(0,0,1)",
+		"? ? ? ?  ? ? ? ?  if rect(0,0,-1,1)",
+		"? ? ? ?  ? ? ? ?  if circle(0,0)",
+		"? ? ? ?  ? ? ? ?  if circle(0,0,<5)",
+		"? ? ? ?  ? ? ? ?  if circle(0,0,#r)",
+		"? ? ? ?  ? ? ? ?  if rect(0,0,1,1",
+		"? ? ? ?  ? ? ? ?  if #x<50%",
+	} {
+		if _, err := parseLine([]byte(expr)); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
@@ -23,13 +23,15 @@ func ParseRule(data string) (*Rule, error) {
 		return nil, fmt.Errorf("Invalid Rule value")
 	}
 
-	list := splitString(strings.Replace(data, " ", ",", -1), ",")
+	list, err := splitFields(data)
+	if err != nil {
+		return nil, err
+	}
 
 	if len(list) != 4 {
 		return nil, fmt.Errorf("Invalid Rule value %q", data)
 	}
 
-	var err error
 	c := new(Rule)
 
 	c.R, err = parseChannel(list[0])
@@ -0,0 +1,332 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package lib
+
+import (
+	"fmt"
+	"math"
+)
+
+// Space identifies the color space in which a rule
+// matches and transforms colors.
+type Space uint8
+
+// Known color spaces.
+const (
+	RGB Space = iota // Red, green, blue and alpha: 0-255 each.
+	HSL              // Hue: 0-360, saturation and lightness: 0-100, alpha: 0-255.
+	HSV              // Hue: 0-360, saturation and value: 0-100, alpha: 0-255.
+	Lab              // CIE L*: 0-100, a* and b*: -128-127, alpha: 0-255.
+)
+
+var spaceNames = [...]string{"rgb", "hsl", "hsv", "lab"}
+
+func (s Space) String() string { return spaceNames[s] }
+
+// parseSpace returns the color space with the given name.
+func parseSpace(name string) (Space, bool) {
+	for i, v := range spaceNames {
+		if v == name {
+			return Space(i), true
+		}
+	}
+	return RGB, false
+}
+
+// channelRange describes the values of a color channel.
+type channelRange struct {
+	name     string
+	min, max float64
+	wrap     bool // Values wrap around, like the hue angle.
+	integer  bool // Values are whole numbers.
+}
+
+var alphaRange = channelRange{"alpha", 0, 255, false, true}
+
+// ranges holds the channels of each color space.
+var ranges = [...][4]channelRange{
+	RGB: {{"red", 0, 255, false, true}, {"green", 0, 255, false, true}, {"blue", 0, 255, false, true}, alphaRange},
+	HSL: {{"hue", 0, 360, true, false}, {"saturation", 0, 100, false, false}, {"lightness", 0, 100, false, false}, alphaRange},
+	HSV: {{"hue", 0, 360, true, false}, {"saturation", 0, 100, false, false}, {"value", 0, 100, false, false}, alphaRange},
+	Lab: {{"L*", 0, 100, false, false}, {"a*", -128, 127, false, false}, {"b*", -128, 127, false, false}, alphaRange},
+}
+
+// grayRange describes the derived grayscale values.
+var grayRange = channelRange{"gray", 0, 255, false, true}
+
+// coordRange describes pixel coordinates and image sizes.
+// These have no upper bound.
+var coordRange = channelRange{"coordinate", 0, math.Inf(1), false, true}
+
+// nameRange returns the range of values of a named reference.
+func nameRange(n Name) *channelRange {
+	switch {
+	case n <= NameA:
+		return &ranges[RGB][n-NameR]
+	case n <= NameAverage:
+		return &grayRange
+	case n <= NameHSLLightness:
+		return &ranges[HSL][n-NameHSLHue]
+	case n <= NameHSVValue:
+		return &ranges[HSV][n-NameHSVHue]
+	case n <= NameLabB:
+		return &ranges[Lab][n-NameLabL]
+	case n == NameMask:
+		return &grayRange
+	}
+	return &coordRange
+}
+
+// clamp limits v to the range of the channel.
+// Wrapping channels are taken modulo their range.
+// Values which are not a number yield the lowest value.
+func (c *channelRange) clamp(v float64) float64 {
+	if math.IsNaN(v) {
+		return c.min
+	}
+
+	if c.wrap {
+		if math.IsInf(v, 0) {
+			return c.min
+		}
+
+		v = math.Mod(v-c.min, c.max-c.min)
+		if v < 0 {
+			v += c.max - c.min
+		}
+		return v + c.min
+	}
+
+	return math.Max(c.min, math.Min(c.max, v))
+}
+
+// check returns an error if v is outside of the channel's range.
+func (c *channelRange) check(v float64) error {
+	if v < c.min || v > c.max {
+		return fmt.Errorf("Value %g is outside of the %s range [%g, %g]", v, c.name, c.min, c.max)
+	}
+	return nil
+}
+
+// colors returns the channels of the pixel in the given space.
+//
+// RGB rules operate on the stored, alpha-premultiplied values. All other
+// spaces operate on the color without premultiplied alpha.
+func (pix *pixel) colors(s Space) [4]float64 {
+	if s == RGB {
+		return [4]float64{float64(pix.r), float64(pix.g), float64(pix.b), float64(pix.a)}
+	}
+
+	r, g, b := pix.unpremultiply()
+
+	var c [4]float64
+	switch s {
+	case HSL:
+		c[0], c[1], c[2] = rgbToHSL(r, g, b)
+	case HSV:
+		c[0], c[1], c[2] = rgbToHSV(r, g, b)
+	case Lab:
+		c[0], c[1], c[2] = rgbToLab(r, g, b)
+	}
+
+	c[3] = float64(pix.a)
+	return c
+}
+
+// setColors sets the pixel to the color c in the given space.
+// The channels are expected to be within range.
+func (pix *pixel) setColors(s Space, c [4]float64) {
+	if s == RGB {
+		pix.r, pix.g, pix.b, pix.a = uint8(c[0]+0.5), uint8(c[1]+0.5), uint8(c[2]+0.5), uint8(c[3]+0.5)
+		return
+	}
+
+	var r, g, b float64
+	switch s {
+	case HSL:
+		r, g, b = hslToRGB(c[0], c[1], c[2])
+	case HSV:
+		r, g, b = hsvToRGB(c[0], c[1], c[2])
+	case Lab:
+		r, g, b = labToRGB(c[0], c[1], c[2])
+	}
+
+	pix.a = uint8(c[3] + 0.5)
+	pix.r = premultiply(r, pix.a)
+	pix.g = premultiply(g, pix.a)
+	pix.b = premultiply(b, pix.a)
+}
+
+// unpremultiply returns the color channels of the pixel
+// in the range [0, 1], without premultiplied alpha.
+func (pix *pixel) unpremultiply() (r, g, b float64) {
+	if pix.a == 0 {
+		return 0, 0, 0
+	}
+
+	a := float64(pix.a)
+	return math.Min(1, float64(pix.r)/a), math.Min(1, float64(pix.g)/a), math.Min(1, float64(pix.b)/a)
+}
+
+// premultiply returns the color channel v in the range
+// [0, 1] as an 8 bit value, premultiplied by alpha.
+func premultiply(v float64, alpha uint8) uint8 {
+	v = math.Max(0, math.Min(1, v))
+	return uint8(v*float64(alpha) + 0.5)
+}
+
+// rgbToHSL converts a color in the range [0, 1] to hue, saturation and
+// lightness. The hue is in degrees, the others are percentages.
+func rgbToHSL(r, g, b float64) (h, s, l float64) {
+	max := math.Max(math.Max(r, g), b)
+	min := math.Min(math.Min(r, g), b)
+	l = (max + min) / 2
+
+	if d := max - min; d > 0 {
+		s = d / (1 - math.Abs(2*l-1))
+		h = hue(r, g, b, max, d)
+	}
+
+	return h, 100 * s, 100 * l
+}
+
+func hslToRGB(h, s, l float64) (r, g, b float64) {
+	s /= 100
+	l /= 100
+	c := (1 - math.Abs(2*l-1)) * s
+	return fromHue(h, c, l-c/2)
+}
+
+// rgbToHSV converts a color in the range [0, 1] to hue, saturation and
+// value. The hue is in degrees, the others are percentages.
+func rgbToHSV(r, g, b float64) (h, s, v float64) {
+	max := math.Max(math.Max(r, g), b)
+	min := math.Min(math.Min(r, g), b)
+
+	if d := max - min; d > 0 {
+		s = d / max
+		h = hue(r, g, b, max, d)
+	}
+
+	return h, 100 * s, 100 * max
+}
+
+func hsvToRGB(h, s, v float64) (r, g, b float64) {
+	s /= 100
+	v /= 100
+	c := v * s
+	return fromHue(h, c, v-c)
+}
+
+// hue returns the hue angle of a color, given its largest channel
+// value and its chroma d, which must be non-zero.
+func hue(r, g, b, max, d float64) float64 {
+	var h float64
+	switch max {
+	case r:
+		h = math.Mod((g-b)/d+6, 6)
+	case g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+	return 60 * h
+}
+
+// fromHue returns the color with the given hue angle and chroma c,
+// offset by m. This is the common part of the HSL and HSV conversions.
+func fromHue(h, c, m float64) (r, g, b float64) {
+	h = math.Mod(h, 360) / 60
+	x := c * (1 - math.Abs(math.Mod(h, 2)-1))
+
+	switch int(h) {
+	case 0:
+		r, g, b = c, x, 0
+	case 1:
+		r, g, b = x, c, 0
+	case 2:
+		r, g, b = 0, c, x
+	case 3:
+		r, g, b = 0, x, c
+	case 4:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+
+	return r + m, g + m, b + m
+}
+
+// Reference white of the D65 illuminant, which sRGB is defined for.
+const (
+	whiteX = 0.95047
+	whiteY = 1.0
+	whiteZ = 1.08883
+)
+
+// rgbToLab converts an sRGB color in the range [0, 1] to CIE L*a*b*.
+func rgbToLab(r, g, b float64) (l, a, bb float64) {
+	r, g, b = linearSRGB(r), linearSRGB(g), linearSRGB(b)
+
+	x := (0.4124564*r + 0.3575761*g + 0.1804375*b) / whiteX
+	y := (0.2126729*r + 0.7151522*g + 0.0721750*b) / whiteY
+	z := (0.0193339*r + 0.1191920*g + 0.9503041*b) / whiteZ
+
+	fx, fy, fz := labF(x), labF(y), labF(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// labToRGB converts a CIE L*a*b* color to sRGB in the range [0, 1].
+// Colors outside of the sRGB gamut are clipped.
+func labToRGB(l, a, bb float64) (r, g, b float64) {
+	fy := (l + 16) / 116
+	fx := fy + a/500
+	fz := fy - bb/200
+
+	x := labFInv(fx) * whiteX
+	y := labFInv(fy) * whiteY
+	z := labFInv(fz) * whiteZ
+
+	r = 3.2404542*x - 1.5371385*y - 0.4985314*z
+	g = -0.9692660*x + 1.8760108*y + 0.0415560*z
+	b = 0.0556434*x - 0.2040259*y + 1.0572252*z
+
+	return gammaSRGBf(r), gammaSRGBf(g), gammaSRGBf(b)
+}
+
+func labF(t float64) float64 {
+	if t > 216.0/24389 {
+		return math.Cbrt(t)
+	}
+	return (24389.0/27*t + 16) / 116
+}
+
+func labFInv(t float64) float64 {
+	if t3 := t * t * t; t3 > 216.0/24389 {
+		return t3
+	}
+	return (116*t - 16) * 27 / 24389
+}
+
+// linearSRGB removes the sRGB gamma from a value in the range [0, 1].
+func linearSRGB(c float64) float64 {
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+// gammaSRGBf applies the sRGB gamma to a linear value,
+// and clips the result to the range [0, 1].
+func gammaSRGBf(v float64) float64 {
+	if v <= 0 {
+		return 0
+	}
+
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+
+	return math.Min(1, 1.055*math.Pow(v, 1/2.4)-0.055)
+}
@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func Test_SpaceRoundTrip(t *testing.T) {
+	for _, s := range []Space{HSL, HSV, Lab} {
+		for r := 0; r < 256; r += 5 {
+			for g := 0; g < 256; g += 3 {
+				for b := 0; b < 256; b += 7 {
+					pix := pixel{r: uint8(r), g: uint8(g), b: uint8(b), a: 255}
+					want := pix
+
+					pix.setColors(s, pix.colors(s))
+					if pix != want {
+						t.Fatalf("%v: %v round-trips to %v", s, want, pix)
+					}
+				}
+			}
+		}
+	}
+}
+
+func Test_SpaceConversions(t *testing.T) {
+	tests := []struct {
+		s    Space
+		c    color.RGBA
+		want [3]float64
+	}{
+		{HSL, color.RGBA{255, 0, 0, 255}, [3]float64{0, 100, 50}},
+		{HSL, color.RGBA{0, 0, 255, 255}, [3]float64{240, 100, 50}},
+		{HSL, color.RGBA{128, 128, 128, 255}, [3]float64{0, 0, 50.196}},
+		{HSV, color.RGBA{0, 255, 0, 255}, [3]float64{120, 100, 100}},
+		{HSV, color.RGBA{128, 64, 128, 255}, [3]float64{300, 50, 50.196}},
+		{Lab, color.RGBA{255, 255, 255, 255}, [3]float64{100, 0, 0}},
+		{Lab, color.RGBA{255, 0, 0, 255}, [3]float64{53.241, 80.092, 67.203}},
+		{Lab, color.RGBA{64, 32, 64, 128}, [3]float64{37.363, 37.210, -24.206}},
+	}
+
+	for _, tt := range tests {
+		pix := pixel{r: tt.c.R, g: tt.c.G, b: tt.c.B, a: tt.c.A}
+		got := pix.colors(tt.s)
+
+		for i := range tt.want {
+			if math.Abs(got[i]-tt.want[i]) > 0.01 {
+				t.Errorf("%v %v: %.3f; want %v", tt.s, tt.c, got[:3], tt.want)
+				break
+			}
+		}
+
+		if got[3] != float64(tt.c.A) {
+			t.Errorf("%v %v: alpha %v; want %d", tt.s, tt.c, got[3], tt.c.A)
+		}
+	}
+}
+
+func Test_HueShift(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 1))
+	img.SetRGBA(0, 0, color.RGBA{0, 0, 255, 255})   // hue 240, matches
+	img.SetRGBA(1, 0, color.RGBA{0, 128, 255, 255}) // hue 210, matches
+	img.SetRGBA(2, 0, color.RGBA{255, 0, 0, 255})   // hue 0
+	img.SetRGBA(3, 0, color.RGBA{70, 70, 90, 255})  // hue 240, saturation 12.5%
+
+	p := compile(t, "hsl >=200 >50% ? ?   +30 ? ? ?\nhsv <30 ? ? ?  -60 ? ? ?")
+	out := p.Run(img)
+
+	want := []color.RGBA{
+		{128, 0, 255, 255},
+		{0, 0, 255, 255},
+		{255, 0, 255, 255}, // hue wraps around to 300
+		{70, 70, 90, 255},
+	}
+
+	for x, w := range want {
+		if got := out.RGBAAt(x, 0); got != w {
+			t.Errorf("pixel %d: %v; want %v", x, got, w)
+		}
+	}
+}
+
+func Test_SpaceErrors(t *testing.T) {
+	for _, expr := range []string{
+		"xyz ? ? ? ?  ? ? ? ?",
+		"hsl 400 ? ? ?  ? ? ? ?",
+		"hsl ? ? ? ?  ? 120 ? ?",
+		"hsl ? ? ? ?  +400 ? ? ?",
+		"lab ? ? ? ?  ? =-200 ? ?",
+		"rgb ? ? ? ?  256 ? ? ?",
+		"hsl ? 150% ? ?  ? ? ? ?",
+	} {
+		if _, err := parseLine([]byte(expr)); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+
+	for _, expr := range []string{
+		"rgb ? ? ? ?  ? ? ? ?",
+		"lab ? -20 <-10.5 ?  ? =-20 ? ?",
+		"hsv ? 50% ? ?  ? ? #L ?",
+	} {
+		if _, err := parseLine([]byte(expr)); err != nil {
+			t.Errorf("%q: %v", expr, err)
+		}
+	}
+}
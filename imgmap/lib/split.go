@@ -3,37 +3,60 @@
 
 package lib
 
-import (
-	"bytes"
-	"strings"
-)
-
-// splitString splits the input data and returns a list with empty elements removed.
-func splitString(data, token string) []string {
-	a := strings.Split(data, token)
-	b := make([]string, 0, len(a))
-
-	for _, v := range a {
-		v = strings.TrimSpace(v)
-		if len(v) > 0 {
-			b = append(b, v)
+import "fmt"
+
+// splitFields splits the input data on whitespace and commas, and returns
+// a list with empty elements removed. Separators inside parentheses or
+// braces do not split a field, so an expression like "min(#r, #g)" or a
+// set like "{0, 255}" stays in one piece.
+func splitFields(data string) ([]string, error) {
+	var list []string
+	var parens, braces, start int
+
+	for i := 0; i < len(data); i++ {
+		switch data[i] {
+		case '(':
+			parens++
+
+		case ')':
+			parens--
+			if parens < 0 {
+				return nil, fmt.Errorf("Unbalanced parentheses in %q", data)
+			}
+
+		case '{':
+			braces++
+
+		case '}':
+			braces--
+			if braces < 0 {
+				return nil, fmt.Errorf("Unbalanced braces in %q", data)
+			}
+
+		case ' ', '\t', '\r', '\n', ',':
+			if parens > 0 || braces > 0 {
+				continue
+			}
+
+			if i > start {
+				list = append(list, data[start:i])
+			}
+
+			start = i + 1
 		}
 	}
 
-	return b
-}
+	if parens > 0 {
+		return nil, fmt.Errorf("Unbalanced parentheses in %q", data)
+	}
 
-// splitBytes splits the input data and returns a list with empty elements removed.
-func splitBytes(data, token []byte) [][]byte {
-	a := bytes.Split(data, token)
-	b := make([][]byte, 0, len(a))
+	if braces > 0 {
+		return nil, fmt.Errorf("Unbalanced braces in %q", data)
+	}
 
-	for _, v := range a {
-		v = bytes.TrimSpace(v)
-		if len(v) > 0 {
-			b = append(b, v)
-		}
+	if len(data) > start {
+		list = append(list, data[start:])
 	}
 
-	return b
+	return list, nil
 }
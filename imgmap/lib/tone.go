@@ -0,0 +1,629 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package lib
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// toneTable maps each 8 bit channel value onto a new value in [0, 1].
+type toneTable [256]float64
+
+// curves is a stage which maps the red, green and blue channels of every
+// pixel through a table. Channels without a table are left as-is, as is
+// alpha. This implements levels and curves.
+type curves [3]*toneTable
+
+// autoLevels is a stage which stretches the channels of an image to their
+// full range. The darkest and lightest clip percent of the values are
+// clipped. With linked channels, all channels are stretched by the same
+// amount, which keeps their balance. Otherwise, each channel is stretched
+// on its own, which also removes color casts.
+type autoLevels struct {
+	clip   float64
+	linked bool
+}
+
+// equalize is a stage which equalizes the histogram of the value
+// (the largest of the red, green and blue channels) of an image.
+// Colors are scaled to the new value, which keeps their hue and
+// saturation. With tiles > 0, this is contrast limited adaptive
+// histogram equalization (CLAHE): each of tiles x tiles parts of
+// the image is equalized on its own, with no bin of its histogram
+// exceeding limit times the average.
+type equalize struct {
+	tiles int
+	limit float64
+}
+
+// Defaults for the tone operations.
+const (
+	defaultClip       = 0.5 // Percent.
+	defaultCLAHETiles = 8
+	defaultCLAHELimit = 2
+	maxCLAHETiles     = 64
+)
+
+// AddDirective appends a whole-image operation to the program, in the
+// form it has in a map file. Relative file names are relative to the
+// current directory. For example:
+//
+//	p.AddDirective("levels in=16..235 gamma=1.2")
+//	p.AddDirective("autocontrast clip=1%")
+func (p *Program) AddDirective(line string) error {
+	st, err := parseDirective([]byte(line), "")
+	if err != nil {
+		return err
+	}
+
+	if st == nil {
+		return fmt.Errorf("Unknown operation: %q", line)
+	}
+
+	p.add(st)
+	return nil
+}
+
+// parseLevels parses a levels adjustment in a map file, of the form:
+//
+//	levels [in=LO..HI] [gamma=G] [out=LO..HI] [channels=rgb]
+//
+// Input values from LO to HI are stretched to the output range. Values
+// outside of the input range are clipped. A gamma above 1 brightens
+// the midtones, and one below 1 darkens them.
+func parseLevels(args []string) (stage, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("Invalid levels; expected: levels [in=LO..HI] [gamma=G] [out=LO..HI] [channels=rgb]")
+	}
+
+	in, out := [2]float64{0, 255}, [2]float64{0, 255}
+	gamma := 1.0
+	channels := [3]bool{true, true, true}
+
+	var err error
+	for _, v := range args {
+		switch {
+		case strings.HasPrefix(v, "in="):
+			in, err = parseSpan(v[3:])
+			if err == nil && in[0] == in[1] {
+				err = fmt.Errorf("Empty input range: %s", v[3:])
+			}
+
+		case strings.HasPrefix(v, "out="):
+			out, err = parseSpan(v[4:])
+
+		case strings.HasPrefix(v, "gamma="):
+			gamma, err = strconv.ParseFloat(v[6:], 64)
+			if err != nil || !(gamma >= 0.01 && gamma <= 100) {
+				err = fmt.Errorf("Invalid gamma: %s; expected 0.01-100", v[6:])
+			}
+
+		case strings.HasPrefix(v, "channels="):
+			channels, err = parseChannels(v[9:])
+
+		default:
+			err = fmt.Errorf("Invalid levels option %q; expected in=LO..HI, gamma=G, out=LO..HI or channels=rgb", v)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return newCurves(levels(in, gamma, out), channels), nil
+}
+
+// parseCurve parses a curve in a map file, of the form:
+//
+//	curve [channels=rgb] X:Y X:Y ...
+//
+// The curve passes through the given points, which need increasing X
+// values. Values below the first and above the last point map onto the
+// Y value of that point. In between, the curve is a monotone cubic
+// spline, which does not overshoot the points.
+func parseCurve(args []string) (stage, error) {
+	channels := [3]bool{true, true, true}
+	var xs, ys []float64
+
+	for _, v := range args {
+		if strings.HasPrefix(v, "channels=") {
+			var err error
+			if channels, err = parseChannels(v[9:]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		idx := strings.IndexByte(v, ':')
+		if idx == -1 {
+			return nil, fmt.Errorf("Invalid curve point %q; expected X:Y", v)
+		}
+
+		x, errx := strconv.ParseFloat(v[:idx], 64)
+		y, erry := strconv.ParseFloat(v[idx+1:], 64)
+		if errx != nil || erry != nil || x < 0 || x > 255 || y < 0 || y > 255 {
+			return nil, fmt.Errorf("Invalid curve point %q; expected X:Y in the range 0-255", v)
+		}
+
+		if len(xs) > 0 && x <= xs[len(xs)-1] {
+			return nil, fmt.Errorf("Curve point %q must have a larger X than the one before it", v)
+		}
+
+		xs = append(xs, x)
+		ys = append(ys, y)
+	}
+
+	if len(xs) < 2 {
+		return nil, fmt.Errorf("Invalid curve; expected: curve [channels=rgb] X:Y X:Y ...")
+	}
+
+	return newCurves(spline(xs, ys), channels), nil
+}
+
+// parseAutoLevels parses an autolevels or autocontrast operation
+// in a map file, of the form:
+//
+//	autolevels [clip=N%]
+//	autocontrast [clip=N%]
+//
+// Autolevels stretches each channel on its own, autocontrast
+// stretches them together.
+func parseAutoLevels(linked bool) func(args []string) (stage, error) {
+	return func(args []string) (stage, error) {
+		st := &autoLevels{clip: defaultClip, linked: linked}
+
+		for _, v := range args {
+			if !strings.HasPrefix(v, "clip=") {
+				return nil, fmt.Errorf("Invalid option %q; expected clip=N%%", v)
+			}
+
+			n, err := strconv.ParseFloat(strings.TrimSuffix(v[5:], "%"), 64)
+			if err != nil || !(n >= 0 && n < 50) {
+				return nil, fmt.Errorf("Invalid clip percentage: %s; expected 0-50%%", v[5:])
+			}
+			st.clip = n
+		}
+
+		return st, nil
+	}
+}
+
+// parseEqualize parses a global histogram equalization in a map file,
+// which has no options.
+func parseEqualize(args []string) (stage, error) {
+	if len(args) > 0 {
+		return nil, fmt.Errorf("Invalid option %q; equalize has no options", args[0])
+	}
+	return &equalize{}, nil
+}
+
+// parseCLAHE parses an adaptive histogram equalization in a map file,
+// of the form:
+//
+//	clahe [tiles=N] [limit=N]
+func parseCLAHE(args []string) (stage, error) {
+	st := &equalize{tiles: defaultCLAHETiles, limit: defaultCLAHELimit}
+
+	for _, v := range args {
+		switch {
+		case strings.HasPrefix(v, "tiles="):
+			n, err := strconv.Atoi(v[6:])
+			if err != nil || n < 1 || n > maxCLAHETiles {
+				return nil, fmt.Errorf("Invalid tile count: %s; expected 1-%d", v[6:], maxCLAHETiles)
+			}
+			st.tiles = n
+
+		case strings.HasPrefix(v, "limit="):
+			n, err := strconv.ParseFloat(v[6:], 64)
+			if err != nil || !(n >= 1) {
+				return nil, fmt.Errorf("Invalid clip limit: %s; expected 1 or more", v[6:])
+			}
+			st.limit = n
+
+		default:
+			return nil, fmt.Errorf("Invalid clahe option %q; expected tiles=N or limit=N", v)
+		}
+	}
+
+	return st, nil
+}
+
+// parseSpan parses a range of 8 bit values, like "16..235".
+func parseSpan(data string) ([2]float64, error) {
+	var v [2]float64
+
+	parts := strings.Split(data, "..")
+	if len(parts) != 2 {
+		return v, fmt.Errorf("Invalid range %q; expected LO..HI", data)
+	}
+
+	for i, p := range parts {
+		n, err := strconv.ParseFloat(p, 64)
+		if err != nil || n < 0 || n > 255 {
+			return v, fmt.Errorf("Invalid range %q; expected values in the range 0-255", data)
+		}
+		v[i] = n
+	}
+
+	return v, nil
+}
+
+// parseChannels parses a list of color channels, like "rgb" or "b".
+func parseChannels(data string) ([3]bool, error) {
+	var ch [3]bool
+
+	for _, c := range data {
+		idx := strings.IndexRune("rgb", c)
+		if idx == -1 || ch[idx] {
+			return ch, fmt.Errorf("Invalid channels %q; expected a combination of r, g and b", data)
+		}
+		ch[idx] = true
+	}
+
+	if len(data) == 0 {
+		return ch, fmt.Errorf("Missing channels; expected a combination of r, g and b")
+	}
+
+	return ch, nil
+}
+
+// newCurves returns a stage which applies table t to the given channels.
+func newCurves(t *toneTable, channels [3]bool) curves {
+	var c curves
+	for i, ok := range channels {
+		if ok {
+			c[i] = t
+		}
+	}
+	return c
+}
+
+// levels returns the table for a levels adjustment. The input
+// and output ranges hold 8 bit values. If the input range is
+// reversed, the image is inverted.
+func levels(in [2]float64, gamma float64, out [2]float64) *toneTable {
+	var t toneTable
+
+	for i := range t {
+		v := (float64(i) - in[0]) / (in[1] - in[0])
+		v = math.Pow(math.Max(0, math.Min(1, v)), 1/gamma)
+		t[i] = (out[0] + v*(out[1]-out[0])) / 255
+	}
+
+	return &t
+}
+
+// spline returns the table for a monotone cubic spline through the
+// given points, using the method of Fritsch and Carlson.
+func spline(xs, ys []float64) *toneTable {
+	n := len(xs)
+
+	// The slopes of the segments, and the tangents at the points.
+	d := make([]float64, n-1)
+	for i := range d {
+		d[i] = (ys[i+1] - ys[i]) / (xs[i+1] - xs[i])
+	}
+
+	m := make([]float64, n)
+	m[0], m[n-1] = d[0], d[n-2]
+	for i := 1; i < n-1; i++ {
+		if d[i-1]*d[i] > 0 {
+			m[i] = (d[i-1] + d[i]) / 2
+		}
+	}
+
+	// Limit the tangents, so the curve is monotone in each segment.
+	for i, s := range d {
+		if s == 0 {
+			m[i], m[i+1] = 0, 0
+			continue
+		}
+
+		a, b := m[i]/s, m[i+1]/s
+		if h := math.Hypot(a, b); h > 3 {
+			m[i] = 3 * a / h * s
+			m[i+1] = 3 * b / h * s
+		}
+	}
+
+	var t toneTable
+	for i := range t {
+		x := float64(i)
+
+		switch {
+		case x <= xs[0]:
+			t[i] = ys[0]
+		case x >= xs[n-1]:
+			t[i] = ys[n-1]
+		default:
+			k := sort.SearchFloat64s(xs, x) - 1
+			h := xs[k+1] - xs[k]
+			u := (x - xs[k]) / h
+
+			// Cubic Hermite basis functions.
+			h00 := (1 + 2*u) * (1 - u) * (1 - u)
+			h10 := u * (1 - u) * (1 - u)
+			h01 := u * u * (3 - 2*u)
+			h11 := u * u * (u - 1)
+			t[i] = h00*ys[k] + h10*h*m[k] + h01*ys[k+1] + h11*h*m[k+1]
+		}
+
+		t[i] = math.Max(0, math.Min(1, t[i]/255))
+	}
+
+	return &t
+}
+
+// eachPixel calls fn for every pixel of img which is not fully
+// transparent, with its un-premultiplied channels as 8 bit values.
+// The new values fn returns, in the range [0, 1], are stored in place.
+// Rows are processed in parallel.
+func eachPixel(img *image.RGBA, fn func(x, y int, c [3]uint8) [3]float64) {
+	b := img.Rect
+
+	parallel(b.Dy(), func(min, max int) {
+		for y := min; y < max; y++ {
+			row := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):img.PixOffset(b.Max.X, b.Min.Y+y)]
+
+			for i := 0; i < len(row); i += 4 {
+				a := row[i+3]
+				if a == 0 {
+					continue
+				}
+
+				v := fn(i/4, y, unpremultiply8(row[i:i+4]))
+				row[i+0] = premultiply(v[0], a)
+				row[i+1] = premultiply(v[1], a)
+				row[i+2] = premultiply(v[2], a)
+			}
+		}
+	})
+}
+
+// unpremultiply8 returns the un-premultiplied channels of px,
+// in the layout of image.RGBA, as 8 bit values.
+func unpremultiply8(px []uint8) [3]uint8 {
+	pix := pixel{r: px[0], g: px[1], b: px[2], a: px[3]}
+	r, g, b := pix.unpremultiply()
+	return [3]uint8{uint8(255*r + 0.5), uint8(255*g + 0.5), uint8(255*b + 0.5)}
+}
+
+// histogram counts the un-premultiplied channel values of all pixels
+// which are not fully transparent.
+func histogram(img *image.RGBA) (h [3][256]int) {
+	b := img.Rect
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+
+		for i := 0; i < len(row); i += 4 {
+			if row[i+3] > 0 {
+				c := unpremultiply8(row[i : i+4])
+				h[0][c[0]]++
+				h[1][c[1]]++
+				h[2][c[2]]++
+			}
+		}
+	}
+	return
+}
+
+// percentiles returns the values below which the lowest and above
+// which the highest clip percent of the counts in h lie.
+func percentiles(h *[256]int, clip float64) (lo, hi float64) {
+	var total int
+	for _, n := range h {
+		total += n
+	}
+
+	limit := int(float64(total) * clip / 100)
+
+	var low, high, sum int
+	for low = 0; low < 255; low++ {
+		if sum += h[low]; sum > limit {
+			break
+		}
+	}
+
+	sum = 0
+	for high = 255; high > 0; high-- {
+		if sum += h[high]; sum > limit {
+			break
+		}
+	}
+
+	return float64(low), float64(high)
+}
+
+func (c curves) len() int       { return 1 }
+func (c curves) usesMask() bool { return false }
+
+func (c curves) run(img *image.RGBA, f *frame) {
+	eachPixel(img, func(x, y int, v [3]uint8) [3]float64 {
+		var out [3]float64
+		for i, t := range c {
+			if t == nil {
+				out[i] = float64(v[i]) / 255
+			} else {
+				out[i] = t[v[i]]
+			}
+		}
+		return out
+	})
+}
+
+func (st *autoLevels) len() int       { return 1 }
+func (st *autoLevels) usesMask() bool { return false }
+
+func (st *autoLevels) run(img *image.RGBA, f *frame) {
+	h := histogram(img)
+
+	if st.linked {
+		for i := range h[0] {
+			h[0][i] += h[1][i] + h[2][i]
+		}
+		h[1], h[2] = h[0], h[0]
+	}
+
+	var c curves
+	for i := range c {
+		if lo, hi := percentiles(&h[i], st.clip); lo < hi {
+			c[i] = levels([2]float64{lo, hi}, 1, [2]float64{0, 255})
+		}
+	}
+
+	c.run(img, f)
+}
+
+func (st *equalize) len() int       { return 1 }
+func (st *equalize) usesMask() bool { return false }
+
+func (st *equalize) run(img *image.RGBA, f *frame) {
+	b := img.Rect
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return
+	}
+
+	// Split the image into nx x ny tiles, or a single one for
+	// global equalization, and compute a table for each.
+	nx, ny := 1, 1
+	if st.tiles > 0 {
+		nx, ny = st.tiles, st.tiles
+		if nx > w {
+			nx = w
+		}
+		if ny > h {
+			ny = h
+		}
+	}
+
+	hists := make([][256]int, nx*ny)
+	for y := 0; y < h; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):img.PixOffset(b.Max.X, b.Min.Y+y)]
+
+		for i := 0; i < len(row); i += 4 {
+			if row[i+3] > 0 {
+				tile := y*ny/h*nx + i/4*nx/w
+				hists[tile][value(unpremultiply8(row[i:i+4]))]++
+			}
+		}
+	}
+
+	tables := make([]*toneTable, len(hists))
+	for i := range hists {
+		tables[i] = equalizeTable(&hists[i], st.limit)
+	}
+
+	// cell returns the tiles before and after coordinate v, along an
+	// axis with n tiles and size pixels, and the weight of the latter.
+	// This interpolates between the tables of the tile centers.
+	cell := func(v, n, size int) (int, int, float64) {
+		g := (float64(v)+0.5)*float64(n)/float64(size) - 0.5
+		t := int(math.Floor(g))
+
+		switch {
+		case t < 0:
+			return 0, 0, 0
+		case t >= n-1:
+			return n - 1, n - 1, 0
+		}
+		return t, t + 1, g - float64(t)
+	}
+
+	eachPixel(img, func(x, y int, c [3]uint8) [3]float64 {
+		x0, x1, wx := cell(x, nx, w)
+		y0, y1, wy := cell(y, ny, h)
+
+		v := value(c)
+		top := tables[y0*nx+x0][v]*(1-wx) + tables[y0*nx+x1][v]*wx
+		bottom := tables[y1*nx+x0][v]*(1-wx) + tables[y1*nx+x1][v]*wx
+		nv := top*(1-wy) + bottom*wy
+
+		if v == 0 {
+			return [3]float64{nv, nv, nv}
+		}
+
+		scale := nv / float64(v)
+		return [3]float64{float64(c[0]) * scale, float64(c[1]) * scale, float64(c[2]) * scale}
+	})
+}
+
+// value returns the largest channel of c, which is the value in HSV.
+func value(c [3]uint8) uint8 {
+	v := c[0]
+	if c[1] > v {
+		v = c[1]
+	}
+	if c[2] > v {
+		v = c[2]
+	}
+	return v
+}
+
+// equalizeTable returns the table which equalizes histogram h. With a
+// limit, no bin may exceed limit times the average, and the excess is
+// spread over all bins. This keeps the contrast of flat areas from
+// being amplified too much.
+func equalizeTable(h *[256]int, limit float64) *toneTable {
+	var t toneTable
+
+	var total float64
+	for _, n := range h {
+		total += float64(n)
+	}
+
+	if total == 0 {
+		for i := range t {
+			t[i] = float64(i) / 255
+		}
+		return &t
+	}
+
+	var bins [256]float64
+	for i, n := range h {
+		bins[i] = float64(n)
+	}
+
+	if limit > 0 {
+		max := math.Max(1, limit*total/256)
+
+		var excess float64
+		for i, n := range bins {
+			if n > max {
+				excess += n - max
+				bins[i] = max
+			}
+		}
+
+		for i := range bins {
+			bins[i] += excess / 256
+		}
+	}
+
+	// Map the cumulative distribution onto [0, 1], starting from the
+	// first value which occurs.
+	var sum, first float64
+	for i, n := range bins {
+		if sum == 0 {
+			first = n
+		}
+		sum += n
+		t[i] = sum
+	}
+
+	for i := range t {
+		if total > first {
+			t[i] = math.Max(0, (t[i]-first)/(total-first))
+		} else {
+			t[i] = float64(i) / 255
+		}
+	}
+
+	return &t
+}
@@ -0,0 +1,187 @@
+package lib
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+)
+
+// runTone applies a single tone operation to img.
+func runTone(t *testing.T, op string, img image.Image) *image.RGBA {
+	var p Program
+	if err := p.AddDirective(op); err != nil {
+		t.Fatalf("%q: %v", op, err)
+	}
+	return p.Run(img)
+}
+
+// grayRamp returns a 256x1 image with gray values from lo to hi.
+func grayRamp(lo, hi float64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 1))
+	for x := 0; x < 256; x++ {
+		v := uint8(lo + (hi-lo)*float64(x)/255 + 0.5)
+		img.SetRGBA(x, 0, color.RGBA{v, v, v, 255})
+	}
+	return img
+}
+
+func Test_Levels(t *testing.T) {
+	tests := []struct {
+		op   string
+		in   color.RGBA
+		want color.RGBA
+	}{
+		{"levels in=50..150", color.RGBA{100, 40, 200, 255}, color.RGBA{128, 0, 255, 255}},
+		{"levels out=255..0", color.RGBA{100, 40, 200, 255}, color.RGBA{155, 215, 55, 255}},
+		{"levels out=100..200 channels=g", color.RGBA{0, 255, 0, 255}, color.RGBA{0, 200, 0, 255}},
+		{"levels gamma=2", color.RGBA{64, 0, 255, 255}, color.RGBA{128, 0, 255, 255}},
+
+		// Alpha is left as-is, and the colors are un-premultiplied.
+		{"levels out=255..0", color.RGBA{50, 0, 100, 100}, color.RGBA{50, 100, 0, 100}},
+	}
+
+	for _, tt := range tests {
+		img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+		img.SetRGBA(0, 0, tt.in)
+
+		if got := runTone(t, tt.op, img).RGBAAt(0, 0); got != tt.want {
+			t.Errorf("%q: %v yields %v; want %v", tt.op, tt.in, got, tt.want)
+		}
+	}
+}
+
+func Test_Curve(t *testing.T) {
+	var p Program
+	if err := p.AddDirective("curve 0:0 64:32 192:224 255:255"); err != nil {
+		t.Fatal(err)
+	}
+
+	table := p.stages[0].(curves)[0]
+
+	// The curve passes through the points, and is monotone in between.
+	for _, pt := range [][2]int{{0, 0}, {64, 32}, {192, 224}, {255, 255}} {
+		if got := table[pt[0]] * 255; math.Abs(got-float64(pt[1])) > 1e-9 {
+			t.Errorf("Curve at %d is %.2f; want %d", pt[0], got, pt[1])
+		}
+	}
+
+	for i := 1; i < 256; i++ {
+		if table[i] < table[i-1] {
+			t.Fatalf("Curve decreases at %d: %.4f < %.4f", i, table[i], table[i-1])
+		}
+	}
+
+	// Outside of the points, the curve is flat.
+	if err := p.AddDirective("curve channels=r 100:50 200:150"); err != nil {
+		t.Fatal(err)
+	}
+
+	table = p.stages[1].(curves)[0]
+	if table[0] != table[100] || table[255] != table[200] || p.stages[1].(curves)[1] != nil {
+		t.Fatalf("Curve is not flat outside of its points, or applies to green")
+	}
+}
+
+func Test_AutoLevels(t *testing.T) {
+	// A dull ramp, with a blue cast.
+	img := grayRamp(60, 180)
+	for i := 2; i < len(img.Pix); i += 4 {
+		img.Pix[i] += 20
+	}
+
+	min, max := func(img *image.RGBA, ch int) uint8 { return img.Pix[ch] },
+		func(img *image.RGBA, ch int) uint8 { return img.Pix[len(img.Pix)-4+ch] }
+
+	out := runTone(t, "autolevels clip=0", img)
+	for ch := 0; ch < 3; ch++ {
+		if min(out, ch) != 0 || max(out, ch) != 255 {
+			t.Fatalf("autolevels: channel %d ranges from %d to %d", ch, min(out, ch), max(out, ch))
+		}
+	}
+
+	// Autocontrast keeps the cast.
+	out = runTone(t, "autocontrast clip=0", img)
+	if min(out, 0) != 0 || max(out, 2) != 255 || min(out, 2) <= min(out, 0) {
+		t.Fatalf("autocontrast: red ranges from %d to %d, blue from %d to %d",
+			min(out, 0), max(out, 0), min(out, 2), max(out, 2))
+	}
+
+	// Clipping ignores outliers.
+	img = grayRamp(60, 180)
+	img.Pix[0], img.Pix[1], img.Pix[2] = 0, 0, 0
+	if out = runTone(t, "autocontrast clip=1%", img); out.Pix[4] != 0 {
+		t.Fatalf("Clipped autocontrast maps %d onto %d; want 0", img.Pix[4], out.Pix[4])
+	}
+}
+
+func Test_Equalize(t *testing.T) {
+	// Most pixels are dark, so equalization brightens them.
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := 0; i < len(img.Pix); i += 4 {
+		v := uint8(i / 4 % 64)
+		if i/4%8 == 0 {
+			v = 200
+		}
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = v, v/2, v, 255
+	}
+
+	for _, op := range []string{"equalize", "clahe", "clahe tiles=4 limit=3"} {
+		out := runTone(t, op, img)
+
+		var before, after float64
+		for i := 0; i < len(img.Pix); i += 4 {
+			before += float64(img.Pix[i])
+			after += float64(out.Pix[i])
+
+			// The hue is kept: all channels are scaled alike.
+			scale := float64(out.Pix[i]) / math.Max(1, float64(img.Pix[i]))
+			if out.Pix[i] != out.Pix[i+2] || math.Abs(scale*float64(img.Pix[i+1])-float64(out.Pix[i+1])) > 1 {
+				t.Fatalf("%s: pixel %d is %v", op, i/4, out.Pix[i:i+4])
+			}
+		}
+
+		if after <= before {
+			t.Errorf("%s: mean is %.1f; want more than %.1f", op, after/4096, before/4096)
+		}
+	}
+
+	// Equalizing a flat image leaves it as-is.
+	flat := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := range flat.Pix {
+		flat.Pix[i] = 255
+	}
+
+	if out := runTone(t, "equalize", flat); out.Pix[0] != 255 {
+		t.Fatalf("Flat image changes to %v", out.Pix[:4])
+	}
+}
+
+func Test_ToneErrors(t *testing.T) {
+	for _, src := range []string{
+		"levels",
+		"levels in=10",
+		"levels in=10..10",
+		"levels in=0..300",
+		"levels gamma=0",
+		"levels channels=rgba",
+		"levels channels=rr",
+		"levels foo",
+		"curve",
+		"curve 0:0",
+		"curve 0:0 0:10",
+		"curve 100:0 50:10",
+		"curve 0:0 256:255",
+		"curve 0,0 255,255",
+		"autolevels clip=50%",
+		"autocontrast foo",
+		"equalize 2",
+		"clahe tiles=0",
+		"clahe limit=0.5",
+	} {
+		if _, err := Compile(strings.NewReader(src)); err == nil {
+			t.Errorf("%q: expected an error", src)
+		}
+	}
+}
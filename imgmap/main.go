@@ -4,55 +4,120 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"flag"
 	"fmt"
 	maplib "github.com/jteeuwen/imgtools/imgmap/lib"
 	"github.com/jteeuwen/imgtools/lib"
 	"image"
-	"image/draw"
 	"io"
 	"os"
-	"path/filepath"
+	"strings"
 )
 
+type config struct {
+	file    string
+	expr    io.Reader
+	mapfile string
+	mask    string
+	palette string
+	metric  maplib.Metric
+	dither  bool
+	lut     string
+	interp  maplib.Interpolation
+	tone    []string
+	matrix  []string
+}
+
+// list is a flag which may be given more than once.
+type list []string
+
+func (l *list) String() string { return strings.Join(*l, ", ") }
+
+func (l *list) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
 func main() {
-	var linecount int
-	var line []byte
+	cfg := parseArgs()
+
+	var prog *maplib.Program
 	var err error
 
-	file, expr := parseArgs()
-	src, dst := getImages(file)
+	// Relative file names in a map file are relative to its directory.
+	if len(cfg.mapfile) > 0 {
+		prog, err = maplib.CompileFile(cfg.mapfile)
+	} else {
+		prog, err = maplib.Compile(cfg.expr)
+	}
 
-	for err != io.EOF {
-		linecount++
-		line, err = expr.ReadBytes('\n')
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	for _, op := range cfg.tone {
+		if err := prog.AddDirective(op); err != nil {
+			fmt.Fprintf(os.Stderr, "-tone %s: %v\n", op, err)
+			os.Exit(1)
+		}
+	}
 
-		if err != nil && err != io.EOF {
-			fmt.Fprintf(os.Stderr, "Read expression: %v\n", err)
+	for _, m := range cfg.matrix {
+		if err := prog.AddDirective("matrix " + m); err != nil {
+			fmt.Fprintf(os.Stderr, "-matrix %s: %v\n", m, err)
 			os.Exit(1)
 		}
+	}
 
-		ok, err := maplib.Parse(line, src, dst)
+	if len(cfg.lut) > 0 {
+		lut, err := maplib.LoadLUTFile(cfg.lut)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Line %d: %v\n", linecount, err)
+			fmt.Fprintf(os.Stderr, "Load LUT: %v\n", err)
+			os.Exit(1)
 		}
 
-		if ok {
-			src, dst = dst, src
+		prog.AddLUT(lut, cfg.interp)
+	}
+
+	if len(cfg.palette) > 0 {
+		pal, err := maplib.LoadPaletteFile(cfg.palette)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Load palette: %v\n", err)
+			os.Exit(1)
 		}
+
+		prog.AddPalette(pal, cfg.metric, cfg.dither)
+	}
+
+	if prog.UsesMask() && len(cfg.mask) == 0 {
+		fmt.Fprintf(os.Stderr, "The color map refers to a mask; specify one with -mask\n")
+		os.Exit(1)
+	}
+
+	img := load(cfg.file)
+
+	var mask image.Image
+	if len(cfg.mask) > 0 {
+		mask = load(cfg.mask)
+	}
+
+	out, err := prog.RunMask(img, mask)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
 	}
 
-	err = lib.Encode(os.Stdout, "png", src, "")
+	err = lib.Encode(os.Stdout, "png", out, "")
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Write output image: %v\n", err)
 		os.Exit(1)
 	}
 }
 
-// getStreams opens ands returns the input and output streams.
-func getImages(input string) (draw.Image, draw.Image) {
+// load loads an image from the given file, or stdin.
+func load(input string) image.Image {
 	var fd io.ReadCloser
 	var err error
 
@@ -60,37 +125,38 @@ func getImages(input string) (draw.Image, draw.Image) {
 		fd = os.Stdin
 	} else {
 		fd, err = os.Open(input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Open input file: %v\n", err)
+			os.Exit(1)
+		}
 		defer fd.Close()
 	}
 
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Open input file: %v\n", err)
-		os.Exit(1)
-	}
-
 	img, _, err := lib.Decode(fd)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Decode image: %v\n", err)
 		os.Exit(1)
 	}
 
-	dimg, ok := img.(draw.Image)
-	if !ok {
-		fmt.Fprintf(os.Stderr, "Decode image: %T is not draw.Image; missing Set method.\n", img)
-		os.Exit(1)
-	}
-
-	return dimg, image.NewRGBA(img.Bounds())
+	return img
 }
 
 // parseArgs parses command line arguments.
-func parseArgs() (string, *bufio.Reader) {
+func parseArgs() *config {
 	var err error
-	var data io.Reader
+	var cfg config
 
 	version := flag.Bool("version", false, "")
 	mapfile := flag.String("map", "", "")
 	expr := flag.String("expr", "", "")
+	mask := flag.String("mask", "", "")
+	palette := flag.String("palette", "", "")
+	metric := flag.String("metric", "lab", "")
+	dither := flag.Bool("dither", false, "")
+	lut := flag.String("lut", "", "")
+	interp := flag.String("interp", "tetrahedral", "")
+	flag.Var((*list)(&cfg.tone), "tone", "")
+	flag.Var((*list)(&cfg.matrix), "matrix", "")
 
 	flag.Usage = usage
 	flag.Parse()
@@ -100,26 +166,42 @@ func parseArgs() (string, *bufio.Reader) {
 		os.Exit(0)
 	}
 
-	if len(*mapfile) == 0 && len(*expr) == 0 {
+	if len(*mapfile) == 0 && len(*expr) == 0 && len(*palette) == 0 && len(*lut) == 0 &&
+		len(cfg.tone) == 0 && len(cfg.matrix) == 0 {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	data = bytes.NewBufferString(*expr)
+	cfg.expr = bytes.NewBufferString(*expr)
+	cfg.mapfile = *mapfile
 
-	if len(*mapfile) > 0 {
-		data, err = os.Open(filepath.Clean(*mapfile))
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
-			os.Exit(1)
-		}
+	cfg.metric, err = maplib.ParseMetric(*metric)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	cfg.interp, err = maplib.ParseInterpolation(*interp)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	if *dither && len(*palette) == 0 {
+		fmt.Fprintf(os.Stderr, "-dither requires -palette\n")
+		os.Exit(1)
 	}
 
+	cfg.mask = *mask
+	cfg.palette = *palette
+	cfg.dither = *dither
+	cfg.lut = *lut
+
 	if flag.NArg() > 0 {
-		return flag.Args()[0], bufio.NewReader(data)
+		cfg.file = flag.Args()[0]
 	}
 
-	return "", bufio.NewReader(data)
+	return &cfg
 }
 
 func usage() {
@@ -137,6 +219,53 @@ func usage() {
     This is intended for simple, one-off operations you
     do not want to create a separate mapping file for.
 
+ -tone <operation>
+    Applies a tone operation, after any map expressions. This
+    is one of the following, and may be given more than once:
+
+      levels [in=LO..HI] [gamma=G] [out=LO..HI] [channels=rgb]
+      curve [channels=rgb] X:Y X:Y ...
+      autolevels [clip=N%%]
+      autocontrast [clip=N%%]
+      equalize
+      clahe [tiles=N] [limit=N]
+
+ -matrix <matrix>
+    Applies a color matrix, after any map expressions and
+    -tone. This is a preset, optionally with a value, or
+    20 numbers for the 4 rows of 5 values of the matrix.
+    It may be given more than once. The presets are sepia,
+    grayscale, grayscale601, grayscale-average, protanopia,
+    deuteranopia, tritanopia, saturation <N> and hue <deg>.
+
+ -lut <file>
+    Applies a color lookup table, after any map expressions,
+    -tone and -matrix.
+    This is a 1D or 3D .cube file, or a Hald CLUT image.
+
+ -interp <name>
+    The interpolation for 3D tables given with -lut:
+    tetrahedral or trilinear. Defaults to tetrahedral.
+
+ -palette <file>
+    Maps every pixel to the nearest color of a palette, after
+    any map expressions and -lut. This is a GIMP (.gpl) or
+    JASC (.pal) palette, a list of hex colors, or an image to
+    take the colors from.
+
+ -metric <name>
+    The color distance for -palette: de2000, lab, rgb or
+    weighted. Defaults to lab.
+
+ -dither
+    Dithers the result of -palette with Floyd-Steinberg
+    error diffusion.
+
+ -mask <file>
+    Path to a mask image, for rules with a mask region or
+    the #m reference. It must be as large as the input image.
+    Pixels are in the mask if their gray value is non-zero.
+
 `, AppName, AppName)
 
 }
@@ -0,0 +1,77 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package lib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Operation identifies a lossless rotation or mirror operation.
+//
+// The values are ordered such that Operation(n-1) undoes the
+// EXIF orientation n. See Orient.
+type Operation uint8
+
+// Known operations.
+const (
+	None       Operation = iota // Leave the image as-is.
+	FlipH                       // Mirror along the vertical axis.
+	Rotate180                   // Rotate by 180 degrees.
+	FlipV                       // Mirror along the horizontal axis.
+	Transpose                   // Mirror along the top-left to bottom-right diagonal.
+	Rotate90                    // Rotate clockwise by 90 degrees.
+	Transverse                  // Mirror along the top-right to bottom-left diagonal.
+	Rotate270                   // Rotate clockwise by 270 degrees.
+)
+
+var operationNames = [...]string{
+	"none", "fliph", "rotate180", "flipv",
+	"transpose", "rotate90", "transverse", "rotate270",
+}
+
+func (op Operation) String() string {
+	if int(op) < len(operationNames) {
+		return operationNames[op]
+	}
+	return fmt.Sprintf("Operation(%d)", op)
+}
+
+// ParseOperation returns the operation with the given name.
+// Names are matched case-insensitively.
+func ParseOperation(name string) (Operation, error) {
+	for i, v := range operationNames {
+		if strings.EqualFold(name, v) {
+			return Operation(i), nil
+		}
+	}
+	return None, fmt.Errorf("Unknown operation: %s", name)
+}
+
+// swaps returns true if the operation swaps the width and height.
+func (op Operation) swaps() bool {
+	return op >= Transpose
+}
+
+// source returns the source offset of the pixel at destination
+// offset (x, y), for a source image of size w x h.
+func (op Operation) source(x, y, w, h int) (int, int) {
+	switch op {
+	case FlipH:
+		return w - 1 - x, y
+	case Rotate180:
+		return w - 1 - x, h - 1 - y
+	case FlipV:
+		return x, h - 1 - y
+	case Transpose:
+		return y, x
+	case Rotate90:
+		return y, h - 1 - x
+	case Transverse:
+		return w - 1 - y, h - 1 - x
+	case Rotate270:
+		return w - 1 - y, x
+	}
+	return x, y
+}
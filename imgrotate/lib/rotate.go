@@ -0,0 +1,154 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package lib
+
+import (
+	"image"
+	"image/color"
+)
+
+// Apply performs the given operation on img. This is an exact permutation
+// of the pixels; no interpolation is performed. The returned image has the
+// same type as the input for all image types in the standard library,
+// except NYCbCrA. Chroma subsampled YCbCr images are returned with a
+// 4:4:4 subsampling ratio, because their chroma samples can not always
+// be permuted exactly. Other image types yield an *image.RGBA64.
+//
+// The returned image has the same origin as the input image.
+func Apply(img image.Image, op Operation) image.Image {
+	src := img.Bounds()
+	dst := src
+
+	if op.swaps() {
+		dst.Max = image.Pt(src.Min.X+src.Dy(), src.Min.Y+src.Dx())
+	}
+
+	switch m := img.(type) {
+	case *image.Gray:
+		out := image.NewGray(dst)
+		permute(op, out.Pix, out.Stride, dst, m.Pix, m.Stride, m.Rect, 1)
+		return out
+
+	case *image.Gray16:
+		out := image.NewGray16(dst)
+		permute(op, out.Pix, out.Stride, dst, m.Pix, m.Stride, m.Rect, 2)
+		return out
+
+	case *image.Alpha:
+		out := image.NewAlpha(dst)
+		permute(op, out.Pix, out.Stride, dst, m.Pix, m.Stride, m.Rect, 1)
+		return out
+
+	case *image.Alpha16:
+		out := image.NewAlpha16(dst)
+		permute(op, out.Pix, out.Stride, dst, m.Pix, m.Stride, m.Rect, 2)
+		return out
+
+	case *image.RGBA:
+		out := image.NewRGBA(dst)
+		permute(op, out.Pix, out.Stride, dst, m.Pix, m.Stride, m.Rect, 4)
+		return out
+
+	case *image.RGBA64:
+		out := image.NewRGBA64(dst)
+		permute(op, out.Pix, out.Stride, dst, m.Pix, m.Stride, m.Rect, 8)
+		return out
+
+	case *image.NRGBA:
+		out := image.NewNRGBA(dst)
+		permute(op, out.Pix, out.Stride, dst, m.Pix, m.Stride, m.Rect, 4)
+		return out
+
+	case *image.NRGBA64:
+		out := image.NewNRGBA64(dst)
+		permute(op, out.Pix, out.Stride, dst, m.Pix, m.Stride, m.Rect, 8)
+		return out
+
+	case *image.CMYK:
+		out := image.NewCMYK(dst)
+		permute(op, out.Pix, out.Stride, dst, m.Pix, m.Stride, m.Rect, 4)
+		return out
+
+	case *image.Paletted:
+		pal := make(color.Palette, len(m.Palette))
+		copy(pal, m.Palette)
+
+		out := image.NewPaletted(dst, pal)
+		permute(op, out.Pix, out.Stride, dst, m.Pix, m.Stride, m.Rect, 1)
+		return out
+
+	case *image.YCbCr:
+		if m.SubsampleRatio != image.YCbCrSubsampleRatio444 {
+			m = ycbcr444(m)
+		}
+
+		out := image.NewYCbCr(dst, image.YCbCrSubsampleRatio444)
+		permute(op, out.Y, out.YStride, dst, m.Y, m.YStride, m.Rect, 1)
+		permute(op, out.Cb, out.CStride, dst, m.Cb, m.CStride, m.Rect, 1)
+		permute(op, out.Cr, out.CStride, dst, m.Cr, m.CStride, m.Rect, 1)
+		return out
+	}
+
+	out := image.NewRGBA64(dst)
+	w, h := src.Dx(), src.Dy()
+
+	for y := 0; y < dst.Dy(); y++ {
+		for x := 0; x < dst.Dx(); x++ {
+			sx, sy := op.source(x, y, w, h)
+			out.Set(dst.Min.X+x, dst.Min.Y+y, img.At(src.Min.X+sx, src.Min.Y+sy))
+		}
+	}
+
+	return out
+}
+
+// permute copies the pixels of a source buffer into a destination
+// buffer, according to the given operation. Both buffers hold pixels
+// of bpp bytes each.
+func permute(op Operation, dst []uint8, dstStride int, dstRect image.Rectangle,
+	src []uint8, srcStride int, srcRect image.Rectangle, bpp int) {
+
+	w, h := srcRect.Dx(), srcRect.Dy()
+
+	for y := 0; y < dstRect.Dy(); y++ {
+		di := y * dstStride
+
+		for x := 0; x < dstRect.Dx(); x++ {
+			sx, sy := op.source(x, y, w, h)
+			si := sy*srcStride + sx*bpp
+			copy(dst[di:di+bpp], src[si:si+bpp])
+			di += bpp
+		}
+	}
+}
+
+// ycbcr444 returns a copy of m with a 4:4:4 subsampling ratio.
+// This merely duplicates the chroma samples and loses no information.
+func ycbcr444(m *image.YCbCr) *image.YCbCr {
+	out := image.NewYCbCr(m.Rect, image.YCbCrSubsampleRatio444)
+
+	for y := m.Rect.Min.Y; y < m.Rect.Max.Y; y++ {
+		for x := m.Rect.Min.X; x < m.Rect.Max.X; x++ {
+			yi := m.YOffset(x, y)
+			ci := m.COffset(x, y)
+			oi := out.YOffset(x, y)
+
+			out.Y[oi] = m.Y[yi]
+			out.Cb[oi] = m.Cb[ci]
+			out.Cr[oi] = m.Cr[ci]
+		}
+	}
+
+	return out
+}
+
+// Orient transforms an image which carries the given EXIF orientation
+// value (1-8), such that it is displayed upright. Invalid orientation
+// values leave the image as-is.
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 1 || orientation > 8 {
+		return img
+	}
+	return Apply(img, Operation(orientation-1))
+}
@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// testImage returns a 3x2 image with non-zero origin:
+//
+//	1 2 3
+//	4 5 6
+func testImage() *image.Gray {
+	m := image.NewGray(image.Rect(10, 20, 13, 22))
+	copy(m.Pix, []uint8{1, 2, 3, 4, 5, 6})
+	return m
+}
+
+func Test_Apply(t *testing.T) {
+	tests := []struct {
+		op   Operation
+		rect image.Rectangle
+		pix  []uint8
+	}{
+		{None, image.Rect(10, 20, 13, 22), []uint8{1, 2, 3, 4, 5, 6}},
+		{FlipH, image.Rect(10, 20, 13, 22), []uint8{3, 2, 1, 6, 5, 4}},
+		{Rotate180, image.Rect(10, 20, 13, 22), []uint8{6, 5, 4, 3, 2, 1}},
+		{FlipV, image.Rect(10, 20, 13, 22), []uint8{4, 5, 6, 1, 2, 3}},
+		{Transpose, image.Rect(10, 20, 12, 23), []uint8{1, 4, 2, 5, 3, 6}},
+		{Rotate90, image.Rect(10, 20, 12, 23), []uint8{4, 1, 5, 2, 6, 3}},
+		{Transverse, image.Rect(10, 20, 12, 23), []uint8{6, 3, 5, 2, 4, 1}},
+		{Rotate270, image.Rect(10, 20, 12, 23), []uint8{3, 6, 2, 5, 1, 4}},
+	}
+
+	for _, tt := range tests {
+		m, ok := Apply(testImage(), tt.op).(*image.Gray)
+		if !ok {
+			t.Errorf("%v: image type not preserved", tt.op)
+			continue
+		}
+
+		if m.Rect != tt.rect {
+			t.Errorf("%v: bounds %v; want %v", tt.op, m.Rect, tt.rect)
+		}
+
+		if string(m.Pix) != string(tt.pix) {
+			t.Errorf("%v: pixels %v; want %v", tt.op, m.Pix, tt.pix)
+		}
+	}
+}
+
+func Test_ApplyPaletted(t *testing.T) {
+	pal := color.Palette{color.Black, color.White}
+	m := image.NewPaletted(image.Rect(0, 0, 2, 1), pal)
+	m.Pix[1] = 1
+
+	out, ok := Apply(m, Rotate90).(*image.Paletted)
+	if !ok {
+		t.Fatal("image type not preserved")
+	}
+
+	if len(out.Palette) != 2 || out.ColorIndexAt(0, 1) != 1 {
+		t.Fail()
+	}
+}
+
+func Test_ApplyYCbCr(t *testing.T) {
+	m := image.NewYCbCr(image.Rect(0, 0, 5, 3), image.YCbCrSubsampleRatio420)
+	for i := range m.Y {
+		m.Y[i] = uint8(i * 10)
+	}
+	for i := range m.Cb {
+		m.Cb[i] = uint8(i * 20)
+		m.Cr[i] = uint8(255 - i*20)
+	}
+
+	out := Apply(m, Rotate90)
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 5; x++ {
+			if out.At(2-y, x) != m.At(x, y) {
+				t.Fatalf("pixel (%d, %d) differs", x, y)
+			}
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	rotlib "github.com/jteeuwen/imgtools/imgrotate/lib"
+	"github.com/jteeuwen/imgtools/lib"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+func main() {
+	file, auto, orient, ops := parseArgs()
+
+	data := load(file)
+
+	img, _, err := lib.Decode(bytes.NewReader(data))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Decode image: %v\n", err)
+		os.Exit(1)
+	}
+
+	if auto {
+		orient = lib.ExifOrientation(data)
+	}
+
+	img = rotlib.Orient(img, orient)
+
+	for _, op := range ops {
+		img = rotlib.Apply(img, op)
+	}
+
+	save(img)
+}
+
+// load reads the contents of the given image file.
+// The raw data is needed to find EXIF orientation tags.
+func load(input string) []byte {
+	var fd io.ReadCloser
+	var err error
+
+	if len(input) == 0 {
+		fd = os.Stdin
+	} else {
+		fd, err = os.Open(input)
+		defer fd.Close()
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Open input file: %v\n", err)
+		os.Exit(1)
+	}
+
+	data, err := ioutil.ReadAll(fd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Read input file: %v\n", err)
+		os.Exit(1)
+	}
+
+	return data
+}
+
+// save encodes the given image as PNG and saves it to stdout.
+func save(img image.Image) {
+	err := lib.Encode(os.Stdout, "png", img, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Encode image: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// parseArgs parses command line arguments.
+func parseArgs() (string, bool, int, []rotlib.Operation) {
+	var ops []rotlib.Operation
+
+	rotate := flag.Int("rotate", 0, "")
+	flip := flag.String("flip", "", "")
+	transpose := flag.Bool("transpose", false, "")
+	transverse := flag.Bool("transverse", false, "")
+	orient := flag.Int("orient", 1, "")
+	auto := flag.Bool("auto", false, "")
+	version := flag.Bool("version", false, "")
+
+	flag.Usage = usage
+	flag.Parse()
+
+	if *version {
+		fmt.Printf("%s\n", Version())
+		os.Exit(0)
+	}
+
+	if *orient < 1 || *orient > 8 {
+		fmt.Fprintf(os.Stderr, "Invalid orientation %d; expected 1-8.\n", *orient)
+		os.Exit(1)
+	}
+
+	switch (*rotate%360 + 360) % 360 {
+	case 0:
+	case 90:
+		ops = append(ops, rotlib.Rotate90)
+	case 180:
+		ops = append(ops, rotlib.Rotate180)
+	case 270:
+		ops = append(ops, rotlib.Rotate270)
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid rotation %d; expected a multiple of 90.\n", *rotate)
+		os.Exit(1)
+	}
+
+	switch strings.ToLower(*flip) {
+	case "":
+	case "h":
+		ops = append(ops, rotlib.FlipH)
+	case "v":
+		ops = append(ops, rotlib.FlipV)
+	case "hv", "vh":
+		ops = append(ops, rotlib.Rotate180)
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid flip value %q; expected h, v or hv.\n", *flip)
+		os.Exit(1)
+	}
+
+	if *transpose {
+		ops = append(ops, rotlib.Transpose)
+	}
+
+	if *transverse {
+		ops = append(ops, rotlib.Transverse)
+	}
+
+	if flag.NArg() == 0 {
+		return "", *auto, *orient, ops
+	}
+
+	return flag.Args()[0], *auto, *orient, ops
+}
+
+func usage() {
+	fmt.Printf(`Usage: %s [options] <path>
+   or: cat <path> | %s [options]
+
+ -version
+    Displays version information.
+
+ -rotate <degrees>
+    Rotate the image clockwise by 90, 180 or 270 degrees.
+    Negative values rotate counter-clockwise.
+
+ -flip <h|v|hv>
+    Mirror the image horizontally, vertically or both.
+
+ -transpose
+    Mirror the image along its top-left to bottom-right diagonal.
+
+ -transverse
+    Mirror the image along its top-right to bottom-left diagonal.
+
+ -orient <N>
+    The EXIF orientation value (1-8) of the input image. The image
+    is transformed, such that it is displayed upright.
+
+ -auto
+    Read the EXIF orientation value from the input image. This
+    works for JPEG files only. It overrides -orient.
+
+    Operations are applied in the order: orient, rotate, flip,
+    transpose and transverse. These are exact pixel permutations,
+    so no interpolation is performed. The image type is preserved.
+
+`, AppName, AppName)
+}
@@ -0,0 +1,194 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package resize
+
+import (
+	"fmt"
+	"github.com/jteeuwen/imgtools/lib"
+	"image"
+	"image/color"
+	"strings"
+)
+
+// BorderMode defines how a filter samples pixels which lie
+// outside of the image bounds.
+type BorderMode uint8
+
+// Known border modes.
+const (
+	Replicate BorderMode = iota // Repeat the outermost pixels.
+	Reflect                     // Mirror the image at its edges.
+	Wrap                        // Tile the image.
+	Constant                    // Use a fixed color.
+)
+
+// Border describes the edge handling of a filter.
+type Border struct {
+	Mode  BorderMode
+	Color color.Color // Color for the Constant mode; nil means transparent.
+}
+
+// TransparentBorder samples fully transparent pixels outside of the image.
+var TransparentBorder = Border{Constant, color.Transparent}
+
+// ParseBorder parses a border from the given string. Known values are:
+// replicate, reflect, wrap, transparent and constant:<color>. The color
+// is written in the hexadecimal notation accepted by lib.ParseColor.
+func ParseBorder(value string) (Border, error) {
+	var b Border
+
+	mode := strings.ToLower(value)
+	if idx := strings.Index(mode, ":"); idx > -1 {
+		mode = mode[:idx]
+	}
+
+	switch mode {
+	case "replicate":
+		b.Mode = Replicate
+	case "reflect":
+		b.Mode = Reflect
+	case "wrap":
+		b.Mode = Wrap
+	case "transparent":
+		b = TransparentBorder
+	case "constant":
+		idx := strings.Index(value, ":")
+		if idx == -1 {
+			return b, fmt.Errorf("Missing border color; expected constant:<color>")
+		}
+
+		c, err := lib.ParseColor(value[idx+1:])
+		if err != nil {
+			return b, err
+		}
+
+		b.Mode = Constant
+		b.Color = c
+	default:
+		return b, fmt.Errorf("Unknown border mode: %s", value)
+	}
+
+	return b, nil
+}
+
+// locate maps the point (x, y) onto a point inside rect.
+// It returns false if the point should be replaced by the
+// border color instead.
+func (b *Border) locate(x, y int, rect image.Rectangle) (int, int, bool) {
+	if (image.Point{x, y}).In(rect) {
+		return x, y, true
+	}
+
+	switch b.Mode {
+	case Reflect:
+		return reflectBorder1d(x, rect.Min.X, rect.Max.X),
+			reflectBorder1d(y, rect.Min.Y, rect.Max.Y), true
+	case Wrap:
+		return wrapBorder1d(x, rect.Min.X, rect.Max.X),
+			wrapBorder1d(y, rect.Min.Y, rect.Max.Y), true
+	case Constant:
+		return x, y, false
+	}
+
+	xx, yy := replicateBorder(x, y, rect)
+	return xx, yy, true
+}
+
+// fillColor returns the color used outside of the image bounds.
+func (b *Border) fillColor() color.Color {
+	if b.Color == nil {
+		return color.Transparent
+	}
+	return b.Color
+}
+
+func reflectBorder1d(x, min, max int) int {
+	n := max - min
+	if n <= 0 {
+		return min
+	}
+
+	x = (x - min) % (2 * n)
+	if x < 0 {
+		x += 2 * n
+	}
+
+	if x >= n {
+		x = 2*n - 1 - x
+	}
+
+	return min + x
+}
+
+func wrapBorder1d(x, min, max int) int {
+	n := max - min
+	if n <= 0 {
+		return min
+	}
+
+	x = (x - min) % n
+	if x < 0 {
+		x += n
+	}
+
+	return min + x
+}
+
+// borderImage attaches a Border to an image.
+type borderImage struct {
+	image.Image
+	Border
+}
+
+// WithBorder returns an image which samples pixels outside of the
+// bounds of img according to the given border. Filters created
+// for the returned image honour the border as well.
+func WithBorder(img image.Image, border Border) image.Image {
+	if bi, ok := img.(*borderImage); ok {
+		img = bi.Image
+	}
+	return &borderImage{img, border}
+}
+
+// attach returns img with the border attached, unless the border
+// replicates the image edges. The converters do so by default.
+func (b Border) attach(img image.Image) image.Image {
+	if b.Mode == Replicate {
+		return img
+	}
+	return WithBorder(img, b)
+}
+
+func (m *borderImage) At(x, y int) color.Color {
+	xx, yy, ok := m.locate(x, y, m.Image.Bounds())
+	if !ok {
+		return m.fillColor()
+	}
+	return m.Image.At(xx, yy)
+}
+
+// borderConverter applies a border mode to the points
+// retrieved from another converter.
+type borderConverter struct {
+	converter
+	border   Border
+	rect     image.Rectangle
+	constant colorArray
+}
+
+func newBorderConverter(c converter, border Border, rect image.Rectangle) *borderConverter {
+	r, g, b, a := border.fillColor().RGBA()
+	return &borderConverter{
+		c, border, rect,
+		colorArray{float32(r), float32(g), float32(b), float32(a)},
+	}
+}
+
+func (c *borderConverter) at(x, y int) colorArray {
+	xx, yy, ok := c.border.locate(x, y, c.rect)
+	if !ok {
+		return c.constant
+	}
+	return c.converter.at(xx, yy)
+}
@@ -0,0 +1,53 @@
+package resize
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func Test_BorderLocate(t *testing.T) {
+	rect := image.Rect(2, 0, 6, 1)
+
+	tests := []struct {
+		mode BorderMode
+		in   int
+		out  int
+	}{
+		{Replicate, -3, 2},
+		{Replicate, 9, 5},
+		{Reflect, 1, 2},
+		{Reflect, 0, 3},
+		{Reflect, 6, 5},
+		{Reflect, 10, 2},
+		{Wrap, 1, 5},
+		{Wrap, 6, 2},
+		{Wrap, -7, 5},
+	}
+
+	for _, tt := range tests {
+		b := Border{Mode: tt.mode}
+		x, _, ok := b.locate(tt.in, 0, rect)
+		if !ok || x != tt.out {
+			t.Errorf("mode %d: locate(%d) = %d; want %d", tt.mode, tt.in, x, tt.out)
+		}
+	}
+}
+
+func Test_TransparentBorder(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := range src.Pix {
+		src.Pix[i] = 0xff
+	}
+
+	m := ResizeWith(8, 8, src, Bicubic, Options{Border: TransparentBorder})
+	_, _, _, a := m.At(0, 0).RGBA()
+	if a == 0xffff {
+		t.Error("transparent border did not affect the edge")
+	}
+
+	m = Resize(8, 8, src, Bicubic)
+	if m.At(0, 0) != color.RGBA64Model.Convert(color.White) {
+		t.Error("replicated border changed the edge")
+	}
+}
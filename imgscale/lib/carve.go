@@ -0,0 +1,386 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package resize
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// maskEnergy is the energy added to, or subtracted from, a pixel
+// which is fully protected or marked for removal by a mask.
+// It outweighs any gradient energy by a wide margin.
+const maskEnergy = 1e4
+
+// Carve resizes img to the given dimensions by seam carving. Rather than
+// scaling the image, this repeatedly removes or duplicates connected paths
+// of pixels with the least visual importance. The importance of a pixel is
+// the magnitude of the color gradient around it. A width or height of 0
+// leaves that dimension unchanged.
+//
+// The optional mask must have the same size as img. Green pixels in the
+// mask protect the corresponding pixels in img. Red pixels mark them for
+// removal. Pixels marked for removal are not favoured when enlarging.
+//
+// It returns an error if the size of the mask does not match the image.
+func Carve(width, height uint, img, mask image.Image) (image.Image, error) {
+	b := img.Bounds()
+
+	if mask != nil && mask.Bounds().Size() != b.Size() {
+		return nil, fmt.Errorf("Mask size %v does not match image size %v",
+			mask.Bounds().Size(), b.Size())
+	}
+
+	w, h := int(width), int(height)
+	if w == 0 {
+		w = b.Dx()
+	}
+
+	if h == 0 {
+		h = b.Dy()
+	}
+
+	c := newCarver(img, mask)
+	c.resize(w)
+
+	if h != c.h {
+		c.transpose()
+		c.resize(h)
+		c.transpose()
+	}
+
+	return c.image(), nil
+}
+
+// carver holds the working state of a seam carving operation.
+// All seams are vertical. Horizontal seams are handled by
+// transposing the image.
+type carver struct {
+	w, h   int
+	pix    []color.RGBA64
+	bias   []float32 // Energy added by the mask.
+	energy []float32
+	cost   []float32 // Cumulative energy of the cheapest seam ending at a pixel.
+	index  []int32   // Original column of each pixel, if tracked.
+}
+
+func newCarver(img, mask image.Image) *carver {
+	b := img.Bounds()
+	c := &carver{w: b.Dx(), h: b.Dy()}
+	c.pix = make([]color.RGBA64, c.w*c.h)
+	c.bias = make([]float32, c.w*c.h)
+
+	for y := 0; y < c.h; y++ {
+		for x := 0; x < c.w; x++ {
+			v := img.At(b.Min.X+x, b.Min.Y+y)
+			c.pix[y*c.w+x] = color.RGBA64Model.Convert(v).(color.RGBA64)
+		}
+	}
+
+	if mask == nil {
+		return c
+	}
+
+	mb := mask.Bounds()
+	for y := 0; y < c.h; y++ {
+		for x := 0; x < c.w; x++ {
+			r, g, _, _ := mask.At(mb.Min.X+x, mb.Min.Y+y).RGBA()
+			c.bias[y*c.w+x] = (float32(g) - float32(r)) / 0xffff * maskEnergy
+		}
+	}
+
+	return c
+}
+
+// image returns the current state as an image.
+func (c *carver) image() image.Image {
+	dst := image.NewRGBA64(image.Rect(0, 0, c.w, c.h))
+
+	for y := 0; y < c.h; y++ {
+		for x := 0; x < c.w; x++ {
+			dst.SetRGBA64(x, y, c.pix[y*c.w+x])
+		}
+	}
+
+	return dst
+}
+
+// resize changes the width of the image to n by removing
+// or inserting vertical seams.
+func (c *carver) resize(n int) {
+	if n < 1 {
+		n = 1
+	}
+
+	switch {
+	case n < c.w:
+		c.shrink(c.w - n)
+	case n > c.w:
+		c.enlarge(n - c.w)
+	}
+}
+
+// shrink removes k vertical seams.
+func (c *carver) shrink(k int) {
+	c.computeEnergy()
+
+	for i := 0; i < k; i++ {
+		c.removeSeam(c.findSeam())
+	}
+}
+
+// enlarge inserts k vertical seams. It does so in passes which
+// widen the image by at most half, so the same low energy seam
+// is not duplicated over and over again.
+func (c *carver) enlarge(k int) {
+	for k > 0 {
+		step := k
+		if step > c.w/2 {
+			step = c.w / 2
+		}
+
+		if step < 1 {
+			step = 1
+		}
+
+		c.insertSeams(step)
+		k -= step
+	}
+}
+
+// insertSeams finds the k cheapest seams by removing them from a copy
+// of the image. It then duplicates those seams in the original.
+func (c *carver) insertSeams(k int) {
+	tmp := &carver{w: c.w, h: c.h}
+	tmp.pix = append([]color.RGBA64(nil), c.pix...)
+	tmp.bias = make([]float32, len(c.bias))
+	tmp.index = make([]int32, len(c.pix))
+
+	for i, v := range c.bias {
+		if v > 0 {
+			tmp.bias[i] = v
+		}
+		tmp.index[i] = int32(i % c.w)
+	}
+
+	dup := make([]bool, len(c.pix))
+
+	tmp.computeEnergy()
+	for i := 0; i < k; i++ {
+		seam := tmp.findSeam()
+		for y, x := range seam {
+			dup[y*c.w+int(tmp.index[y*tmp.w+x])] = true
+		}
+		tmp.removeSeam(seam)
+	}
+
+	w := c.w + k
+	pix := make([]color.RGBA64, w*c.h)
+	bias := make([]float32, w*c.h)
+
+	for y := 0; y < c.h; y++ {
+		i := y * w
+		for x := 0; x < c.w; x++ {
+			j := y*c.w + x
+			pix[i] = c.pix[j]
+			bias[i] = c.bias[j]
+			i++
+
+			if !dup[j] {
+				continue
+			}
+
+			// The inserted pixel is the average of
+			// the seam pixel and its right neighbour.
+			next := j
+			if x+1 < c.w {
+				next = j + 1
+			}
+
+			pix[i] = average(c.pix[j], c.pix[next])
+			bias[i] = c.bias[j]
+			i++
+		}
+	}
+
+	c.w = w
+	c.pix = pix
+	c.bias = bias
+}
+
+// transpose swaps the rows and columns of the image.
+func (c *carver) transpose() {
+	pix := make([]color.RGBA64, len(c.pix))
+	bias := make([]float32, len(c.bias))
+
+	for y := 0; y < c.h; y++ {
+		for x := 0; x < c.w; x++ {
+			pix[x*c.h+y] = c.pix[y*c.w+x]
+			bias[x*c.h+y] = c.bias[y*c.w+x]
+		}
+	}
+
+	c.w, c.h = c.h, c.w
+	c.pix = pix
+	c.bias = bias
+}
+
+// computeEnergy computes the energy of all pixels.
+// The rows are divided into bands which are processed in parallel.
+func (c *carver) computeEnergy() {
+	c.energy = make([]float32, c.w*c.h)
+
+	parallel(c.h, func(min, max int) {
+		for y := min; y < max; y++ {
+			for x := 0; x < c.w; x++ {
+				c.energy[y*c.w+x] = c.energyAt(x, y)
+			}
+		}
+	})
+}
+
+// energyAt returns the gradient energy of the pixel at (x, y), plus the
+// bias of the mask. The gradient is taken against all four neighbours,
+// so thin lines have energy of their own. Edges are replicated.
+func (c *carver) energyAt(x, y int) float32 {
+	p := c.pix[y*c.w+x]
+	dx := gradient(c.at(x-1, y), p) + gradient(p, c.at(x+1, y))
+	dy := gradient(c.at(x, y-1), p) + gradient(p, c.at(x, y+1))
+	return float32(math.Sqrt(float64(dx+dy))) + c.bias[y*c.w+x]
+}
+
+func (c *carver) at(x, y int) color.RGBA64 {
+	return c.pix[clampInt(y, 0, c.h-1)*c.w+clampInt(x, 0, c.w-1)]
+}
+
+// findSeam returns the column of the cheapest vertical seam in each row.
+func (c *carver) findSeam() []int {
+	c.accumulate()
+
+	y := c.h - 1
+	row := c.cost[y*c.w : (y+1)*c.w]
+	x := 0
+	for i := range row {
+		if row[i] < row[x] {
+			x = i
+		}
+	}
+
+	seam := make([]int, c.h)
+	seam[y] = x
+
+	for y--; y >= 0; y-- {
+		row = c.cost[y*c.w : (y+1)*c.w]
+		best := x
+		if x > 0 && row[x-1] < row[best] {
+			best = x - 1
+		}
+		if x+1 < c.w && row[x+1] < row[best] {
+			best = x + 1
+		}
+		x = best
+		seam[y] = x
+	}
+
+	return seam
+}
+
+// accumulate computes the cumulative seam costs. Each row depends
+// on the one above it, so this is done serially.
+func (c *carver) accumulate() {
+	if len(c.cost) < c.w*c.h {
+		c.cost = make([]float32, c.w*c.h)
+	}
+
+	copy(c.cost, c.energy[:c.w])
+
+	for y := 1; y < c.h; y++ {
+		prev := c.cost[(y-1)*c.w : y*c.w]
+		cur := c.cost[y*c.w : (y+1)*c.w]
+		energy := c.energy[y*c.w : (y+1)*c.w]
+
+		for x := range cur {
+			v := prev[x]
+			if x > 0 && prev[x-1] < v {
+				v = prev[x-1]
+			}
+			if x+1 < c.w && prev[x+1] < v {
+				v = prev[x+1]
+			}
+			cur[x] = energy[x] + v
+		}
+	}
+}
+
+// removeSeam removes the given seam and updates the
+// energy of the pixels which were adjacent to it.
+func (c *carver) removeSeam(seam []int) {
+	w := c.w - 1
+
+	for y, x := range seam {
+		src := y * c.w
+		dst := y * w
+
+		copy(c.pix[dst:], c.pix[src:src+x])
+		copy(c.pix[dst+x:], c.pix[src+x+1:src+c.w])
+		copy(c.bias[dst:], c.bias[src:src+x])
+		copy(c.bias[dst+x:], c.bias[src+x+1:src+c.w])
+		copy(c.energy[dst:], c.energy[src:src+x])
+		copy(c.energy[dst+x:], c.energy[src+x+1:src+c.w])
+
+		if c.index != nil {
+			copy(c.index[dst:], c.index[src:src+x])
+			copy(c.index[dst+x:], c.index[src+x+1:src+c.w])
+		}
+	}
+
+	c.w = w
+	c.pix = c.pix[:w*c.h]
+	c.bias = c.bias[:w*c.h]
+	c.energy = c.energy[:w*c.h]
+
+	if c.index != nil {
+		c.index = c.index[:w*c.h]
+	}
+
+	// Neighbouring seams differ by at most one column, so only
+	// pixels near the seam have a changed neighbourhood.
+	for y, x := range seam {
+		for i := x - 2; i <= x+1; i++ {
+			if i >= 0 && i < c.w {
+				c.energy[y*c.w+i] = c.energyAt(i, y)
+			}
+		}
+	}
+}
+
+// gradient returns the squared difference between two colors.
+func gradient(a, b color.RGBA64) float32 {
+	dr := (float32(a.R) - float32(b.R)) / 0xffff
+	dg := (float32(a.G) - float32(b.G)) / 0xffff
+	db := (float32(a.B) - float32(b.B)) / 0xffff
+	da := (float32(a.A) - float32(b.A)) / 0xffff
+	return dr*dr + dg*dg + db*db + da*da
+}
+
+func average(a, b color.RGBA64) color.RGBA64 {
+	return color.RGBA64{
+		uint16((uint32(a.R) + uint32(b.R)) / 2),
+		uint16((uint32(a.G) + uint32(b.G)) / 2),
+		uint16((uint32(a.B) + uint32(b.B)) / 2),
+		uint16((uint32(a.A) + uint32(b.A)) / 2),
+	}
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
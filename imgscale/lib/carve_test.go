@@ -0,0 +1,90 @@
+package resize
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// stripes returns a flat gray image with a red vertical stripe at column x.
+func stripes(w, h, x int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for py := 0; py < h; py++ {
+		for px := 0; px < w; px++ {
+			img.Set(px, py, color.RGBA{0x80, 0x80, 0x80, 0xff})
+		}
+		img.Set(x, py, color.RGBA{0xff, 0, 0, 0xff})
+	}
+	return img
+}
+
+func countRed(img image.Image) int {
+	var n int
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, _, _ := img.At(x, y).RGBA()
+			if r > 0xf000 && g < 0x1000 {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func Test_CarveShrink(t *testing.T) {
+	img := stripes(40, 30, 10)
+
+	out, err := Carve(25, 20, img, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := out.Bounds().Size(); s != image.Pt(25, 20) {
+		t.Fatalf("Size: got %v; want (25,20)", s)
+	}
+
+	// Every row must still hold exactly one red pixel.
+	if n := countRed(out); n != 20 {
+		t.Fatalf("Stripe pixels: got %d; want 20", n)
+	}
+}
+
+func Test_CarveEnlarge(t *testing.T) {
+	img := stripes(20, 10, 5)
+
+	out, err := Carve(50, 0, img, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := out.Bounds().Size(); s != image.Pt(50, 10) {
+		t.Fatalf("Size: got %v; want (50,10)", s)
+	}
+
+	if n := countRed(out); n != 10 {
+		t.Fatalf("Stripe pixels: got %d; want 10", n)
+	}
+}
+
+func Test_CarveMask(t *testing.T) {
+	img := stripes(20, 10, 5)
+	mask := image.NewRGBA(img.Bounds())
+	for y := 0; y < 10; y++ {
+		mask.Set(5, y, color.RGBA{0xff, 0, 0, 0xff})
+	}
+
+	out, err := Carve(19, 0, img, mask)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := countRed(out); n != 0 {
+		t.Fatalf("Stripe pixels: got %d; want 0", n)
+	}
+
+	_, err = Carve(10, 0, img, image.NewRGBA(image.Rect(0, 0, 5, 5)))
+	if err == nil {
+		t.Fatal("Mismatched mask size was accepted")
+	}
+}
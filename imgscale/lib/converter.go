@@ -46,6 +46,24 @@ type converter interface {
 	at(x, y int) colorArray
 }
 
+// newConverter returns the fastest converter for the given image type.
+func newConverter(img image.Image) converter {
+	switch img.(type) {
+	case *image.RGBA:
+		return &rgbaConverter{img.(*image.RGBA)}
+	case *image.RGBA64:
+		return &rgba64Converter{img.(*image.RGBA64)}
+	case *image.Gray:
+		return &grayConverter{img.(*image.Gray)}
+	case *image.Gray16:
+		return &gray16Converter{img.(*image.Gray16)}
+	case *image.YCbCr:
+		return &ycbcrConverter{img.(*image.YCbCr)}
+	}
+
+	return &genericConverter{img}
+}
+
 type genericConverter struct {
 	src image.Image
 }
@@ -0,0 +1,335 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package resize
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Kernel is a two-dimensional convolution kernel. The values are stored
+// row by row. The kernel is centered on the value at (Width/2, Height/2).
+// It is applied as-is, rather than mirrored, so asymmetric kernels like
+// edge detectors respond the way they read.
+type Kernel struct {
+	Width, Height int
+	Values        []float32
+}
+
+// Convolve applies the kernel k to img. Pixels outside of the image are
+// sampled according to the given border. Colors are convolved with
+// premultiplied alpha. The result has the same bounds as img.
+func Convolve(img image.Image, k Kernel, border Border) image.Image {
+	src := newFloatImage(img)
+	src.setFill(&border)
+	dst := src.like()
+	cx, cy := k.Width/2, k.Height/2
+
+	parallel(src.rect.Dy(), func(min, max int) {
+		var acc [4]float32
+		for y := src.rect.Min.Y + min; y < src.rect.Min.Y+max; y++ {
+			for x := src.rect.Min.X; x < src.rect.Max.X; x++ {
+				acc = [4]float32{}
+
+				for ky := 0; ky < k.Height; ky++ {
+					for kx := 0; kx < k.Width; kx++ {
+						v := k.Values[ky*k.Width+kx]
+						p := src.at(x+kx-cx, y+ky-cy, &border)
+						acc[0] += v * p[0]
+						acc[1] += v * p[1]
+						acc[2] += v * p[2]
+						acc[3] += v * p[3]
+					}
+				}
+
+				dst.set(x, y, acc)
+			}
+		}
+	})
+
+	return dst.image()
+}
+
+// ConvolveSeparable applies a separable kernel to img. This is the
+// horizontal kernel h followed by the vertical kernel v. Both are
+// centered on their middle value. For large kernels, this is a lot
+// faster than Convolve with the equivalent two-dimensional kernel.
+func ConvolveSeparable(img image.Image, h, v []float32, border Border) image.Image {
+	src := newFloatImage(img)
+	return convolveSeparable(src, h, v, border).image()
+}
+
+func convolveSeparable(src *floatImage, h, v []float32, border Border) *floatImage {
+	src.setFill(&border)
+	tmp := src.like()
+	dst := src.like()
+	r := src.rect
+
+	parallel(r.Dy(), func(min, max int) {
+		var acc [4]float32
+		c := len(h) / 2
+		for y := r.Min.Y + min; y < r.Min.Y+max; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				acc = [4]float32{}
+				for i, w := range h {
+					p := src.at(x+i-c, y, &border)
+					acc[0] += w * p[0]
+					acc[1] += w * p[1]
+					acc[2] += w * p[2]
+					acc[3] += w * p[3]
+				}
+				i := tmp.offset(x, y)
+				copy(tmp.pix[i:i+4], acc[:])
+			}
+		}
+	})
+
+	parallel(r.Dy(), func(min, max int) {
+		var acc [4]float32
+		c := len(v) / 2
+		for y := r.Min.Y + min; y < r.Min.Y+max; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				acc = [4]float32{}
+				for i, w := range v {
+					p := tmp.at(x, y+i-c, &border)
+					acc[0] += w * p[0]
+					acc[1] += w * p[1]
+					acc[2] += w * p[2]
+					acc[3] += w * p[3]
+				}
+				dst.set(x, y, acc)
+			}
+		}
+	})
+
+	return dst
+}
+
+// GaussianKernel returns a normalized, one-dimensional Gaussian kernel
+// with the given standard deviation. It extends to three times sigma
+// on either side of its center.
+func GaussianKernel(sigma float64) []float32 {
+	if sigma <= 0 {
+		return []float32{1}
+	}
+
+	radius := int(math.Ceil(3 * sigma))
+	k := make([]float32, 2*radius+1)
+
+	var sum float32
+	for i := range k {
+		x := float64(i - radius)
+		k[i] = float32(math.Exp(-x * x / (2 * sigma * sigma)))
+		sum += k[i]
+	}
+
+	for i := range k {
+		k[i] /= sum
+	}
+
+	return k
+}
+
+// GaussianBlur blurs img with a Gaussian kernel of the given
+// standard deviation, in pixels.
+func GaussianBlur(img image.Image, sigma float64, border Border) image.Image {
+	k := GaussianKernel(sigma)
+	return ConvolveSeparable(img, k, k, border)
+}
+
+// UnsharpMask defines the parameters of a sharpening operation.
+// The zero value performs no sharpening.
+type UnsharpMask struct {
+	Radius    float64 // Standard deviation of the blur, in pixels.
+	Amount    float64 // Strength of the effect. 1 adds the full difference.
+	Threshold uint8   // Smallest difference (0-255) which is sharpened.
+}
+
+// ParseUnsharp parses an unsharp mask from a string of the form
+// radius,amount[,threshold]. For example: 0.8,0.6,2
+func ParseUnsharp(value string) (UnsharpMask, error) {
+	var u UnsharpMask
+
+	list := strings.Split(value, ",")
+	if len(list) < 2 || len(list) > 3 {
+		return u, fmt.Errorf("Invalid unsharp mask %q; expected radius,amount[,threshold]", value)
+	}
+
+	var err error
+	u.Radius, err = strconv.ParseFloat(strings.TrimSpace(list[0]), 64)
+	if err != nil || u.Radius <= 0 {
+		return u, fmt.Errorf("Invalid unsharp mask radius: %s", list[0])
+	}
+
+	u.Amount, err = strconv.ParseFloat(strings.TrimSpace(list[1]), 64)
+	if err != nil || u.Amount < 0 {
+		return u, fmt.Errorf("Invalid unsharp mask amount: %s", list[1])
+	}
+
+	if len(list) == 3 {
+		n, err := strconv.ParseUint(strings.TrimSpace(list[2]), 10, 8)
+		if err != nil {
+			return u, fmt.Errorf("Invalid unsharp mask threshold: %s", list[2])
+		}
+		u.Threshold = uint8(n)
+	}
+
+	return u, nil
+}
+
+// Sharpen sharpens img with an unsharp mask. It subtracts a blurred
+// version of the image from the original, and adds the difference,
+// scaled by the amount, back onto the original. Channels which differ
+// from the blurred version by less than the threshold are left as-is,
+// which keeps noise in flat areas from being amplified.
+func Sharpen(img image.Image, u UnsharpMask) image.Image {
+	if u.Amount <= 0 || u.Radius <= 0 {
+		return img
+	}
+
+	src := newFloatImage(img)
+	k := GaussianKernel(u.Radius)
+	blur := convolveSeparable(src, k, k, Border{})
+	dst := src.like()
+
+	amount := float32(u.Amount)
+	threshold := float32(u.Threshold) * 0x101
+
+	parallel(src.rect.Dy(), func(min, max int) {
+		var out [4]float32
+		for y := src.rect.Min.Y + min; y < src.rect.Min.Y+max; y++ {
+			for x := src.rect.Min.X; x < src.rect.Max.X; x++ {
+				i := src.offset(x, y)
+				for c := 0; c < 4; c++ {
+					v := src.pix[i+c]
+					d := v - blur.pix[i+c]
+					if d >= threshold || -d >= threshold {
+						v += amount * d
+					}
+					out[c] = v
+				}
+				dst.set(x, y, out)
+			}
+		}
+	})
+
+	return dst.image()
+}
+
+// floatImage holds premultiplied colors with float32 channels
+// in the range [0, 0xffff]. It is used as intermediate storage
+// by the convolution operations.
+type floatImage struct {
+	rect image.Rectangle
+	pix  []float32  // R, G, B and A for each pixel.
+	fill [4]float32 // Channels of the constant border color.
+}
+
+func newFloatImage(img image.Image) *floatImage {
+	f := &floatImage{rect: img.Bounds()}
+	f.pix = make([]float32, 4*f.rect.Dx()*f.rect.Dy())
+
+	parallel(f.rect.Dy(), func(min, max int) {
+		for y := f.rect.Min.Y + min; y < f.rect.Min.Y+max; y++ {
+			for x := f.rect.Min.X; x < f.rect.Max.X; x++ {
+				r, g, b, a := img.At(x, y).RGBA()
+				i := f.offset(x, y)
+				f.pix[i+0] = float32(r)
+				f.pix[i+1] = float32(g)
+				f.pix[i+2] = float32(b)
+				f.pix[i+3] = float32(a)
+			}
+		}
+	})
+
+	return f
+}
+
+// like returns an empty image with the same bounds and fill color.
+func (f *floatImage) like() *floatImage {
+	return &floatImage{
+		rect: f.rect,
+		pix:  make([]float32, len(f.pix)),
+		fill: f.fill,
+	}
+}
+
+// setFill sets the color returned for points outside of
+// the image, to the fill color of the given border.
+func (f *floatImage) setFill(b *Border) {
+	r, g, bl, a := b.fillColor().RGBA()
+	f.fill = [4]float32{float32(r), float32(g), float32(bl), float32(a)}
+}
+
+func (f *floatImage) offset(x, y int) int {
+	return 4 * ((y-f.rect.Min.Y)*f.rect.Dx() + (x - f.rect.Min.X))
+}
+
+// at returns the channels of the pixel at (x, y). Points outside
+// of the image are mapped according to the given border. A constant
+// border yields the fill color set with setFill.
+func (f *floatImage) at(x, y int, b *Border) []float32 {
+	x, y, ok := b.locate(x, y, f.rect)
+	if !ok {
+		return f.fill[:]
+	}
+
+	i := f.offset(x, y)
+	return f.pix[i : i+4]
+}
+
+// set stores the given channels at (x, y). They are clamped
+// to a valid premultiplied color.
+func (f *floatImage) set(x, y int, c [4]float32) {
+	a := clampChannel(c[3], 0xffff)
+	i := f.offset(x, y)
+	f.pix[i+0] = clampChannel(c[0], a)
+	f.pix[i+1] = clampChannel(c[1], a)
+	f.pix[i+2] = clampChannel(c[2], a)
+	f.pix[i+3] = a
+}
+
+// image returns the contents as an RGBA64 image.
+func (f *floatImage) image() *image.RGBA64 {
+	dst := image.NewRGBA64(f.rect)
+
+	for i, v := range f.pix {
+		n := uint16(v + 0.5)
+		dst.Pix[2*i+0] = uint8(n >> 8)
+		dst.Pix[2*i+1] = uint8(n)
+	}
+
+	return dst
+}
+
+func clampChannel(v, max float32) float32 {
+	if v < 0 {
+		return 0
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// parallel divides n rows into bands, and calls fn for each
+// band in a separate goroutine. It returns when all are done.
+func parallel(n int, fn func(min, max int)) {
+	jobs := numJobs(n)
+	c := make(chan int, jobs)
+
+	for i := 0; i < jobs; i++ {
+		go func(min, max int) {
+			fn(min, max)
+			c <- 1
+		}(i*n/jobs, (i+1)*n/jobs)
+	}
+
+	for i := 0; i < jobs; i++ {
+		<-c
+	}
+}
@@ -0,0 +1,84 @@
+package resize
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func Test_ConvolveIdentity(t *testing.T) {
+	img := image.NewRGBA(image.Rect(2, 3, 10, 9))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xff
+	}
+
+	k := Kernel{3, 3, []float32{0, 0, 0, 0, 1, 0, 0, 0, 0}}
+	out := Convolve(img, k, Border{})
+
+	if out.Bounds() != img.Bounds() {
+		t.Fatalf("Bounds: got %v; want %v", out.Bounds(), img.Bounds())
+	}
+
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r0, g0, b0, a0 := img.At(x, y).RGBA()
+			r1, g1, b1, a1 := out.At(x, y).RGBA()
+			if r0 != r1 || g0 != g1 || b0 != b1 || a0 != a1 {
+				t.Fatalf("Pixel (%d,%d) changed", x, y)
+			}
+		}
+	}
+}
+
+func Test_Sharpen(t *testing.T) {
+	// A vertical edge from dark to light gray.
+	img := image.NewGray(image.Rect(0, 0, 20, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 20; x++ {
+			if x < 10 {
+				img.SetGray(x, y, color.Gray{0x40})
+			} else {
+				img.SetGray(x, y, color.Gray{0xc0})
+			}
+		}
+	}
+
+	out := Sharpen(img, UnsharpMask{Radius: 1, Amount: 1})
+	dark, _, _, _ := out.At(9, 1).RGBA()
+	light, _, _, _ := out.At(10, 1).RGBA()
+	flat, _, _, _ := out.At(0, 1).RGBA()
+
+	if dark >= 0x4040 || light <= 0xc0c0 {
+		t.Errorf("Edge not sharpened: %04x, %04x", dark, light)
+	}
+
+	if flat != 0x4040 {
+		t.Errorf("Flat area changed: %04x", flat)
+	}
+
+	out = Sharpen(img, UnsharpMask{Radius: 1, Amount: 1, Threshold: 0xff})
+	if v, _, _, _ := out.At(9, 1).RGBA(); v != 0x4040 {
+		t.Errorf("Threshold ignored: %04x", v)
+	}
+}
+
+func Test_ParseUnsharp(t *testing.T) {
+	u, err := ParseUnsharp("0.8, 0.6, 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u != (UnsharpMask{0.8, 0.6, 2}) {
+		t.Errorf("Got %+v", u)
+	}
+
+	for _, v := range []string{"", "1", "0,1", "1,-1", "1,1,300"} {
+		if _, err := ParseUnsharp(v); err == nil {
+			t.Errorf("%q: expected an error", v)
+		}
+	}
+}
@@ -73,7 +73,11 @@ func (f *filterModel) convolution1d(x float32, p []colorArray, factor float32) c
 }
 
 func (f *filterModel) Interpolate(x, y float32) color.RGBA64 {
-	xf, yf := int(x)-len(f.tempRow)/2+1, int(y)-len(f.tempCol)/2+1
+	// Round down rather than towards zero. Upscaling and warping map edge
+	// pixels onto negative source coordinates, like -0.25. Truncating
+	// those shifts the window of taps by one, away from the point.
+	xf := int(math.Floor(float64(x))) - len(f.tempRow)/2 + 1
+	yf := int(math.Floor(float64(y))) - len(f.tempCol)/2 + 1
 	x -= float32(xf)
 	y -= float32(yf)
 
@@ -100,55 +104,61 @@ func createFilter(img image.Image, factor [2]float32, size int, kernel func(floa
 	sizeX := size * (int(math.Ceil(float64(factor[0]))))
 	sizeY := size * (int(math.Ceil(float64(factor[1]))))
 
-	switch img.(type) {
-	default:
-		f = &filterModel{
-			kernel, factor,
-			&genericConverter{img},
-			make([]colorArray, sizeX), make([]colorArray, sizeY),
-		}
-	case *image.RGBA:
-		f = &filterModel{
-			kernel, factor,
-			&rgbaConverter{img.(*image.RGBA)},
-			make([]colorArray, sizeX), make([]colorArray, sizeY),
-		}
-	case *image.RGBA64:
-		f = &filterModel{
-			kernel, factor,
-			&rgba64Converter{img.(*image.RGBA64)},
-			make([]colorArray, sizeX), make([]colorArray, sizeY),
-		}
-	case *image.Gray:
-		f = &filterModel{
-			kernel, factor,
-			&grayConverter{img.(*image.Gray)},
-			make([]colorArray, sizeX), make([]colorArray, sizeY),
-		}
-	case *image.Gray16:
-		f = &filterModel{
-			kernel, factor,
-			&gray16Converter{img.(*image.Gray16)},
-			make([]colorArray, sizeX), make([]colorArray, sizeY),
-		}
-	case *image.YCbCr:
-		f = &filterModel{
-			kernel, factor,
-			&ycbcrConverter{img.(*image.YCbCr)},
-			make([]colorArray, sizeX), make([]colorArray, sizeY),
-		}
+	var border *Border
+	if bi, ok := img.(*borderImage); ok {
+		img = bi.Image
+		border = &bi.Border
 	}
 
-	return
+	conv := newConverter(img)
+
+	// The converters replicate the image border by default.
+	// Any other border mode needs an extra lookup per pixel.
+	if border != nil && border.Mode != Replicate {
+		conv = newBorderConverter(conv, *border, img.Bounds())
+	}
+
+	return &filterModel{
+		kernel, factor, conv,
+		make([]colorArray, sizeX), make([]colorArray, sizeY),
+	}
+}
+
+// NewFilter returns a Filter which resamples img by convolution with the
+// given kernel. The kernel is assumed to be symmetrical around 0 and to
+// be zero outside of the range [-radius, radius].
+func NewFilter(img image.Image, factor [2]float32, radius float32, kernel func(float32) float32) Filter {
+	return createFilter(img, factor, kernelSize(radius), kernel)
+}
+
+// NewInterpolation returns an InterpolationFunction for the given kernel.
+// This allows custom kernels to be used with Resize. The kernel is assumed
+// to be symmetrical around 0 and to be zero outside of the range
+// [-radius, radius].
+func NewInterpolation(kernel func(float32) float32, radius float32) InterpolationFunction {
+	size := kernelSize(radius)
+	return func(img image.Image, factor [2]float32) Filter {
+		return createFilter(img, factor, size, kernel)
+	}
 }
 
-// Return a filter kernel that performs nearly identically to the provided
-// kernel, but generates and uses a precomputed table rather than executing
-// the kernel for each evaluation. The table is generated with tableSize
-// values that cover the kernal domain from -maxX to +maxX. The input kernel
-// is assumed to be symmetrical around 0, so the table only includes values
-// from 0 to maxX.
-func tableKernel(kernel func(float32) float32, tableSize int,
+// kernelSize returns the number of filter taps needed to cover
+// a kernel with the given radius.
+func kernelSize(radius float32) int {
+	size := 2 * int(math.Ceil(float64(radius)))
+	if size < 2 {
+		size = 2
+	}
+	return size
+}
+
+// TableKernel returns a filter kernel that performs nearly identically to
+// the provided kernel, but generates and uses a precomputed table rather than
+// executing the kernel for each evaluation. The table is generated with
+// tableSize values that cover the kernal domain from -maxX to +maxX. The
+// input kernel is assumed to be symmetrical around 0, so the table only
+// includes values from 0 to maxX.
+func TableKernel(kernel func(float32) float32, tableSize int,
 	maxX float32) func(float32) float32 {
 
 	// precompute an array of filter coefficients
@@ -253,7 +263,7 @@ func Lanczos2(img image.Image, factor [2]float32) Filter {
 // to speed up computation
 func Lanczos2Lut(img image.Image, factor [2]float32) Filter {
 	return createFilter(img, factor, 4,
-		tableKernel(lanczosKernel(2), lanczosTableSize, 2.0))
+		TableKernel(lanczosKernel(2), lanczosTableSize, 2.0))
 }
 
 // Lanczos interpolation (a=3)
@@ -265,5 +275,91 @@ func Lanczos3(img image.Image, factor [2]float32) Filter {
 // to speed up computation
 func Lanczos3Lut(img image.Image, factor [2]float32) Filter {
 	return createFilter(img, factor, 6,
-		tableKernel(lanczosKernel(3), lanczosTableSize, 3.0))
+		TableKernel(lanczosKernel(3), lanczosTableSize, 3.0))
+}
+
+// Lanczos interpolation with an arbitrary number of lobes.
+func Lanczos(a uint) InterpolationFunction {
+	return NewInterpolation(lanczosKernel(a), float32(a))
+}
+
+// Box filter. When downscaling, this computes the area-average
+// of all source pixels covered by a destination pixel.
+func Box(img image.Image, factor [2]float32) Filter {
+	return createFilter(img, factor, 2, func(x float32) (y float32) {
+		absX := float32(math.Abs(float64(x)))
+		if absX < 0.5 {
+			y = 1
+		} else if absX == 0.5 {
+			y = 0.5
+		} else {
+			y = 0
+		}
+
+		return
+	})
+}
+
+// Hermite interpolation (cubic spline with B=0, C=0)
+func Hermite(img image.Image, factor [2]float32) Filter {
+	return createFilter(img, factor, 2, splineKernel(0, 0))
+}
+
+// Catmull-Rom interpolation (cubic spline with B=0, C=0.5).
+// This yields the same kernel as Bicubic.
+func CatmullRom(img image.Image, factor [2]float32) Filter {
+	return createFilter(img, factor, 4, splineKernel(0, 0.5))
+}
+
+// Gaussian interpolation (sigma=0.5)
+func Gaussian(img image.Image, factor [2]float32) Filter {
+	return createFilter(img, factor, 4, gaussianKernel(0.5, 2))
+}
+
+func gaussianKernel(sigma, radius float32) func(float32) float32 {
+	s := 2 * float64(sigma) * float64(sigma)
+	return func(x float32) (y float32) {
+		if x > -radius && x < radius {
+			y = float32(math.Exp(-float64(x) * float64(x) / s))
+		} else {
+			y = 0
+		}
+
+		return
+	}
+}
+
+// windowedSincKernel returns a sinc kernel with a lobes, tapered by the
+// given window function. The window is evaluated in the range [-1, 1].
+func windowedSincKernel(a uint, window func(float64) float64) func(float32) float32 {
+	return func(x float32) (y float32) {
+		if x > -float32(a) && x < float32(a) {
+			y = float32(Sinc(float64(x)) * window(float64(x)/float64(a)))
+		} else {
+			y = 0
+		}
+
+		return
+	}
+}
+
+// Hann-windowed sinc interpolation with a lobes.
+func Hann(a uint) InterpolationFunction {
+	return NewInterpolation(windowedSincKernel(a, func(t float64) float64 {
+		return 0.5 + 0.5*math.Cos(math.Pi*t)
+	}), float32(a))
+}
+
+// Hamming-windowed sinc interpolation with a lobes.
+func Hamming(a uint) InterpolationFunction {
+	return NewInterpolation(windowedSincKernel(a, func(t float64) float64 {
+		return 0.54 + 0.46*math.Cos(math.Pi*t)
+	}), float32(a))
+}
+
+// Blackman-windowed sinc interpolation with a lobes.
+func Blackman(a uint) InterpolationFunction {
+	return NewInterpolation(windowedSincKernel(a, func(t float64) float64 {
+		return 0.42 + 0.5*math.Cos(math.Pi*t) + 0.08*math.Cos(2*math.Pi*t)
+	}), float32(a))
 }
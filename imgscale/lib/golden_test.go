@@ -0,0 +1,246 @@
+package resize
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Run "go test -run Golden -update" to rewrite the reference images,
+// after a deliberate change to the output of the filters.
+var update = flag.Bool("update", false, "Rewrite the reference images in testdata/golden.")
+
+// Tolerances for matching the reference images. The references are
+// stored with 8 bits per sample, which alone limits the PSNR to ~59 dB.
+const (
+	goldenPSNR = 45
+	goldenSSIM = 0.995
+)
+
+// pattern describes a synthetic test image.
+type pattern struct {
+	name string
+	at   func(x, y, size int) float64 // Intensity in [0, 1].
+}
+
+var patterns = []pattern{
+	{"zoneplate", func(x, y, size int) float64 {
+		// Circular chirp, whose frequency rises towards the edges.
+		// Aliasing shows up as spurious rings.
+		dx, dy := float64(x)-float64(size-1)/2, float64(y)-float64(size-1)/2
+		return 0.5 + 0.5*math.Cos(math.Pi*(dx*dx+dy*dy)/float64(size))
+	}},
+	{"checkerboard", func(x, y, size int) float64 {
+		return float64((x/3 + y/3) % 2)
+	}},
+	{"gradient", func(x, y, size int) float64 {
+		return (float64(x) + 0.5*float64(y)) / (1.5 * float64(size-1))
+	}},
+	{"impulse", func(x, y, size int) float64 {
+		if x == size/2 && y == size/2 {
+			return 1
+		}
+		return 0
+	}},
+}
+
+// patternImage renders a pattern at the given size.
+func patternImage(p pattern, size int) *image.Gray16 {
+	img := image.NewGray16(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.SetGray16(x, y, color.Gray16{uint16(0.5 + 0xffff*p.at(x, y, size))})
+		}
+	}
+	return img
+}
+
+// luma returns the gray levels of img in [0, 1], in row-major order.
+func luma(img image.Image) []float64 {
+	b := img.Bounds()
+	v := make([]float64, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			g := color.Gray16Model.Convert(img.At(x, y)).(color.Gray16)
+			v = append(v, float64(g.Y)/0xffff)
+		}
+	}
+	return v
+}
+
+// psnr returns the peak signal-to-noise ratio of a against b, in dB.
+func psnr(a, b []float64) float64 {
+	var mse float64
+	for i := range a {
+		d := a[i] - b[i]
+		mse += d * d
+	}
+
+	mse /= float64(len(a))
+	if mse == 0 {
+		return math.Inf(1)
+	}
+
+	return -10 * math.Log10(mse)
+}
+
+// ssim returns the mean structural similarity of two images of the
+// given width, over 8x8 windows which overlap by half. The images must
+// be at least 8x8 pixels.
+func ssim(a, b []float64, width int) float64 {
+	const (
+		c1 = 0.01 * 0.01
+		c2 = 0.03 * 0.03
+		n  = 8
+	)
+
+	height := len(a) / width
+
+	var sum float64
+	var count int
+
+	for y0 := 0; y0+n <= height; y0 += n / 2 {
+		for x0 := 0; x0+n <= width; x0 += n / 2 {
+			var ma, mb, va, vb, cov float64
+			for y := y0; y < y0+n; y++ {
+				for x := x0; x < x0+n; x++ {
+					ma += a[y*width+x]
+					mb += b[y*width+x]
+				}
+			}
+
+			ma /= n * n
+			mb /= n * n
+
+			for y := y0; y < y0+n; y++ {
+				for x := x0; x < x0+n; x++ {
+					da, db := a[y*width+x]-ma, b[y*width+x]-mb
+					va += da * da
+					vb += db * db
+					cov += da * db
+				}
+			}
+
+			va /= n*n - 1
+			vb /= n*n - 1
+			cov /= n*n - 1
+
+			sum += (2*ma*mb + c1) * (2*cov + c2) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+			count++
+		}
+	}
+
+	return sum / float64(count)
+}
+
+// compareGolden compares img against the named reference image,
+// or rewrites the reference if the -update flag is set.
+func compareGolden(t *testing.T, name string, img image.Image) {
+	file := filepath.Join("testdata", "golden", name+".png")
+
+	if *update {
+		ref := image.NewGray(img.Bounds())
+		for y := ref.Rect.Min.Y; y < ref.Rect.Max.Y; y++ {
+			for x := ref.Rect.Min.X; x < ref.Rect.Max.X; x++ {
+				ref.Set(x, y, img.At(x, y))
+			}
+		}
+
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		fd, err := os.Create(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer fd.Close()
+
+		if err = png.Encode(fd, ref); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	fd, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("%s: %v; run the test with -update to create it", name, err)
+	}
+
+	defer fd.Close()
+
+	ref, err := png.Decode(fd)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+
+	if ref.Bounds() != img.Bounds() {
+		t.Fatalf("%s: size %v; want %v", name, img.Bounds(), ref.Bounds())
+	}
+
+	a, b := luma(img), luma(ref)
+	p := psnr(a, b)
+	s := ssim(a, b, img.Bounds().Dx())
+
+	if p < goldenPSNR || s < goldenSSIM {
+		t.Errorf("%s: PSNR %.1f dB, SSIM %.4f; want at least %d dB and %.3f", name, p, s, goldenPSNR, goldenSSIM)
+	}
+}
+
+// Test_Golden resizes every pattern with every registered filter,
+// both down and up, and compares the results against the references.
+func Test_Golden(t *testing.T) {
+	const size = 40
+
+	ops := []struct {
+		name string
+		size uint
+	}{
+		{"down", 17},
+		{"up", 72},
+	}
+
+	for _, p := range patterns {
+		src := patternImage(p, size)
+
+		for _, in := range Interpolations {
+			for _, op := range ops {
+				name := fmt.Sprintf("%s-%s-%s", p.name, op.name, strings.ToLower(in.Name))
+				compareGolden(t, name, Resize(op.size, op.size, src, in.Interp))
+			}
+		}
+	}
+}
+
+func Test_Metrics(t *testing.T) {
+	a := luma(patternImage(patterns[0], 32))
+	if p := psnr(a, a); !math.IsInf(p, 1) {
+		t.Fatalf("PSNR of identical images is %f; want +Inf", p)
+	}
+
+	if s := ssim(a, a, 32); math.Abs(s-1) > 1e-9 {
+		t.Fatalf("SSIM of identical images is %f; want 1", s)
+	}
+
+	// A uniform offset hardly affects structure, but does affect PSNR.
+	b := make([]float64, len(a))
+	for i := range a {
+		b[i] = a[i]*0.9 + 0.05
+	}
+
+	if p := psnr(a, b); p > 30 {
+		t.Fatalf("PSNR of scaled image is %.1f dB; want below 30", p)
+	}
+
+	if s := ssim(a, b, 32); s < 0.9 || s >= 1 {
+		t.Fatalf("SSIM of scaled image is %f; want in [0.9, 1)", s)
+	}
+}
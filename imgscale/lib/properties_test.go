@@ -0,0 +1,121 @@
+package resize
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// gray16 returns the gray levels of img, in row-major order.
+func gray16(img image.Image) (v []int, width int) {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			v = append(v, int(color.Gray16Model.Convert(img.At(x, y)).(color.Gray16).Y))
+		}
+	}
+	return v, b.Dx()
+}
+
+// Test_DCPreservation checks that every filter maps a flat
+// image onto the same flat color, at any scale.
+func Test_DCPreservation(t *testing.T) {
+	const level = 0x7777
+
+	src := image.NewGray16(image.Rect(0, 0, 37, 29))
+	for i := range src.Pix {
+		src.Pix[i] = 0x77
+	}
+
+	for _, in := range Interpolations {
+		for _, w := range []uint{5, 13, 80} {
+			v, _ := gray16(Resize(w, 0, src, in.Interp))
+			for i, g := range v {
+				if g < level-2 || g > level+2 {
+					t.Fatalf("%s to width %d: pixel %d is %#x; want %#x", in.Name, w, i, g, level)
+				}
+			}
+		}
+	}
+}
+
+// Test_Symmetry checks that resizing preserves the mirror and diagonal
+// symmetry of the zone plate. This fails if the sampling grid is not
+// centered on the image. Small differences remain from float32 rounding.
+// They are largest for the Gaussian filter, whose kernel is cut off
+// abruptly at its radius: rounding decides whether a tap at the very
+// edge contributes.
+func Test_Symmetry(t *testing.T) {
+	src := patternImage(patterns[0], 40)
+
+	for _, in := range Interpolations {
+		for _, size := range []uint{17, 20, 72} {
+			v, w := gray16(Resize(size, size, src, in.Interp))
+
+			for y := 0; y < w; y++ {
+				for x := 0; x < w; x++ {
+					g := v[y*w+x]
+					for _, m := range []int{v[y*w+w-1-x], v[(w-1-y)*w+x], v[x*w+y]} {
+						if d := g - m; d < -16 || d > 16 {
+							t.Fatalf("%s to %dx%d: pixel (%d,%d) is %#x; mirrored %#x", in.Name, size, size, x, y, g, m)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// Test_NoRinging checks that filters with non-negative kernels do not
+// overshoot at a hard edge, and keep the edge monotonic.
+func Test_NoRinging(t *testing.T) {
+	const lo, hi = 0x2000, 0xe000
+
+	src := image.NewGray16(image.Rect(0, 0, 40, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 40; x++ {
+			v := uint16(lo)
+			if x >= 17 {
+				v = hi
+			}
+			src.SetGray16(x, y, color.Gray16{v})
+		}
+	}
+
+	filters := map[string]InterpolationFunction{
+		"NearestNeighbor": NearestNeighbor,
+		"Box":             Box,
+		"Bilinear":        Bilinear,
+		"Hermite":         Hermite,
+		"Gaussian":        Gaussian,
+	}
+
+	for name, interp := range filters {
+		for _, w := range []uint{11, 23, 97} {
+			v, width := gray16(Resize(w, 8, src, interp))
+
+			for y := 0; y < 8; y++ {
+				row := v[y*width : (y+1)*width]
+				for x, g := range row {
+					if g < lo-2 || g > hi+2 {
+						t.Fatalf("%s to width %d: pixel (%d,%d) is %#x; outside [%#x, %#x]", name, w, x, y, g, lo, hi)
+					}
+					if x > 0 && g < row[x-1]-2 {
+						t.Fatalf("%s to width %d: edge is not monotonic at (%d,%d)", name, w, x, y)
+					}
+				}
+			}
+		}
+	}
+
+	// Sanity check: windowed sinc filters are expected to ring.
+	v, _ := gray16(Resize(97, 8, src, Lanczos3))
+	var over bool
+	for _, g := range v {
+		over = over || g > hi+0x100
+	}
+
+	if !over {
+		t.Fatalf("Lanczos3 does not overshoot; the test can not detect ringing")
+	}
+}
@@ -0,0 +1,105 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package resize
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Pyramid returns progressively smaller versions of img. Each level is
+// resized from the previous one, rather than from img itself, which keeps
+// the cost of deep pyramids low.
+//
+// If sizes is empty, the first level is img itself and every following
+// level halves the previous one, until it measures 1x1 pixels. This is
+// the layout of a texture mipmap chain. Otherwise, there is one level for
+// each size, in the given order. A width or height of 0 preserves the
+// aspect ratio of the previous level, like Resize does. Sizes should be
+// given in decreasing order; a level which is larger than the previous
+// one only adds blur.
+//
+// If opt.Sharpen is set, it is applied to each resized level, but the
+// next level is resized from the unsharpened version. This keeps the
+// sharpening from accumulating.
+func Pyramid(img image.Image, interp InterpolationFunction, sizes []image.Point, opt Options) []image.Image {
+	sharpen := opt.Sharpen
+	opt.Sharpen = UnsharpMask{}
+
+	if len(sizes) == 0 {
+		return halvings(img, interp, opt, sharpen)
+	}
+
+	levels := make([]image.Image, len(sizes))
+	for i, size := range sizes {
+		img = ResizeWith(uint(size.X), uint(size.Y), img, interp, opt)
+		levels[i] = Sharpen(img, sharpen)
+	}
+
+	return levels
+}
+
+// halvings returns img and all its halvings, down to 1x1 pixels.
+func halvings(img image.Image, interp InterpolationFunction, opt Options, sharpen UnsharpMask) []image.Image {
+	levels := []image.Image{img}
+	size := img.Bounds().Size()
+
+	for size.X > 1 || size.Y > 1 {
+		size.X = halve(size.X)
+		size.Y = halve(size.Y)
+		img = ResizeWith(uint(size.X), uint(size.Y), img, interp, opt)
+		levels = append(levels, Sharpen(img, sharpen))
+	}
+
+	return levels
+}
+
+func halve(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return n / 2
+}
+
+// Atlas combines the given levels of a pyramid into a single image.
+// The first level is placed in the top-left corner. All other levels
+// are stacked top to bottom, in a column to the right of it. This is
+// the traditional layout for a mipmap chain.
+//
+// It returns the atlas and the area which each level occupies in it.
+func Atlas(levels []image.Image) (image.Image, []image.Rectangle) {
+	if len(levels) == 0 {
+		return image.NewRGBA(image.Rectangle{}), nil
+	}
+
+	rects := make([]image.Rectangle, len(levels))
+	first := levels[0].Bounds().Size()
+	rects[0] = image.Rectangle{Max: first}
+
+	width, height := first.X, first.Y
+	column, y := 0, 0
+
+	for i, img := range levels[1:] {
+		size := img.Bounds().Size()
+		min := image.Pt(first.X, y)
+		rects[i+1] = image.Rectangle{min, min.Add(size)}
+		y += size.Y
+
+		if size.X > column {
+			column = size.X
+		}
+	}
+
+	width += column
+	if y > height {
+		height = y
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i, img := range levels {
+		draw.Draw(dst, rects[i], img, img.Bounds().Min, draw.Src)
+	}
+
+	return dst, rects
+}
@@ -0,0 +1,50 @@
+package resize
+
+import (
+	"image"
+	"testing"
+)
+
+func Test_Pyramid(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 6))
+
+	levels := Pyramid(img, Bilinear, nil, Options{})
+	want := []image.Point{{10, 6}, {5, 3}, {2, 1}, {1, 1}}
+
+	if len(levels) != len(want) {
+		t.Fatalf("Levels: got %d; want %d", len(levels), len(want))
+	}
+
+	for i, v := range levels {
+		if s := v.Bounds().Size(); s != want[i] {
+			t.Errorf("Level %d: got %v; want %v", i, s, want[i])
+		}
+	}
+
+	levels = Pyramid(img, Bilinear, []image.Point{{8, 0}, {4, 2}}, Options{})
+	if s := levels[1].Bounds().Size(); s != image.Pt(4, 2) {
+		t.Errorf("Custom level: got %v; want (4,2)", s)
+	}
+}
+
+func Test_Atlas(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	atlas, rects := Atlas(Pyramid(img, Box, nil, Options{}))
+
+	if s := atlas.Bounds().Size(); s != image.Pt(12, 8) {
+		t.Fatalf("Atlas size: got %v; want (12,8)", s)
+	}
+
+	want := []image.Rectangle{
+		image.Rect(0, 0, 8, 8),
+		image.Rect(8, 0, 12, 4),
+		image.Rect(8, 4, 10, 6),
+		image.Rect(8, 6, 9, 7),
+	}
+
+	for i, r := range rects {
+		if r != want[i] {
+			t.Errorf("Level %d: got %v; want %v", i, r, want[i])
+		}
+	}
+}
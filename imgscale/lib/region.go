@@ -0,0 +1,98 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package resize
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+)
+
+// Region is a rectangle in source image coordinates, which need not be
+// aligned to whole pixels. Pixel (x, y) covers the area from (x, y) to
+// (x+1, y+1), so the region {0, 0, w, h} covers an image of w x h pixels
+// exactly.
+type Region struct {
+	X, Y          float32 // Top-left corner.
+	Width, Height float32
+}
+
+// RectRegion returns the region which covers the given rectangle.
+func RectRegion(r image.Rectangle) Region {
+	return Region{
+		float32(r.Min.X), float32(r.Min.Y),
+		float32(r.Dx()), float32(r.Dy()),
+	}
+}
+
+// ResizeRegion resamples the region r of img to an image of the given
+// size, using the interpolation function interp. The edges of the region
+// map onto the edges of the output. This allows zooming into an image,
+// or rendering it as tiles, without cropping it to whole pixels first.
+//
+// If width or height is 0, it is calculated from the aspect ratio of the
+// region. Parts of the region outside of img are sampled according to
+// opt.Border. opt.PreShrink is ignored.
+//
+// It returns an error if the region is empty.
+func ResizeRegion(width, height uint, img image.Image, r Region, interp InterpolationFunction, opt Options) (image.Image, error) {
+	if !(r.Width > 0 && r.Height > 0) {
+		return nil, fmt.Errorf("Invalid region size: %gx%g", r.Width, r.Height)
+	}
+
+	scaleX, scaleY := calcFactors(width, height, r.Width, r.Height)
+
+	dst := image.NewRGBA64(image.Rect(0, 0, int(0.7+r.Width/scaleX), int(0.7+r.Height/scaleY)))
+	b := dst.Bounds()
+
+	if b.Empty() {
+		return dst, nil
+	}
+
+	// Map the region onto the output exactly. The output size was
+	// rounded, so the scale is derived from it again.
+	scaleX = r.Width / float32(b.Dx())
+	scaleY = r.Height / float32(b.Dy())
+
+	// Filters sample pixels at integer coordinates, whereas the region
+	// has them at the centers of their areas. The adjustment moves each
+	// output pixel center onto the matching point in the region.
+	t := Trans2{scaleX, 0, r.X, 0, scaleY, r.Y}
+	adjustX := 0.5 * (1 - 1/scaleX)
+	adjustY := 0.5 * (1 - 1/scaleY)
+
+	factor := [2]float32{clampFactor(scaleX), clampFactor(scaleY)}
+	render(dst, opt.Border.attach(img), interp, factor, func(x, y int) (float32, float32) {
+		return t.Eval(float32(x)+adjustX, float32(y)+adjustY)
+	})
+
+	return Sharpen(dst, opt.Sharpen), nil
+}
+
+// ParseRegion parses a region of the form "x,y,width,height".
+// All values are in source pixels and may have a fraction.
+func ParseRegion(value string) (Region, error) {
+	var r Region
+
+	list := strings.Split(value, ",")
+	if len(list) != 4 {
+		return r, fmt.Errorf("Invalid region %q; expected x,y,width,height", value)
+	}
+
+	fields := []*float32{&r.X, &r.Y, &r.Width, &r.Height}
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(strings.TrimSpace(list[i]), 32)
+		if err != nil {
+			return r, fmt.Errorf("Invalid region value: %s", list[i])
+		}
+		*f = float32(v)
+	}
+
+	if !(r.Width > 0 && r.Height > 0) {
+		return r, fmt.Errorf("Invalid region size: %gx%g", r.Width, r.Height)
+	}
+
+	return r, nil
+}
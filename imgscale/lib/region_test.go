@@ -0,0 +1,92 @@
+package resize
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func Test_RegionFull(t *testing.T) {
+	src := patternImage(patterns[0], 40)
+
+	for _, interp := range []InterpolationFunction{Bilinear, Lanczos3} {
+		want, _ := gray16(Resize(17, 0, src, interp))
+
+		img, err := ResizeRegion(17, 0, src, RectRegion(src.Bounds()), interp, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, _ := gray16(img)
+		for i := range want {
+			if d := got[i] - want[i]; d < -1 || d > 1 {
+				t.Fatalf("pixel %d is %#x; want %#x", i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func Test_RegionSubpixel(t *testing.T) {
+	// Bilinear interpolation of a linear ramp is exact,
+	// so the output shows where each pixel was sampled.
+	src := image.NewGray16(image.Rect(0, 0, 32, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 32; x++ {
+			src.SetGray16(x, y, color.Gray16{uint16(x * 1000)})
+		}
+	}
+
+	r := Region{10.25, 0, 8, 4}
+	img, err := ResizeRegion(8, 4, src, r, Bilinear, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, _ := gray16(img)
+	for x := 0; x < 8; x++ {
+		want := int(1000 * (10.25 + float32(x)))
+		if d := v[x] - want; d < -2 || d > 2 {
+			t.Fatalf("pixel %d is %d; want %d", x, v[x], want)
+		}
+	}
+}
+
+func Test_RegionZoom(t *testing.T) {
+	src := patternImage(patterns[1], 24)
+
+	img, err := ResizeRegion(16, 16, src, Region{10, 10, 4, 4}, NearestNeighbor, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			if got, want := img.At(x, y), src.At(10+x/4, 10+y/4); !sameColor(got, want) {
+				t.Fatalf("pixel (%d,%d) is %v; want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func sameColor(a, b color.Color) bool {
+	r0, g0, b0, a0 := a.RGBA()
+	r1, g1, b1, a1 := b.RGBA()
+	return r0 == r1 && g0 == g1 && b0 == b1 && a0 == a1
+}
+
+func Test_ParseRegion(t *testing.T) {
+	r, err := ParseRegion("1.5, 2,10.25,8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r != (Region{1.5, 2, 10.25, 8}) {
+		t.Fatalf("got %v", r)
+	}
+
+	for _, v := range []string{"", "1,2,3", "1,2,0,4", "a,2,3,4", "1,2,3,-4"} {
+		if _, err := ParseRegion(v); err == nil {
+			t.Fatalf("ParseRegion(%q) succeeded; want an error", v)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package resize
+
+import "strings"
+
+func init() {
+	RegisterInterpolation("NearestNeighbor", NearestNeighbor)
+	RegisterInterpolation("Box", Box)
+	RegisterInterpolation("Bilinear", Bilinear)
+	RegisterInterpolation("Hermite", Hermite)
+	RegisterInterpolation("Bicubic", Bicubic)
+	RegisterInterpolation("CatmullRom", CatmullRom)
+	RegisterInterpolation("MitchellNetravali", MitchellNetravali)
+	RegisterInterpolation("Gaussian", Gaussian)
+	RegisterInterpolation("Lanczos2Lut", Lanczos2Lut)
+	RegisterInterpolation("Lanczos2", Lanczos2)
+	RegisterInterpolation("Lanczos3Lut", Lanczos3Lut)
+	RegisterInterpolation("Lanczos3", Lanczos3)
+	RegisterInterpolation("Hann", Hann(3))
+	RegisterInterpolation("Hamming", Hamming(3))
+	RegisterInterpolation("Blackman", Blackman(3))
+	RegisterInterpolation("Lanczos4", Lanczos(4))
+}
+
+// List of registered interpolation functions.
+var Interpolations []*Interpolation
+
+// Interpolation describes a named interpolation function.
+type Interpolation struct {
+	Name   string                // Name of the algorithm: bilinear, lanczos3, etc
+	Interp InterpolationFunction // Interpolation handler
+}
+
+// RegisterInterpolation registers an interpolation function
+// under the given name. This makes it available through Lookup.
+func RegisterInterpolation(name string, interp InterpolationFunction) {
+	Interpolations = append(Interpolations, &Interpolation{
+		Name:   name,
+		Interp: interp,
+	})
+}
+
+// Lookup returns the interpolation function registered under
+// the given name. Names are matched case-insensitively.
+// It returns nil if no such function exists.
+func Lookup(name string) InterpolationFunction {
+	for _, in := range Interpolations {
+		if strings.EqualFold(name, in.Name) {
+			return in.Interp
+		}
+	}
+
+	return nil
+}
+
+// InterpolationNames returns the names of all registered
+// interpolation functions, in order of registration.
+func InterpolationNames() []string {
+	list := make([]string, 0, len(Interpolations))
+
+	for _, in := range Interpolations {
+		list = append(list, in.Name)
+	}
+
+	return list
+}
@@ -51,17 +51,46 @@ type Filter interface {
 // to prevent moire patterns.
 type InterpolationFunction func(image.Image, [2]float32) Filter
 
+// Options holds optional parameters for ResizeWith.
+// The zero value yields the default behaviour of Resize.
+type Options struct {
+	// Border defines how pixels outside of the source image are sampled.
+	Border Border
+
+	// Sharpen is applied to the resized image. The zero value
+	// disables sharpening.
+	Sharpen UnsharpMask
+
+	// PreShrink enables a pre-reduction step for large reduction factors.
+	// If the image is reduced by more than PreShrink times along an axis,
+	// it is first reduced to PreShrink times the target size with Shrink.
+	// The interpolation filter then performs the remaining reduction. This
+	// is a lot faster, with little loss of quality for values of 2 or more.
+	// Values below 1 disable the pre-reduction.
+	PreShrink float32
+}
+
 // Resize an image to new width and height using the interpolation function interp.
 // A new image with the given dimensions will be returned.
 // If one of the parameters width or height is set to 0, its size will be calculated so that
 // the aspect ratio is that of the originating image.
 // The resizing algorithm uses channels for parallel computation.
 func Resize(width, height uint, img image.Image, interp InterpolationFunction) image.Image {
+	return ResizeWith(width, height, img, interp, Options{})
+}
+
+// ResizeWith behaves like Resize, but accepts additional options.
+func ResizeWith(width, height uint, img image.Image, interp InterpolationFunction, opt Options) image.Image {
 	oldBounds := img.Bounds()
 	oldWidth := float32(oldBounds.Dx())
 	oldHeight := float32(oldBounds.Dy())
 
 	scaleX, scaleY := calcFactors(width, height, oldWidth, oldHeight)
+
+	if opt.PreShrink >= 1 && (scaleX > opt.PreShrink || scaleY > opt.PreShrink) {
+		return preShrink(img, scaleX, scaleY, interp, opt)
+	}
+
 	t := Trans2{scaleX, 0, float32(oldBounds.Min.X), 0, scaleY, float32(oldBounds.Min.Y)}
 
 	resizedImg := image.NewRGBA64(image.Rect(0, 0, int(0.7+oldWidth/scaleX), int(0.7+oldHeight/scaleY)))
@@ -69,27 +98,60 @@ func Resize(width, height uint, img image.Image, interp InterpolationFunction) i
 	adjustX := 0.5 * ((oldWidth-1.0)/scaleX - float32(b.Dx()-1))
 	adjustY := 0.5 * ((oldHeight-1.0)/scaleY - float32(b.Dy()-1))
 
+	factor := [2]float32{clampFactor(scaleX), clampFactor(scaleY)}
+	render(resizedImg, opt.Border.attach(img), interp, factor, func(x, y int) (float32, float32) {
+		return t.Eval(float32(x)+adjustX, float32(y)+adjustY)
+	})
+
+	return Sharpen(resizedImg, opt.Sharpen)
+}
+
+// preShrink reduces img with Shrink, so the remaining reduction factor
+// is at most opt.PreShrink along either axis. It then resizes the result
+// to the final size with the interpolation filter.
+func preShrink(img image.Image, scaleX, scaleY float32, interp InterpolationFunction, opt Options) image.Image {
+	b := img.Bounds()
+	width := int(0.7 + float32(b.Dx())/scaleX)
+	height := int(0.7 + float32(b.Dy())/scaleY)
+
+	w, h := b.Dx(), b.Dy()
+	if scaleX > opt.PreShrink {
+		w = int(float32(width)*opt.PreShrink + 0.5)
+	}
+	if scaleY > opt.PreShrink {
+		h = int(float32(height)*opt.PreShrink + 0.5)
+	}
+
+	opt.PreShrink = 0
+	return ResizeWith(uint(width), uint(height), Shrink(uint(w), uint(h), img), interp, opt)
+}
+
+// render fills dst with colors sampled from img. The eval function maps
+// each destination pixel onto the source point to interpolate.
+// The rows of dst are divided into bands which are processed in parallel.
+func render(dst *image.RGBA64, img image.Image, interp InterpolationFunction, factor [2]float32, eval func(x, y int) (float32, float32)) {
+	b := dst.Bounds()
 	n := numJobs(b.Dy())
 	c := make(chan int, n)
 	for i := 0; i < n; i++ {
 		go func(b image.Rectangle, c chan int) {
-			filter := interp(img, [2]float32{clampFactor(scaleX), clampFactor(scaleY)})
+			filter := interp(img, factor)
 			var u, v float32
 			var color color.RGBA64
 			for y := b.Min.Y; y < b.Max.Y; y++ {
 				for x := b.Min.X; x < b.Max.X; x++ {
-					u, v = t.Eval(float32(x)+adjustX, float32(y)+adjustY)
+					u, v = eval(x, y)
 					color = filter.Interpolate(u, v)
 
-					i := resizedImg.PixOffset(x, y)
-					resizedImg.Pix[i+0] = uint8(color.R >> 8)
-					resizedImg.Pix[i+1] = uint8(color.R)
-					resizedImg.Pix[i+2] = uint8(color.G >> 8)
-					resizedImg.Pix[i+3] = uint8(color.G)
-					resizedImg.Pix[i+4] = uint8(color.B >> 8)
-					resizedImg.Pix[i+5] = uint8(color.B)
-					resizedImg.Pix[i+6] = uint8(color.A >> 8)
-					resizedImg.Pix[i+7] = uint8(color.A)
+					i := dst.PixOffset(x, y)
+					dst.Pix[i+0] = uint8(color.R >> 8)
+					dst.Pix[i+1] = uint8(color.R)
+					dst.Pix[i+2] = uint8(color.G >> 8)
+					dst.Pix[i+3] = uint8(color.G)
+					dst.Pix[i+4] = uint8(color.B >> 8)
+					dst.Pix[i+5] = uint8(color.B)
+					dst.Pix[i+6] = uint8(color.A >> 8)
+					dst.Pix[i+7] = uint8(color.A)
 				}
 			}
 			c <- 1
@@ -99,8 +161,6 @@ func Resize(width, height uint, img image.Image, interp InterpolationFunction) i
 	for i := 0; i < n; i++ {
 		<-c
 	}
-
-	return resizedImg
 }
 
 // Calculate scaling factors using old and new image dimensions.
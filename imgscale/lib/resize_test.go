@@ -78,3 +78,78 @@ func Benchmark_Reduction(b *testing.B) {
 	}
 	m.At(0, 0)
 }
+
+func Test_Lookup(t *testing.T) {
+	for _, name := range InterpolationNames() {
+		if Lookup(name) == nil {
+			t.Errorf("Lookup(%q) == nil", name)
+		}
+	}
+
+	if Lookup("lanczos3") == nil {
+		t.Error("Lookup is not case-insensitive")
+	}
+
+	if Lookup("nonexistent") != nil {
+		t.Error("Lookup returned unknown filter")
+	}
+}
+
+func Test_CustomKernel(t *testing.T) {
+	interp := NewInterpolation(func(x float32) float32 {
+		if x > -1 && x < 1 {
+			return 1
+		}
+		return 0
+	}, 1)
+
+	m := Resize(6, 0, img, interp)
+	if m.Bounds() != image.Rect(0, 0, 6, 6) {
+		t.Fail()
+	}
+}
+
+// Test_ResizeEdges pins the edge pixels of an upscaled image. These are
+// sampled at negative source coordinates, where the window of kernel taps
+// must start at the pixel below the coordinate, not the one towards zero.
+// Mirroring the input must mirror the output.
+func Test_ResizeEdges(t *testing.T) {
+	row := []uint16{0x0000, 0xffff, 0x4000, 0x8000}
+
+	src := image.NewGray16(image.Rect(0, 0, 4, 1))
+	rev := image.NewGray16(image.Rect(0, 0, 4, 1))
+	for x, v := range row {
+		src.SetGray16(x, 0, color.Gray16{v})
+		rev.SetGray16(3-x, 0, color.Gray16{v})
+	}
+
+	tests := []struct {
+		name   string
+		interp InterpolationFunction
+		want   [4]uint16 // Pixels 0, 1, 6 and 7.
+	}{
+		{"bicubic", Bicubic, [4]uint16{0x0000, 0x387f, 0x6e80, 0x8480}},
+		{"lanczos3", Lanczos3, [4]uint16{0x0000, 0x41f8, 0x6501, 0x8c62}},
+	}
+
+	gray := func(img image.Image, x int) uint16 {
+		return color.Gray16Model.Convert(img.At(x, 0)).(color.Gray16).Y
+	}
+
+	for _, tt := range tests {
+		a := Resize(8, 1, src, tt.interp)
+		b := Resize(8, 1, rev, tt.interp)
+
+		for i, x := range []int{0, 1, 6, 7} {
+			if v := gray(a, x); v != tt.want[i] {
+				t.Errorf("%s: pixel %d is %#04x; want %#04x", tt.name, x, v, tt.want[i])
+			}
+		}
+
+		for x := 0; x < 8; x++ {
+			if va, vb := gray(a, x), gray(b, 7-x); va != vb {
+				t.Errorf("%s: pixel %d is %#04x; mirrored %#04x", tt.name, x, va, vb)
+			}
+		}
+	}
+}
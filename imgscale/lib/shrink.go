@@ -0,0 +1,118 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package resize
+
+import (
+	"image"
+	"math"
+)
+
+// Shrink reduces img to the given size by exact area averaging. Each
+// destination pixel is the average of the source pixels it covers,
+// weighted by how much of each source pixel it covers. For integer
+// reduction ratios, this is the plain mean of a block of pixels.
+//
+// Unlike the interpolation filters, the cost per source pixel does not
+// grow with the reduction factor. This makes Shrink suitable for very
+// large reductions. It does not enlarge images; sizes larger than the
+// source are clamped. A width or height of 0 preserves the aspect ratio,
+// like Resize does.
+func Shrink(width, height uint, img image.Image) image.Image {
+	b := img.Bounds()
+	scaleX, scaleY := calcFactors(width, height, float32(b.Dx()), float32(b.Dy()))
+
+	w := int(0.7 + float32(b.Dx())/clampFactor(scaleX))
+	h := int(0.7 + float32(b.Dy())/clampFactor(scaleY))
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	cols := coverage(b.Dx(), w)
+	rows := coverage(b.Dy(), h)
+	conv := newConverter(img)
+	dst := image.NewRGBA64(image.Rect(0, 0, w, h))
+
+	parallel(h, func(min, max int) {
+		acc := make([]float32, 4*w)
+		row := make([]float32, 4*w)
+
+		for y := min; y < max; y++ {
+			for i := range acc {
+				acc[i] = 0
+			}
+
+			span := rows[y]
+			for j, wy := range span.weights {
+				// Reduce the source row horizontally,
+				// then add it to the output row.
+				sy := b.Min.Y + span.first + j
+				for x, col := range cols {
+					var c colorArray
+					for k, wx := range col.weights {
+						p := conv.at(b.Min.X+col.first+k, sy)
+						c[0] += wx * p[0]
+						c[1] += wx * p[1]
+						c[2] += wx * p[2]
+						c[3] += wx * p[3]
+					}
+					copy(row[4*x:], c[:])
+				}
+
+				for i, v := range row {
+					acc[i] += wy * v
+				}
+			}
+
+			for x := 0; x < w; x++ {
+				i := dst.PixOffset(x, y)
+				for c := 0; c < 4; c++ {
+					v := clampToUint16(acc[4*x+c] + 0.5)
+					dst.Pix[i+2*c+0] = uint8(v >> 8)
+					dst.Pix[i+2*c+1] = uint8(v)
+				}
+			}
+		}
+	})
+
+	return dst
+}
+
+// span describes the source pixels which contribute to a destination
+// pixel, along one axis. The weights sum to 1.
+type span struct {
+	first   int
+	weights []float32
+}
+
+// coverage returns a span for each of the dst pixels which evenly
+// divide the src pixels.
+func coverage(src, dst int) []span {
+	s := float64(src) / float64(dst)
+	spans := make([]span, dst)
+
+	for i := range spans {
+		start := float64(i) * s
+		end := float64(i+1) * s
+		first := int(math.Floor(start))
+		last := int(math.Ceil(end))
+
+		if last > src {
+			last = src
+		}
+
+		sp := span{first: first, weights: make([]float32, last-first)}
+		for j := range sp.weights {
+			lo := math.Max(start, float64(first+j))
+			hi := math.Min(end, float64(first+j+1))
+			sp.weights[j] = float32((hi - lo) / s)
+		}
+
+		spans[i] = sp
+	}
+
+	return spans
+}
@@ -0,0 +1,76 @@
+package resize
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func Test_Shrink(t *testing.T) {
+	// A 4x4 checkerboard of black and white 2x2 blocks.
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if (x/2+y/2)%2 == 0 {
+				img.SetGray(x, y, color.Gray{0xff})
+			}
+		}
+	}
+
+	// Integer ratio: each output pixel covers a single block.
+	out := Shrink(4, 4, img)
+	if v, _, _, _ := out.At(0, 0).RGBA(); v != 0xffff {
+		t.Errorf("Block (0,0): got %04x; want ffff", v)
+	}
+	if v, _, _, _ := out.At(1, 0).RGBA(); v != 0 {
+		t.Errorf("Block (1,0): got %04x; want 0", v)
+	}
+
+	// Fractional ratio: output pixels cover parts of blocks, but the
+	// overall mean must be preserved.
+	out = Shrink(3, 0, img)
+	if s := out.Bounds().Size(); s != image.Pt(3, 3) {
+		t.Fatalf("Size: got %v; want (3,3)", s)
+	}
+
+	var sum uint32
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 3; x++ {
+			v, _, _, _ := out.At(x, y).RGBA()
+			sum += v
+		}
+	}
+
+	if mean := sum / 9; mean < 0x7f00 || mean > 0x8100 {
+		t.Errorf("Mean: got %04x; want 8000", mean)
+	}
+}
+
+func Test_PreShrink(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 500, 300))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i / 4 % 251)
+	}
+
+	a := ResizeWith(20, 0, img, Lanczos3, Options{})
+	b := ResizeWith(20, 0, img, Lanczos3, Options{PreShrink: 3})
+
+	if a.Bounds() != b.Bounds() {
+		t.Fatalf("Bounds: got %v; want %v", b.Bounds(), a.Bounds())
+	}
+
+	var diff float64
+	r := a.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			v0, _, _, _ := a.At(x, y).RGBA()
+			v1, _, _, _ := b.At(x, y).RGBA()
+			diff += float64(v0) - float64(v1)
+		}
+	}
+
+	// The results differ in detail, but not in overall brightness.
+	if mean := diff / float64(r.Dx()*r.Dy()); mean > 0x200 || mean < -0x200 {
+		t.Errorf("Mean difference too large: %f", mean)
+	}
+}
@@ -0,0 +1,189 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package resize
+
+import (
+	"fmt"
+	"github.com/jteeuwen/imgtools/lib"
+	"image"
+	"io"
+	"math"
+	"runtime"
+)
+
+// ResizeStream behaves like ResizeWith, but reads the source image row by
+// row from src, and encodes the result row by row to w, in the given format.
+// See lib.NewRowWriter for the supported formats.
+//
+// Only a window of source rows is held in memory. Its height is bounded by
+// the height of the filter kernel, plus the rows needed for a strip of
+// output rows which is resampled in parallel. Memory use is therefore
+// independent of the image height.
+//
+// The Wrap border mode, sharpening and pre-shrinking need access to rows
+// outside of the window, and are not supported.
+func ResizeStream(w io.Writer, format string, width, height uint, src lib.RowReader, interp InterpolationFunction, opt Options) error {
+	if opt.Border.Mode == Wrap {
+		return fmt.Errorf("The wrap border mode is not supported by streaming resizes")
+	}
+
+	if opt.Sharpen.Amount > 0 {
+		return fmt.Errorf("Sharpening is not supported by streaming resizes")
+	}
+
+	if opt.PreShrink >= 1 {
+		return fmt.Errorf("Pre-shrinking is not supported by streaming resizes")
+	}
+
+	srcSize := src.Size()
+	oldWidth := float32(srcSize.X)
+	oldHeight := float32(srcSize.Y)
+
+	scaleX, scaleY := calcFactors(width, height, oldWidth, oldHeight)
+	t := Trans2{scaleX, 0, 0, 0, scaleY, 0}
+
+	dstSize := image.Pt(int(0.7+oldWidth/scaleX), int(0.7+oldHeight/scaleY))
+	adjustX := 0.5 * ((oldWidth-1.0)/scaleX - float32(dstSize.X-1))
+	adjustY := 0.5 * ((oldHeight-1.0)/scaleY - float32(dstSize.Y-1))
+
+	dst, err := lib.NewRowWriter(w, format, dstSize)
+	if err != nil {
+		return err
+	}
+
+	factor := [2]float32{clampFactor(scaleX), clampFactor(scaleY)}
+	taps := kernelRows(interp, factor)
+
+	// firstRow returns the first source row read by the filter, when
+	// computing the given output row. It errs on the low side by one
+	// row, to be safe from rounding errors.
+	firstRow := func(y int) int {
+		v := scaleY * (float32(y) + adjustY)
+		return int(math.Floor(float64(v))) - taps/2
+	}
+
+	win := newRowWindow(src, taps+2+int(math.Ceil(float64(scaleY)))*stripRows())
+	strip := image.NewRGBA64(image.Rect(0, 0, dstSize.X, stripRows()))
+
+	for y0 := 0; y0 < dstSize.Y; y0 += strip.Rect.Dy() {
+		y1 := y0 + stripRows()
+		if y1 > dstSize.Y {
+			y1 = dstSize.Y
+		}
+
+		img, err := win.load(firstRow(y0), firstRow(y1-1)+taps+2)
+		if err != nil {
+			return err
+		}
+
+		strip.Rect = image.Rect(0, y0, dstSize.X, y1)
+		strip.Pix = strip.Pix[:strip.Stride*(y1-y0)]
+
+		render(strip, opt.Border.attach(img), interp, factor, func(x, y int) (float32, float32) {
+			return t.Eval(float32(x)+adjustX, float32(y)+adjustY)
+		})
+
+		for y := y0; y < y1; y++ {
+			i := strip.PixOffset(0, y)
+			if err = dst.WriteRow(strip.Pix[i : i+strip.Stride]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return dst.Close()
+}
+
+// stripRows returns the number of output rows which
+// are resampled in parallel by ResizeStream.
+func stripRows() int {
+	return 4 * runtime.NumCPU()
+}
+
+// kernelRows returns the number of source rows which a filter reads
+// for a single output pixel. Custom filters are assumed to read no more
+// than a Lanczos4 filter would.
+func kernelRows(interp InterpolationFunction, factor [2]float32) int {
+	probe := interp(image.NewRGBA64(image.Rect(0, 0, 1, 1)), factor)
+	if f, ok := probe.(*filterModel); ok {
+		return len(f.tempCol)
+	}
+	return 8 * int(math.Ceil(float64(factor[1])))
+}
+
+// rowWindow holds a contiguous range of rows from a RowReader.
+type rowWindow struct {
+	src      lib.RowReader
+	size     image.Point
+	stride   int
+	pix      []uint8
+	min, max int // Source rows held in pix.
+}
+
+func newRowWindow(src lib.RowReader, rows int) *rowWindow {
+	size := src.Size()
+	if rows > size.Y {
+		rows = size.Y
+	}
+
+	return &rowWindow{
+		src:    src,
+		size:   size,
+		stride: 8 * size.X,
+		pix:    make([]uint8, 0, 8*size.X*rows),
+	}
+}
+
+// load makes sure the window holds the source rows [min, max), clamped
+// to the image bounds, and returns them as an image. Rows are only read
+// forward. Rows before min are discarded.
+func (w *rowWindow) load(min, max int) (*image.RGBA64, error) {
+	if min < 0 {
+		min = 0
+	}
+
+	if max > w.size.Y {
+		max = w.size.Y
+	}
+
+	if min < w.min {
+		min = w.min
+	}
+
+	if min > w.max {
+		// Skip rows which are not needed at all.
+		row := make([]uint8, w.stride)
+		for ; w.max < min; w.max++ {
+			if err := w.src.ReadRow(row); err != nil {
+				return nil, err
+			}
+		}
+		w.min = min
+		w.pix = w.pix[:0]
+	}
+
+	// Discard rows before min.
+	drop := (min - w.min) * w.stride
+	w.pix = append(w.pix[:0], w.pix[drop:]...)
+	w.min = min
+
+	for ; w.max < max; w.max++ {
+		n := len(w.pix)
+		if n+w.stride <= cap(w.pix) {
+			w.pix = w.pix[:n+w.stride]
+		} else {
+			w.pix = append(w.pix, make([]uint8, w.stride)...)
+		}
+
+		if err := w.src.ReadRow(w.pix[n:]); err != nil {
+			return nil, err
+		}
+	}
+
+	return &image.RGBA64{
+		Pix:    w.pix,
+		Stride: w.stride,
+		Rect:   image.Rect(0, w.min, w.size.X, w.max),
+	}, nil
+}
@@ -0,0 +1,59 @@
+package resize
+
+import (
+	"bytes"
+	"github.com/jteeuwen/imgtools/lib"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func Test_ResizeStream(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 97, 211))
+	for y := 0; y < 211; y++ {
+		for x := 0; x < 97; x++ {
+			src.Set(x, y, color.NRGBA{uint8(x * 5), uint8(y * 3), uint8(x ^ y), 0xff})
+		}
+	}
+
+	var in bytes.Buffer
+	if err := png.Encode(&in, src); err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := [][2]uint{{40, 0}, {0, 400}, {13, 17}}
+	for _, size := range sizes {
+		rr, _, err := lib.NewRowReader(bytes.NewReader(in.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out bytes.Buffer
+		err = ResizeStream(&out, "png", size[0], size[1], rr, Lanczos3, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := png.Decode(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := Resize(size[0], size[1], src, Lanczos3)
+		if got.Bounds() != want.Bounds() {
+			t.Fatalf("%v: bounds %v; want %v", size, got.Bounds(), want.Bounds())
+		}
+
+		b := want.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c0 := color.NRGBAModel.Convert(got.At(x, y)).(color.NRGBA)
+				c1 := color.NRGBAModel.Convert(want.At(x, y)).(color.NRGBA)
+				if c0 != c1 {
+					t.Fatalf("%v: pixel (%d,%d) is %v; want %v", size, x, y, c0, c1)
+				}
+			}
+		}
+	}
+}
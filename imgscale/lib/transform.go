@@ -0,0 +1,174 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package resize
+
+import (
+	"fmt"
+	"math"
+)
+
+// Transform maps points from one coordinate space onto another.
+// Both *Trans2 and *Trans3 implement this interface.
+type Transform interface {
+	Eval(x, y float32) (u, v float32)
+}
+
+// Identity returns the identity transformation.
+func Identity() Trans2 {
+	return Trans2{1, 0, 0, 0, 1, 0}
+}
+
+// Translation returns a transformation which moves points by (dx, dy).
+func Translation(dx, dy float32) Trans2 {
+	return Trans2{1, 0, dx, 0, 1, dy}
+}
+
+// Scaling returns a transformation which scales points by (sx, sy)
+// relative to the origin. Negative factors flip the image.
+func Scaling(sx, sy float32) Trans2 {
+	return Trans2{sx, 0, 0, 0, sy, 0}
+}
+
+// Rotation returns a transformation which rotates points clockwise
+// around the origin by the given angle in degrees. Note that the
+// y-axis points down in image space.
+func Rotation(degrees float64) Trans2 {
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	return Trans2{float32(cos), float32(-sin), 0, float32(sin), float32(cos), 0}
+}
+
+// Shearing returns a transformation which shears points by kx
+// along the x-axis and by ky along the y-axis.
+func Shearing(kx, ky float32) Trans2 {
+	return Trans2{1, kx, 0, ky, 1, 0}
+}
+
+// Mul returns the transformation which first applies u and then t.
+func (t Trans2) Mul(u Trans2) Trans2 {
+	return Trans2{
+		t[0]*u[0] + t[1]*u[3],
+		t[0]*u[1] + t[1]*u[4],
+		t[0]*u[2] + t[1]*u[5] + t[2],
+		t[3]*u[0] + t[4]*u[3],
+		t[3]*u[1] + t[4]*u[4],
+		t[3]*u[2] + t[4]*u[5] + t[5],
+	}
+}
+
+// Invert returns the inverse of t.
+// It returns an error if t is not invertible.
+func (t Trans2) Invert() (Trans2, error) {
+	det := float64(t[0])*float64(t[4]) - float64(t[1])*float64(t[3])
+	if math.Abs(det) < 1e-12 {
+		return t, fmt.Errorf("Transformation is not invertible")
+	}
+
+	a := float64(t[4]) / det
+	b := -float64(t[1]) / det
+	d := -float64(t[3]) / det
+	e := float64(t[0]) / det
+
+	return Trans2{
+		float32(a), float32(b), float32(-a*float64(t[2]) - b*float64(t[5])),
+		float32(d), float32(e), float32(-d*float64(t[2]) - e*float64(t[5])),
+	}, nil
+}
+
+// Trans3 is a 2-dimensional projective transformation.
+// It holds a 3x3 matrix in row-major order.
+type Trans3 [9]float32
+
+// Apply the transformation to a point (x,y).
+func (t *Trans3) Eval(x, y float32) (u, v float32) {
+	w := t[6]*x + t[7]*y + t[8]
+	if w == 0 {
+		w = 1e-12
+	}
+
+	u = (t[0]*x + t[1]*y + t[2]) / w
+	v = (t[3]*x + t[4]*y + t[5]) / w
+	return
+}
+
+// Invert returns the inverse of t.
+// It returns an error if t is not invertible.
+func (t Trans3) Invert() (Trans3, error) {
+	var m [9]float64
+	for i := range t {
+		m[i] = float64(t[i])
+	}
+
+	c0 := m[4]*m[8] - m[5]*m[7]
+	c1 := m[5]*m[6] - m[3]*m[8]
+	c2 := m[3]*m[7] - m[4]*m[6]
+	det := m[0]*c0 + m[1]*c1 + m[2]*c2
+
+	if math.Abs(det) < 1e-12 {
+		return t, fmt.Errorf("Transformation is not invertible")
+	}
+
+	return Trans3{
+		float32(c0 / det),
+		float32((m[2]*m[7] - m[1]*m[8]) / det),
+		float32((m[1]*m[5] - m[2]*m[4]) / det),
+		float32(c1 / det),
+		float32((m[0]*m[8] - m[2]*m[6]) / det),
+		float32((m[2]*m[3] - m[0]*m[5]) / det),
+		float32(c2 / det),
+		float32((m[1]*m[6] - m[0]*m[7]) / det),
+		float32((m[0]*m[4] - m[1]*m[3]) / det),
+	}, nil
+}
+
+// Homography computes the projective transformation which maps
+// the four points in from onto the corresponding points in to.
+// It returns an error if no such transformation exists; for
+// instance when three of the points are collinear.
+func Homography(from, to [4][2]float32) (Trans3, error) {
+	// Solve the 8x8 linear system A*h = b for the first eight
+	// matrix elements. The last element is fixed at 1.
+	var a [8][9]float64
+
+	for i := 0; i < 4; i++ {
+		x, y := float64(from[i][0]), float64(from[i][1])
+		u, v := float64(to[i][0]), float64(to[i][1])
+
+		a[2*i] = [9]float64{x, y, 1, 0, 0, 0, -u * x, -u * y, u}
+		a[2*i+1] = [9]float64{0, 0, 0, x, y, 1, -v * x, -v * y, v}
+	}
+
+	// Gaussian elimination with partial pivoting.
+	for col := 0; col < 8; col++ {
+		pivot := col
+		for row := col + 1; row < 8; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return Trans3{}, fmt.Errorf("Degenerate point correspondences")
+		}
+
+		a[col], a[pivot] = a[pivot], a[col]
+
+		for row := 0; row < 8; row++ {
+			if row == col {
+				continue
+			}
+
+			f := a[row][col] / a[col][col]
+			for k := col; k < 9; k++ {
+				a[row][k] -= f * a[col][k]
+			}
+		}
+	}
+
+	var t Trans3
+	for i := 0; i < 8; i++ {
+		t[i] = float32(a[i][8] / a[i][i])
+	}
+	t[8] = 1
+	return t, nil
+}
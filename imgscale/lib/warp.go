@@ -0,0 +1,117 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package resize
+
+import (
+	"image"
+	"math"
+)
+
+// WarpAffine applies the affine transformation t to img, using the
+// interpolation function interp. The transformation maps source
+// coordinates onto destination coordinates. The returned image covers
+// the given destination bounds. Areas which map outside of the source
+// image are sampled according to opt.Border.
+//
+// It returns an error if t is not invertible.
+func WarpAffine(img image.Image, t Trans2, bounds image.Rectangle, interp InterpolationFunction, opt Options) (image.Image, error) {
+	inv, err := t.Invert()
+	if err != nil {
+		return nil, err
+	}
+
+	// The change in source coordinates for a step of one destination
+	// pixel determines how far the filter kernel has to be scaled.
+	factor := [2]float32{
+		clampFactor(float32(math.Hypot(float64(inv[0]), float64(inv[1])))),
+		clampFactor(float32(math.Hypot(float64(inv[3]), float64(inv[4])))),
+	}
+
+	return warp(img, &inv, factor, bounds, interp, opt), nil
+}
+
+// WarpPerspective applies the projective transformation t to img, using
+// the interpolation function interp. The transformation maps source
+// coordinates onto destination coordinates. The returned image covers
+// the given destination bounds. Areas which map outside of the source
+// image are sampled according to opt.Border.
+//
+// It returns an error if t is not invertible.
+func WarpPerspective(img image.Image, t Trans3, bounds image.Rectangle, interp InterpolationFunction, opt Options) (image.Image, error) {
+	inv, err := t.Invert()
+	if err != nil {
+		return nil, err
+	}
+
+	// The scale of a perspective transformation varies across the image.
+	// Approximate it by the local derivatives at the destination center.
+	cx := float32(bounds.Min.X+bounds.Max.X) * 0.5
+	cy := float32(bounds.Min.Y+bounds.Max.Y) * 0.5
+	u0, v0 := inv.Eval(cx, cy)
+	u1, v1 := inv.Eval(cx+1, cy)
+	u2, v2 := inv.Eval(cx, cy+1)
+
+	factor := [2]float32{
+		clampFactor(float32(math.Hypot(float64(u1-u0), float64(u2-u0)))),
+		clampFactor(float32(math.Hypot(float64(v1-v0), float64(v2-v0)))),
+	}
+
+	return warp(img, &inv, factor, bounds, interp, opt), nil
+}
+
+// warp samples img at the points which the inverse transformation
+// inv yields for every pixel in the given destination bounds.
+func warp(img image.Image, inv Transform, factor [2]float32, bounds image.Rectangle, interp InterpolationFunction, opt Options) image.Image {
+	dst := image.NewRGBA64(bounds)
+
+	// Pixel (x, y) covers the area [x, x+1) x [y, y+1), while the
+	// filters interpolate around pixel centers. Hence the offsets.
+	render(dst, opt.Border.attach(img), interp, factor, func(x, y int) (float32, float32) {
+		u, v := inv.Eval(float32(x)+0.5, float32(y)+0.5)
+		return u - 0.5, v - 0.5
+	})
+
+	return dst
+}
+
+// TransformBounds returns the smallest rectangle which holds
+// all points of rect after transformation with t.
+func TransformBounds(t Transform, rect image.Rectangle) image.Rectangle {
+	corners := [4][2]float32{
+		{float32(rect.Min.X), float32(rect.Min.Y)},
+		{float32(rect.Max.X), float32(rect.Min.Y)},
+		{float32(rect.Max.X), float32(rect.Max.Y)},
+		{float32(rect.Min.X), float32(rect.Max.Y)},
+	}
+
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+
+	for _, c := range corners {
+		u, v := t.Eval(c[0], c[1])
+		minX = math.Min(minX, float64(u))
+		minY = math.Min(minY, float64(v))
+		maxX = math.Max(maxX, float64(u))
+		maxY = math.Max(maxY, float64(v))
+	}
+
+	// Allow for rounding errors, so an exact 90 degree
+	// rotation does not gain an extra row or column.
+	const eps = 1e-3
+
+	return image.Rect(
+		int(math.Floor(minX+eps)),
+		int(math.Floor(minY+eps)),
+		int(math.Ceil(maxX-eps)),
+		int(math.Ceil(maxY-eps)),
+	)
+}
+
+// Centered returns a transformation which applies t relative
+// to the center of rect, instead of the origin.
+func Centered(t Trans2, rect image.Rectangle) Trans2 {
+	cx := float32(rect.Min.X+rect.Max.X) * 0.5
+	cy := float32(rect.Min.Y+rect.Max.Y) * 0.5
+	return Translation(cx, cy).Mul(t).Mul(Translation(-cx, -cy))
+}
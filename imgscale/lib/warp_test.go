@@ -0,0 +1,88 @@
+package resize
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func near(a, b float32) bool {
+	return math.Abs(float64(a-b)) < 1e-3
+}
+
+func Test_Trans2Invert(t *testing.T) {
+	tr := Translation(3, -2).Mul(Rotation(30)).Mul(Shearing(0.2, 0))
+
+	inv, err := tr.Invert()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, v := tr.Eval(5, 7)
+	x, y := inv.Eval(u, v)
+	if !near(x, 5) || !near(y, 7) {
+		t.Errorf("Invert: got (%f, %f); want (5, 7)", x, y)
+	}
+
+	if _, err = Scaling(0, 1).Invert(); err == nil {
+		t.Error("Invert of a singular matrix succeeded")
+	}
+}
+
+func Test_Homography(t *testing.T) {
+	from := [4][2]float32{{0, 0}, {100, 0}, {100, 100}, {0, 100}}
+	to := [4][2]float32{{10, 5}, {90, 0}, {100, 100}, {0, 80}}
+
+	h, err := Homography(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range from {
+		u, v := h.Eval(from[i][0], from[i][1])
+		if !near(u, to[i][0]) || !near(v, to[i][1]) {
+			t.Errorf("point %d: got (%f, %f); want %v", i, u, v, to[i])
+		}
+	}
+
+	inv, err := h.Invert()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x, y := inv.Eval(to[2][0], to[2][1])
+	if !near(x, 100) || !near(y, 100) {
+		t.Errorf("Invert: got (%f, %f); want (100, 100)", x, y)
+	}
+}
+
+func Test_WarpIdentity(t *testing.T) {
+	src := image.NewGray(image.Rect(2, 3, 9, 8))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 7)
+	}
+
+	m, err := WarpAffine(src, Identity(), src.Bounds(), Bicubic, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for y := src.Rect.Min.Y; y < src.Rect.Max.Y; y++ {
+		for x := src.Rect.Min.X; x < src.Rect.Max.X; x++ {
+			want := color.RGBA64Model.Convert(src.At(x, y))
+			if m.At(x, y) != want {
+				t.Fatalf("pixel (%d, %d): got %v; want %v", x, y, m.At(x, y), want)
+			}
+		}
+	}
+}
+
+func Test_TransformBounds(t *testing.T) {
+	rect := image.Rect(0, 0, 40, 20)
+	tr := Centered(Rotation(90), rect)
+
+	if b := TransformBounds(&tr, rect); b != image.Rect(10, -10, 30, 30) {
+		t.Errorf("got %v; want (10,-10)-(30,30)", b)
+	}
+}
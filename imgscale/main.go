@@ -4,8 +4,10 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
+	croplib "github.com/jteeuwen/imgtools/imgcrop/lib"
 	scale "github.com/jteeuwen/imgtools/imgscale/lib"
 	"github.com/jteeuwen/imgtools/lib"
 	"image"
@@ -16,18 +18,218 @@ import (
 	"strings"
 )
 
+// config holds the parsed command line arguments.
+type config struct {
+	file    string
+	width   string
+	height  string
+	filter  scale.InterpolationFunction
+	border  scale.Border
+	fill    bool
+	gravity croplib.Gravity
+	heatmap string
+	carve   bool
+	mask    string
+	pyramid bool
+	sizes   string
+	out     string
+	sharpen scale.UnsharpMask
+	stream  bool
+	shrink  float64
+	full    bool
+	region  *scale.Region
+}
+
 func main() {
-	file, strwidth, strheight, filter := parseArgs()
+	cfg := parseArgs()
+
+	if cfg.stream {
+		stream(cfg)
+		return
+	}
+
+	if cfg.pyramid {
+		pyramid(load(cfg.file), cfg)
+		return
+	}
 
-	src := load(file)
+	if cfg.carve {
+		src := load(cfg.file)
+		width := realSize(src.Bounds().Dx(), cfg.width)
+		height := realSize(src.Bounds().Dy(), cfg.height)
+		save(carve(src, width, height, cfg))
+		return
+	}
 
-	width := realSize(src.Bounds().Dx(), strwidth)
-	height := realSize(src.Bounds().Dy(), strheight)
-	dst := scale.Resize(width, height, src, filter)
+	if cfg.region != nil {
+		save(region(load(cfg.file), cfg))
+		return
+	}
+
+	src, width, height := loadReduced(cfg)
+
+	if cfg.fill {
+		src = fill(src, width, height, cfg)
+	}
+
+	dst := scale.ResizeWith(width, height, src, cfg.filter, scale.Options{
+		Border:    cfg.border,
+		Sharpen:   cfg.sharpen,
+		PreShrink: float32(cfg.shrink),
+	})
 
 	save(dst)
 }
 
+// region resamples the selected region of the image. Percentages
+// for the target size are relative to the size of the region.
+func region(img image.Image, cfg *config) image.Image {
+	r := *cfg.region
+	width := realSize(int(math.Ceil(float64(r.Width))), cfg.width)
+	height := realSize(int(math.Ceil(float64(r.Height))), cfg.height)
+
+	dst, err := scale.ResizeRegion(width, height, img, r, cfg.filter, scale.Options{
+		Border:  cfg.border,
+		Sharpen: cfg.sharpen,
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	return dst
+}
+
+// stream resizes the image row by row, without decoding it as a whole.
+// This keeps memory use low for very large images.
+func stream(cfg *config) {
+	fd := open(cfg.file)
+	defer fd.Close()
+
+	src, _, err := lib.NewRowReader(fd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Decode image: %v\n", err)
+		os.Exit(1)
+	}
+
+	size := src.Size()
+	width := realSize(size.X, cfg.width)
+	height := realSize(size.Y, cfg.height)
+
+	out := bufio.NewWriter(os.Stdout)
+	err = scale.ResizeStream(out, "png", width, height, src, cfg.filter, scale.Options{
+		Border: cfg.border,
+	})
+
+	if err == nil {
+		err = out.Flush()
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+// pyramid writes all levels of an image pyramid, either as numbered
+// files or as a single atlas image on stdout.
+func pyramid(img image.Image, cfg *config) {
+	sizes := parseSizes(img.Bounds().Size(), cfg.sizes)
+	levels := scale.Pyramid(img, cfg.filter, sizes, scale.Options{
+		Border:    cfg.border,
+		Sharpen:   cfg.sharpen,
+		PreShrink: float32(cfg.shrink),
+	})
+
+	if len(cfg.out) == 0 {
+		atlas, _ := scale.Atlas(levels)
+		save(atlas)
+		return
+	}
+
+	for i, level := range levels {
+		saveFile(fmt.Sprintf(cfg.out, i), level)
+	}
+}
+
+// parseSizes parses a comma-separated list of sizes. Each size has the
+// form WxH, where both values are in pixels or percentages of the given
+// image size.
+func parseSizes(imgsize image.Point, str string) []image.Point {
+	if len(str) == 0 {
+		return nil
+	}
+
+	var sizes []image.Point
+	for _, v := range strings.Split(str, ",") {
+		wh := strings.Split(strings.TrimSpace(v), "x")
+		if len(wh) != 2 {
+			fmt.Fprintf(os.Stderr, "Invalid size %q; expected WxH\n", v)
+			os.Exit(1)
+		}
+
+		sizes = append(sizes, image.Pt(
+			int(realSize(imgsize.X, wh[0])),
+			int(realSize(imgsize.Y, wh[1])),
+		))
+	}
+
+	return sizes
+}
+
+// carve resizes the image by seam carving, using the optional mask.
+func carve(img image.Image, width, height uint, cfg *config) image.Image {
+	var mask image.Image
+	if len(cfg.mask) > 0 {
+		mask = load(cfg.mask)
+	}
+
+	dst, err := scale.Carve(width, height, img, mask)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	return dst
+}
+
+// fill crops the image to the aspect ratio of the target size, so it
+// covers the target entirely once resized. The gravity decides which
+// part of the image is kept. If requested, this writes the heatmap
+// of the smart crop scores to a separate file.
+func fill(img image.Image, width, height uint, cfg *config) image.Image {
+	if width == 0 || height == 0 {
+		fmt.Fprintf(os.Stderr, "The -fill option requires both -width and -height.\n")
+		os.Exit(1)
+	}
+
+	rect := croplib.AspectRect(img, int(width), int(height), cfg.gravity)
+
+	if len(cfg.heatmap) > 0 {
+		saveFile(cfg.heatmap, croplib.Heatmap(img, rect))
+	}
+
+	return croplib.Crop(img, rect)
+}
+
+// saveFile encodes the given image as PNG and saves it to a file.
+func saveFile(file string, img image.Image) {
+	fd, err := os.Create(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Create output file: %v\n", err)
+		os.Exit(1)
+	}
+
+	defer fd.Close()
+
+	err = lib.Encode(fd, "png", img, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Encode image: %v\n", err)
+		os.Exit(1)
+	}
+}
+
 // realSize returns the given size string as an integer.
 // If it carries a percentage sign, this will return
 // the appropriate size, relative to the given input image.
@@ -56,23 +258,26 @@ func realSize(imgsize int, str string) uint {
 	return uint(n)
 }
 
-// load loads the given image.
-func load(input string) image.Image {
-	var fd io.ReadCloser
-	var err error
-
+// open opens the given input file, or stdin if it is empty.
+func open(input string) io.ReadCloser {
 	if len(input) == 0 {
-		fd = os.Stdin
-	} else {
-		fd, err = os.Open(input)
-		defer fd.Close()
+		return os.Stdin
 	}
 
+	fd, err := os.Open(input)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Open input file: %v\n", err)
 		os.Exit(1)
 	}
 
+	return fd
+}
+
+// load loads the given image.
+func load(input string) image.Image {
+	fd := open(input)
+	defer fd.Close()
+
 	img, _, err := lib.Decode(fd)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Decode image: %v\n", err)
@@ -82,6 +287,68 @@ func load(input string) image.Image {
 	return img
 }
 
+// loadReduced loads the input image and determines the target size.
+// JPEG images are reduced while they are decoded, by the largest factor
+// which keeps them at least as large as the target size. Percentages
+// are relative to the original image size.
+func loadReduced(cfg *config) (image.Image, uint, uint) {
+	fd := open(cfg.file)
+	defer fd.Close()
+
+	var width, height uint
+	var sized bool
+
+	img, _, err := lib.DecodeReduced(fd, func(size image.Point) int {
+		width = realSize(size.X, cfg.width)
+		height = realSize(size.Y, cfg.height)
+		sized = true
+
+		if cfg.full {
+			return 1
+		}
+		return reduction(size, width, height)
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Decode image: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !sized {
+		width = realSize(img.Bounds().Dx(), cfg.width)
+		height = realSize(img.Bounds().Dy(), cfg.height)
+	}
+
+	return img, width, height
+}
+
+// reduction returns the largest JPEG reduction factor for which an image
+// of the given size is still at least as large as the target size.
+// A target width or height of 0 preserves the aspect ratio.
+func reduction(size image.Point, width, height uint) int {
+	tw, th := float64(width), float64(height)
+
+	switch {
+	case width == 0 && height == 0:
+		return 1
+	case width == 0:
+		tw = th * float64(size.X) / float64(size.Y)
+	case height == 0:
+		th = tw * float64(size.Y) / float64(size.X)
+	}
+
+	for f := 8; f > 1; f /= 2 {
+		w := (size.X + f - 1) / f
+		h := (size.Y + f - 1) / f
+
+		if float64(w) >= tw && float64(h) >= th {
+			return f
+		}
+	}
+
+	return 1
+}
+
 // save encodes the given image as PNG and saves it to stdout.
 func save(img image.Image) {
 	err := lib.Encode(os.Stdout, "png", img, "")
@@ -92,10 +359,27 @@ func save(img image.Image) {
 }
 
 // parseArgs parses command line arguments.
-func parseArgs() (string, string, string, scale.InterpolationFunction) {
+func parseArgs() *config {
+	var err error
+	var cfg config
+
 	width := flag.String("width", "0", "")
 	height := flag.String("height", "0", "")
 	filter := flag.String("filter", "", "")
+	border := flag.String("border", "replicate", "")
+	fill := flag.Bool("fill", false, "")
+	gravity := flag.String("gravity", "center", "")
+	heatmap := flag.String("heatmap", "", "")
+	carve := flag.Bool("carve", false, "")
+	mask := flag.String("mask", "", "")
+	pyramid := flag.Bool("pyramid", false, "")
+	sizes := flag.String("sizes", "", "")
+	out := flag.String("out", "", "")
+	unsharp := flag.String("unsharp", "", "")
+	stream := flag.Bool("stream", false, "")
+	shrink := flag.Float64("preshrink", 0, "")
+	full := flag.Bool("fulldecode", false, "")
+	region := flag.String("region", "", "")
 	version := flag.Bool("version", false, "")
 
 	flag.Usage = usage
@@ -106,41 +390,124 @@ func parseArgs() (string, string, string, scale.InterpolationFunction) {
 		os.Exit(0)
 	}
 
-	if len(*filter) == 0 {
+	if *carve && *fill {
+		fmt.Fprintf(os.Stderr, "The -carve and -fill options can not be combined.\n")
+		os.Exit(1)
+	}
+
+	if *stream && (*carve || *fill || *pyramid || len(*unsharp) > 0 || *shrink > 0) {
+		fmt.Fprintf(os.Stderr, "The -stream option can not be combined with -carve, -fill, -pyramid, -unsharp or -preshrink.\n")
+		os.Exit(1)
+	}
+
+	if len(*region) > 0 && (*carve || *fill || *pyramid || *stream || *shrink > 0) {
+		fmt.Fprintf(os.Stderr, "The -region option can not be combined with -carve, -fill, -pyramid, -stream or -preshrink.\n")
+		os.Exit(1)
+	}
+
+	if *pyramid && (*carve || *fill) {
+		fmt.Fprintf(os.Stderr, "The -pyramid option can not be combined with -carve or -fill.\n")
+		os.Exit(1)
+	}
+
+	if (len(*sizes) > 0 || len(*out) > 0) && !*pyramid {
+		fmt.Fprintf(os.Stderr, "The -sizes and -out options require -pyramid.\n")
+		os.Exit(1)
+	}
+
+	if len(*out) > 0 && !strings.Contains(*out, "%") {
+		fmt.Fprintf(os.Stderr, "The -out pattern must hold the level number, e.g.: mip_%%d.png\n")
+		os.Exit(1)
+	}
+
+	if len(*mask) > 0 && !*carve {
+		fmt.Fprintf(os.Stderr, "The -mask option requires -carve.\n")
+		os.Exit(1)
+	}
+
+	if len(*filter) == 0 && !*carve {
 		fmt.Fprintf(os.Stderr, "Missing interpolation algorithm.\n")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	var interp scale.InterpolationFunction
-	switch strings.ToLower(*filter) {
-	case "nearestneighbor":
-		interp = scale.NearestNeighbor
-	case "bilinear":
-		interp = scale.Bilinear
-	case "bicubic":
-		interp = scale.Bicubic
-	case "mitchellnetravali":
-		interp = scale.MitchellNetravali
-	case "lanczos2lut":
-		interp = scale.Lanczos2Lut
-	case "lanczos2":
-		interp = scale.Lanczos2
-	case "lanczos3lut":
-		interp = scale.Lanczos3Lut
-	case "lanczos3":
-		interp = scale.Lanczos3
+	if len(*filter) > 0 {
+		cfg.filter = scale.Lookup(*filter)
+		if cfg.filter == nil {
+			fmt.Fprintf(os.Stderr, "Unknown interpolation algorithm: %s\n", *filter)
+			os.Exit(1)
+		}
+	}
 
-	default:
-		fmt.Fprintf(os.Stderr, "Unknown interpolation algorithm: %s\n", *filter)
+	cfg.border, err = scale.ParseBorder(*border)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 
-	if flag.NArg() == 0 {
-		return "", *width, *height, interp
+	if len(*unsharp) > 0 {
+		if *carve {
+			fmt.Fprintf(os.Stderr, "The -unsharp option can not be combined with -carve.\n")
+			os.Exit(1)
+		}
+
+		cfg.sharpen, err = scale.ParseUnsharp(*unsharp)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
 	}
 
-	return flag.Args()[0], *width, *height, interp
+	if *shrink != 0 {
+		if *carve {
+			fmt.Fprintf(os.Stderr, "The -preshrink option can not be combined with -carve.\n")
+			os.Exit(1)
+		}
+
+		if *shrink < 1 {
+			fmt.Fprintf(os.Stderr, "The -preshrink value must be at least 1.\n")
+			os.Exit(1)
+		}
+	}
+
+	if len(*region) > 0 {
+		r, err := scale.ParseRegion(*region)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		cfg.region = &r
+	}
+
+	cfg.gravity, err = croplib.ParseGravity(*gravity)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	if len(*heatmap) > 0 && !*fill {
+		fmt.Fprintf(os.Stderr, "The -heatmap option requires -fill.\n")
+		os.Exit(1)
+	}
+
+	cfg.width = *width
+	cfg.height = *height
+	cfg.fill = *fill
+	cfg.heatmap = *heatmap
+	cfg.carve = *carve
+	cfg.mask = *mask
+	cfg.pyramid = *pyramid
+	cfg.sizes = *sizes
+	cfg.out = *out
+	cfg.stream = *stream
+	cfg.shrink = *shrink
+	cfg.full = *full
+
+	if flag.NArg() > 0 {
+		cfg.file = flag.Args()[0]
+	}
+
+	return &cfg
 }
 
 func usage() {
@@ -166,18 +533,113 @@ func usage() {
     Name of the interpolation algorithm to use.
     Available algorithms, in order of fastest to slowest, are:
 
-    * NearestNeighbor
-    * Bilinear
-    * Bicubic
-    * MitchellNetravali
-    * Lanczos2Lut
-    * Lanczos2
-    * Lanczos3Lut
-    * Lanczos3
+`, AppName, AppName)
 
+	for _, name := range scale.InterpolationNames() {
+		fmt.Printf("    * %s\n", name)
+	}
+
+	fmt.Printf(`
     Which of these gives the best results, depends on the input
     image and your use case.
 
-`, AppName, AppName)
-
+ -border <mode>
+    Defines how pixels outside of the image are sampled by the
+    interpolation filter. This affects the edges of the output.
+    Available modes are:
+
+    * replicate: Repeat the outermost pixels. This is the default.
+    * reflect: Mirror the image at its edges.
+    * wrap: Tile the image. This is useful for seamless textures.
+    * transparent: Use fully transparent pixels.
+    * constant:<color>: Use the given color. For example: constant:#ff9900
+
+ -unsharp <radius,amount[,threshold]>
+    Sharpen the image with an unsharp mask after resizing. This offsets
+    the softness which downscaling introduces.
+
+    * radius: Standard deviation of the blur, in pixels. E.g.: 0.8
+    * amount: Strength of the effect. 1 adds the full difference.
+    * threshold: Smallest difference (0-255) which is sharpened. This
+      keeps noise in flat areas from being amplified. Defaults to 0.
+
+    For example: -unsharp 0.8,0.6,2
+
+ -preshrink <N>
+    Speed up large reductions. If the image is reduced by more than N
+    times, it is first reduced to N times the target size by exact area
+    averaging. The filter then performs the remaining reduction. A value
+    of 2 or 3 gives nearly the same quality as the filter alone, at a
+    fraction of the cost. Disabled by default.
+
+ -fulldecode
+    Always decode JPEG images at their full size. By default, JPEG
+    images are reduced by 1/2, 1/4 or 1/8 while they are decoded, as
+    long as they remain at least as large as the target size. This is
+    much faster, but the filter then has less to work with.
+
+ -region <x,y,width,height>
+    Resize only the given region of the image, in source pixels. The
+    values may have fractions: the region need not be aligned to whole
+    pixels. Its edges map onto the edges of the output. Percentages for
+    -width and -height are relative to the size of the region. Parts of
+    the region outside of the image are sampled according to -border.
+
+    For example, to zoom in 8 times: -region 100.5,40.25,32,32 -width 256
+
+ -fill
+    Scale the image so it covers the target size entirely, cropping
+    whatever does not fit. This requires both -width and -height.
+
+ -gravity <name>
+    The part of the image which is kept by -fill. Defaults to center.
+    Known values are: center, north, northeast, east, southeast,
+    south, southwest, west, northwest and smart.
+
+    The smart gravity selects the most interesting area of the image,
+    based on edges, entropy, skin tones and saturated colors.
+
+ -heatmap <file>
+    Write the scores used by the smart gravity to the given PNG file.
+    Red marks skin tones, green marks edges and blue marks saturated
+    colors. The selected crop is outlined in white. This requires -fill.
+
+ -carve
+    Resize the image by seam carving, instead of scaling it. This
+    removes or duplicates paths of pixels with the least detail, which
+    changes the aspect ratio without cropping or distorting the image.
+    A width or height of 0 leaves that dimension unchanged. No -filter
+    is needed in this mode.
+
+ -mask <file>
+    An image of the same size as the input, which guides -carve.
+    Green areas are protected. Red areas are removed first.
+
+ -pyramid
+    Create an image pyramid from a single decode of the input. Each
+    level is resized from the previous one. By default, every level
+    halves the previous one down to 1x1 pixels, like a texture mipmap
+    chain. The -width and -height options are ignored in this mode.
+
+    Without -out, all levels are combined into a single atlas image,
+    which is written to stdout. The first level is placed on the left.
+    All others are stacked in a column to the right of it.
+
+ -sizes <list>
+    A comma-separated list of sizes for -pyramid, in decreasing order.
+    Each has the form WxH, in pixels or percentage. A width or height
+    of 0 preserves the aspect ratio. For example: 512x0,50%%x50%%,64x64
+
+ -out <pattern>
+    Write each level of -pyramid to a separate file. The pattern must
+    hold a printf verb for the level number. For example: mip_%%02d.png
+
+ -stream
+    Decode, resize and encode the image row by row. Memory use is then
+    bounded by the height of the filter kernel, rather than by the size
+    of the image. This allows resizing images which do not fit in memory.
+    The input must be a non-interlaced PNG or a PNM image. The output is
+    an 8 bit RGBA PNG image. The wrap border mode is not supported.
+
+`)
 }
@@ -0,0 +1,295 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	scale "github.com/jteeuwen/imgtools/imgscale/lib"
+	"github.com/jteeuwen/imgtools/lib"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// config holds the parsed command line arguments.
+type config struct {
+	file     string
+	widths   []int
+	formats  []string
+	options  string
+	filter   scale.InterpolationFunction
+	dir      string
+	name     string
+	prefix   string
+	manifest string
+	sizes    string
+	alt      string
+}
+
+func main() {
+	cfg := parseArgs()
+	img := load(cfg.file)
+
+	set, err := generate(img, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	switch cfg.manifest {
+	case "json":
+		err = writeJSON(os.Stdout, set)
+	default:
+		err = writeHTML(os.Stdout, set, cfg)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Write manifest: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// generate resizes the image to each of the configured widths and
+// writes each version in all configured formats. Widths larger than
+// the source image are skipped.
+func generate(img image.Image, cfg *config) (*imageSet, error) {
+	b := img.Bounds()
+	set := &imageSet{
+		Width:  b.Dx(),
+		Height: b.Dy(),
+	}
+
+	for _, width := range targetWidths(b.Dx(), cfg.widths) {
+		dst := img
+		if width != b.Dx() {
+			dst = scale.Resize(uint(width), 0, img, cfg.filter)
+		}
+
+		for _, format := range cfg.formats {
+			file := fmt.Sprintf("%s-%dw%s", cfg.name, width, lib.Extension(format))
+
+			size, err := save(filepath.Join(cfg.dir, file), format, dst, cfg.options)
+			if err != nil {
+				return nil, err
+			}
+
+			set.Images = append(set.Images, &imageFile{
+				File:   file,
+				Format: format,
+				Width:  dst.Bounds().Dx(),
+				Height: dst.Bounds().Dy(),
+				Bytes:  size,
+			})
+		}
+	}
+
+	return set, nil
+}
+
+// targetWidths returns the sorted, unique widths which do not exceed
+// the source width. If none of them do, it returns the source width.
+func targetWidths(source int, widths []int) []int {
+	var out []int
+
+	for _, w := range widths {
+		if w > source || (len(out) > 0 && out[len(out)-1] == w) {
+			continue
+		}
+		out = append(out, w)
+	}
+
+	if len(out) == 0 {
+		out = append(out, source)
+	}
+
+	return out
+}
+
+// save encodes the image in the given format and writes it to a file.
+// It returns the size of the file in bytes.
+func save(file, format string, img image.Image, options string) (int64, error) {
+	fd, err := os.Create(file)
+	if err != nil {
+		return 0, fmt.Errorf("Create output file: %v", err)
+	}
+
+	defer fd.Close()
+
+	err = lib.Encode(fd, format, img, options)
+	if err != nil {
+		return 0, fmt.Errorf("Encode %s: %v", file, err)
+	}
+
+	stat, err := fd.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("Stat %s: %v", file, err)
+	}
+
+	return stat.Size(), nil
+}
+
+// load loads the given image.
+func load(input string) image.Image {
+	var fd io.ReadCloser
+	var err error
+
+	if len(input) == 0 {
+		fd = os.Stdin
+	} else {
+		fd, err = os.Open(input)
+		defer fd.Close()
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Open input file: %v\n", err)
+		os.Exit(1)
+	}
+
+	img, _, err := lib.Decode(fd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Decode image: %v\n", err)
+		os.Exit(1)
+	}
+
+	return img
+}
+
+// parseArgs parses command line arguments.
+func parseArgs() *config {
+	var cfg config
+
+	widths := flag.String("widths", "320,640,1280,1920", "")
+	formats := flag.String("formats", "jpeg", "")
+	optstr := flag.String("options", "", "")
+	filter := flag.String("filter", "lanczos3", "")
+	dir := flag.String("dir", ".", "")
+	name := flag.String("name", "", "")
+	prefix := flag.String("prefix", "", "")
+	manifest := flag.String("manifest", "html", "")
+	sizes := flag.String("sizes", "100vw", "")
+	alt := flag.String("alt", "", "")
+	version := flag.Bool("version", false, "")
+
+	flag.Usage = usage
+	flag.Parse()
+
+	if *version {
+		fmt.Printf("%s\n", Version())
+		os.Exit(0)
+	}
+
+	for _, v := range strings.Split(*widths, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n <= 0 {
+			fmt.Fprintf(os.Stderr, "Invalid width: %q\n", v)
+			os.Exit(1)
+		}
+		cfg.widths = append(cfg.widths, n)
+	}
+
+	sort.Ints(cfg.widths)
+
+	for _, v := range strings.Split(*formats, ",") {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if !lib.Supported(v) {
+			fmt.Fprintf(os.Stderr, "Unsupported image format: %s; WebP is not supported, as there is no WebP encoder\n", v)
+			os.Exit(1)
+		}
+		cfg.formats = append(cfg.formats, v)
+	}
+
+	cfg.filter = scale.Lookup(*filter)
+	if cfg.filter == nil {
+		fmt.Fprintf(os.Stderr, "Unknown interpolation algorithm: %s\n", *filter)
+		os.Exit(1)
+	}
+
+	switch *manifest {
+	case "html", "json":
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown manifest type: %s\n", *manifest)
+		os.Exit(1)
+	}
+
+	if flag.NArg() > 0 {
+		cfg.file = flag.Args()[0]
+	}
+
+	cfg.name = *name
+	if len(cfg.name) == 0 {
+		if len(cfg.file) == 0 {
+			fmt.Fprintf(os.Stderr, "Missing -name for an image read from stdin.\n")
+			os.Exit(1)
+		}
+
+		base := filepath.Base(cfg.file)
+		cfg.name = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+
+	cfg.options = *optstr
+	cfg.dir = *dir
+	cfg.prefix = *prefix
+	cfg.manifest = *manifest
+	cfg.sizes = *sizes
+	cfg.alt = *alt
+	return &cfg
+}
+
+func usage() {
+	fmt.Printf(`Usage: %s [options] <path>
+   or: cat <path> | %s [options]
+
+ -version
+    Displays version information.
+
+ -widths <list>
+    Comma-separated list of target widths, in pixels. The height is
+    chosen to preserve the aspect ratio. Widths larger than the input
+    image are skipped. Defaults to 320,640,1280,1920.
+
+ -formats <list>
+    Comma-separated list of output formats. Available formats: %s
+    Defaults to jpeg. In the HTML snippet, the last format is used for
+    the fallback <img> element. All others become <source> elements.
+
+ -options <string>
+    A semi-colon-separated list of encoder options, which is passed to
+    the encoder of every format. See imgconv for details.
+
+ -filter <name>
+    Name of the interpolation algorithm to use. See imgscale for the
+    available algorithms. Defaults to lanczos3.
+
+ -dir <path>
+    Directory in which the images are written. Defaults to the
+    current directory.
+
+ -name <string>
+    Base name of the output files. Each file is named <name>-<width>w,
+    followed by the extension for its format. Defaults to the name of
+    the input file.
+
+ -prefix <string>
+    URL prefix for the file names in the HTML snippet.
+    For example: /static/img/
+
+ -manifest <type>
+    The type of manifest written to stdout. This is one of:
+
+    * html: A <picture> element with srcset attributes. This is the default.
+    * json: A list of all files, with their dimensions and byte sizes.
+
+ -sizes <string>
+    Value of the sizes attribute in the HTML snippet. Defaults to 100vw.
+
+ -alt <string>
+    Value of the alt attribute in the HTML snippet.
+
+`, AppName, AppName, strings.Join(lib.Formats(), ", "))
+}
@@ -0,0 +1,107 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+// imageSet describes all files generated from a source image.
+type imageSet struct {
+	Width  int          `json:"width"`
+	Height int          `json:"height"`
+	Images []*imageFile `json:"images"`
+}
+
+// imageFile describes a single generated file.
+type imageFile struct {
+	File   string `json:"file"`
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// Known MIME types, by image format name.
+var mimeTypes = map[string]string{
+	"gif":  "image/gif",
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"pnm":  "image/x-portable-anymap",
+}
+
+// writeJSON writes the image set as a JSON manifest.
+func writeJSON(w io.Writer, set *imageSet) error {
+	data, err := json.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// writeHTML writes the image set as an HTML <picture> element.
+// The last format is used for the fallback <img> element.
+func writeHTML(w io.Writer, set *imageSet, cfg *config) error {
+	last := len(cfg.formats) - 1
+	sizes := html.EscapeString(cfg.sizes)
+
+	_, err := fmt.Fprintf(w, "<picture>\n")
+	if err != nil {
+		return err
+	}
+
+	for _, format := range cfg.formats[:last] {
+		_, err = fmt.Fprintf(w, "  <source type=\"%s\" srcset=\"%s\" sizes=\"%s\">\n",
+			mimeType(format), srcset(set, format, cfg.prefix), sizes)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The fallback image is the largest one in the last format.
+	var largest *imageFile
+	for _, img := range set.Images {
+		if img.Format == cfg.formats[last] {
+			largest = img
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "  <img src=\"%s\" srcset=\"%s\" sizes=\"%s\" width=\"%d\" height=\"%d\" alt=\"%s\">\n",
+		html.EscapeString(cfg.prefix+largest.File), srcset(set, cfg.formats[last], cfg.prefix),
+		sizes, largest.Width, largest.Height, html.EscapeString(cfg.alt))
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "</picture>\n")
+	return err
+}
+
+// srcset returns the value of a srcset attribute,
+// which lists all images in the given format.
+func srcset(set *imageSet, format, prefix string) string {
+	var list []string
+
+	for _, img := range set.Images {
+		if img.Format == format {
+			list = append(list, fmt.Sprintf("%s %dw", prefix+img.File, img.Width))
+		}
+	}
+
+	return html.EscapeString(strings.Join(list, ", "))
+}
+
+// mimeType returns the MIME type for the given image format.
+func mimeType(format string) string {
+	if v, ok := mimeTypes[format]; ok {
+		return v
+	}
+	return "image/" + format
+}
@@ -0,0 +1,326 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	scale "github.com/jteeuwen/imgtools/imgscale/lib"
+	"github.com/jteeuwen/imgtools/lib"
+	"image"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// config holds the parsed command line arguments.
+type config struct {
+	file        string
+	filter      scale.InterpolationFunction
+	border      scale.Border
+	expand      bool
+	affine      scale.Trans2
+	perspective bool
+	from, to    [4][2]float32
+}
+
+func main() {
+	var dst image.Image
+	var err error
+
+	cfg := parseArgs()
+	src := load(cfg.file)
+	opt := scale.Options{Border: cfg.border}
+
+	if cfg.perspective {
+		var t scale.Trans3
+
+		t, err = scale.Homography(cfg.from, cfg.to)
+		if err == nil {
+			rect := pointBounds(cfg.to)
+			if cfg.expand {
+				rect = scale.TransformBounds(&t, src.Bounds())
+			}
+
+			dst, err = scale.WarpPerspective(src, t, rect, cfg.filter, opt)
+		}
+	} else {
+		t := scale.Centered(cfg.affine, src.Bounds())
+		dst, err = scale.WarpAffine(src, t, bounds(&t, src, cfg.expand), cfg.filter, opt)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warp image: %v\n", err)
+		os.Exit(1)
+	}
+
+	save(dst)
+}
+
+// bounds returns the bounds of the output image. These are either
+// the input bounds, or the bounds of the whole transformed image.
+func bounds(t scale.Transform, src image.Image, expand bool) image.Rectangle {
+	if expand {
+		return scale.TransformBounds(t, src.Bounds())
+	}
+	return src.Bounds()
+}
+
+// pointBounds returns the smallest rectangle holding the given points.
+func pointBounds(p [4][2]float32) image.Rectangle {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+
+	for _, v := range p {
+		minX = math.Min(minX, float64(v[0]))
+		minY = math.Min(minY, float64(v[1]))
+		maxX = math.Max(maxX, float64(v[0]))
+		maxY = math.Max(maxY, float64(v[1]))
+	}
+
+	return image.Rect(int(math.Floor(minX)), int(math.Floor(minY)),
+		int(math.Ceil(maxX)), int(math.Ceil(maxY)))
+}
+
+// load loads the given image.
+func load(input string) image.Image {
+	var fd io.ReadCloser
+	var err error
+
+	if len(input) == 0 {
+		fd = os.Stdin
+	} else {
+		fd, err = os.Open(input)
+		defer fd.Close()
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Open input file: %v\n", err)
+		os.Exit(1)
+	}
+
+	img, _, err := lib.Decode(fd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Decode image: %v\n", err)
+		os.Exit(1)
+	}
+
+	return img
+}
+
+// save encodes the given image as PNG and saves it to stdout.
+func save(img image.Image) {
+	err := lib.Encode(os.Stdout, "png", img, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Encode image: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// parseFloats parses a comma-separated list of exactly n numbers.
+func parseFloats(value string, n int) ([]float32, error) {
+	list := strings.Split(value, ",")
+	if len(list) != n {
+		return nil, fmt.Errorf("Invalid value %q; expected %d comma-separated numbers", value, n)
+	}
+
+	out := make([]float32, n)
+	for i, v := range list {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value %q: %v", value, err)
+		}
+		out[i] = float32(f)
+	}
+
+	return out, nil
+}
+
+// parsePoints parses a comma-separated list of four x,y pairs.
+func parsePoints(value string) ([4][2]float32, error) {
+	var p [4][2]float32
+
+	list, err := parseFloats(value, 8)
+	if err != nil {
+		return p, err
+	}
+
+	for i := range p {
+		p[i][0] = list[i*2]
+		p[i][1] = list[i*2+1]
+	}
+
+	return p, nil
+}
+
+// parseAffine builds the affine transformation from the given arguments.
+// The operations are applied in the order: matrix, flip, shear, rotate
+// and translate.
+func parseAffine(matrix, flip, shear string, rotate float64, translate string) (scale.Trans2, error) {
+	t := scale.Identity()
+
+	if len(matrix) > 0 {
+		list, err := parseFloats(matrix, 6)
+		if err != nil {
+			return t, err
+		}
+		copy(t[:], list)
+	}
+
+	switch strings.ToLower(flip) {
+	case "":
+	case "h":
+		t = scale.Scaling(-1, 1).Mul(t)
+	case "v":
+		t = scale.Scaling(1, -1).Mul(t)
+	case "hv", "vh":
+		t = scale.Scaling(-1, -1).Mul(t)
+	default:
+		return t, fmt.Errorf("Invalid flip value %q; expected h, v or hv", flip)
+	}
+
+	if len(shear) > 0 {
+		list, err := parseFloats(shear, 2)
+		if err != nil {
+			return t, err
+		}
+		t = scale.Shearing(list[0], list[1]).Mul(t)
+	}
+
+	if rotate != 0 {
+		t = scale.Rotation(rotate).Mul(t)
+	}
+
+	if len(translate) > 0 {
+		list, err := parseFloats(translate, 2)
+		if err != nil {
+			return t, err
+		}
+		t = scale.Translation(list[0], list[1]).Mul(t)
+	}
+
+	return t, nil
+}
+
+// parseArgs parses command line arguments.
+func parseArgs() *config {
+	var err error
+	var cfg config
+
+	filter := flag.String("filter", "bicubic", "")
+	border := flag.String("border", "transparent", "")
+	rotate := flag.Float64("rotate", 0, "")
+	shear := flag.String("shear", "", "")
+	translate := flag.String("translate", "", "")
+	flip := flag.String("flip", "", "")
+	matrix := flag.String("matrix", "", "")
+	from := flag.String("from", "", "")
+	to := flag.String("to", "", "")
+	expand := flag.Bool("expand", false, "")
+	version := flag.Bool("version", false, "")
+
+	flag.Usage = usage
+	flag.Parse()
+
+	if *version {
+		fmt.Printf("%s\n", Version())
+		os.Exit(0)
+	}
+
+	cfg.filter = scale.Lookup(*filter)
+	if cfg.filter == nil {
+		fmt.Fprintf(os.Stderr, "Unknown interpolation algorithm: %s\n", *filter)
+		os.Exit(1)
+	}
+
+	cfg.border, err = scale.ParseBorder(*border)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	cfg.expand = *expand
+
+	if len(*from) > 0 || len(*to) > 0 {
+		if len(*matrix) > 0 || len(*flip) > 0 || len(*shear) > 0 || *rotate != 0 || len(*translate) > 0 {
+			fmt.Fprintf(os.Stderr, "Perspective transformations can not be combined with affine ones.\n")
+			os.Exit(1)
+		}
+
+		cfg.perspective = true
+
+		cfg.from, err = parsePoints(*from)
+		if err == nil {
+			cfg.to, err = parsePoints(*to)
+		}
+	} else {
+		cfg.affine, err = parseAffine(*matrix, *flip, *shear, *rotate, *translate)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	if flag.NArg() > 0 {
+		cfg.file = flag.Args()[0]
+	}
+
+	return &cfg
+}
+
+func usage() {
+	fmt.Printf(`Usage: %s [options] <path>
+   or: cat <path> | %s [options]
+
+ -version
+    Displays version information.
+
+ -filter <name>
+    Name of the interpolation algorithm to use. This accepts the
+    same names as imgscale. Defaults to Bicubic.
+
+ -border <mode>
+    Defines how areas outside of the input image are filled.
+    This accepts the same modes as imgscale. Defaults to transparent.
+
+ -expand
+    Enlarge the output image, so it holds the whole transformed
+    image. By default, the output has the size of the input and
+    anything which falls outside of it is cropped.
+
+ -rotate <degrees>
+    Rotate the image clockwise around its center.
+
+ -shear <kx,ky>
+    Shear the image along the x- and y-axis around its center.
+
+ -translate <dx,dy>
+    Move the image by the given number of pixels.
+
+ -flip <h|v|hv>
+    Mirror the image horizontally, vertically or both.
+
+ -matrix <a,b,c,d,e,f>
+    Apply an arbitrary affine transformation. A point (x, y) relative
+    to the image center is mapped onto (a*x + b*y + c, d*x + e*y + f).
+
+    The affine operations are applied in the order: matrix, flip,
+    shear, rotate and translate.
+
+ -from <x0,y0,x1,y1,x2,y2,x3,y3>
+ -to <x0,y0,x1,y1,x2,y2,x3,y3>
+    Apply a perspective transformation which maps the four points
+    in -from onto the four points in -to. These can not be combined
+    with the affine operations. The output covers the area spanned
+    by the -to points, unless -expand is given.
+
+    For example, to map a photographed page onto a 600x800 rectangle:
+
+        %s -from 112,58,640,92,702,830,60,790 -to 0,0,600,0,600,800,0,800 page.jpg
+
+`, AppName, AppName, AppName)
+}
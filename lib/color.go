@@ -0,0 +1,53 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package lib
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// ParseColor parses a color from a hexadecimal string in one of the
+// following forms: #rgb, #rgba, #rrggbb or #rrggbbaa. The leading `#`
+// is optional. The name "transparent" yields a fully transparent color.
+func ParseColor(value string) (color.NRGBA, error) {
+	value = strings.TrimSpace(value)
+
+	if strings.EqualFold(value, "transparent") {
+		return color.NRGBA{}, nil
+	}
+
+	hex := strings.TrimPrefix(value, "#")
+
+	// Expand the short forms.
+	if len(hex) == 3 || len(hex) == 4 {
+		long := make([]byte, 0, len(hex)*2)
+		for i := 0; i < len(hex); i++ {
+			long = append(long, hex[i], hex[i])
+		}
+		hex = string(long)
+	}
+
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+
+	if len(hex) != 8 {
+		return color.NRGBA{}, fmt.Errorf("Invalid color value: %q", value)
+	}
+
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("Invalid color value: %q", value)
+	}
+
+	return color.NRGBA{
+		R: uint8(n >> 24),
+		G: uint8(n >> 16),
+		B: uint8(n >> 8),
+		A: uint8(n),
+	}, nil
+}
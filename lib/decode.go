@@ -4,6 +4,9 @@
 package lib
 
 import (
+	"bufio"
+	"bytes"
+	"github.com/jteeuwen/imgtools/lib/jpeg"
 	"image"
 	"io"
 )
@@ -13,3 +16,21 @@ import (
 func Decode(r io.Reader) (image.Image, string, error) {
 	return image.Decode(r)
 }
+
+// DecodeReduced decodes an image from the given stream, like Decode.
+// JPEG images are reduced in size while they are decoded, which is much
+// faster than decoding them in full. The reduce function is called with
+// the full size of the image, and returns the factor by which to reduce
+// it: 1, 2, 4 or 8. Other formats are always decoded in full, and reduce
+// is not called for them.
+func DecodeReduced(r io.Reader, reduce func(size image.Point) int) (image.Image, string, error) {
+	br := bufio.NewReader(r)
+
+	magic, _ := br.Peek(2)
+	if bytes.Equal(magic, []byte("\xff\xd8")) {
+		img, err := jpeg.DecodeScaled(br, reduce)
+		return img, "jpeg", err
+	}
+
+	return image.Decode(br)
+}
@@ -0,0 +1,93 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// ExifOrientation returns the EXIF orientation value (1-8) which is stored
+// in the given JPEG file data. It returns 1 (upright) if the data is not a
+// JPEG file, or does not carry an orientation tag.
+func ExifOrientation(data []byte) int {
+	const (
+		markerSOI  = 0xd8
+		markerAPP1 = 0xe1
+		markerSOS  = 0xda
+		tagOrient  = 0x0112
+	)
+
+	if len(data) < 4 || data[0] != 0xff || data[1] != markerSOI {
+		return 1
+	}
+
+	// Walk the JPEG segments up to the image data, looking
+	// for an APP1 segment which holds EXIF data.
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return 1
+		}
+
+		marker := data[i+1]
+		if marker == markerSOS {
+			return 1
+		}
+
+		size := int(data[i+2])<<8 | int(data[i+3])
+		if size < 2 || i+2+size > len(data) {
+			return 1
+		}
+
+		seg := data[i+4 : i+2+size]
+		i += 2 + size
+
+		if marker != markerAPP1 || !bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			continue
+		}
+
+		tiff := seg[6:]
+		if len(tiff) < 8 {
+			return 1
+		}
+
+		var order binary.ByteOrder
+		switch string(tiff[:2]) {
+		case "II":
+			order = binary.LittleEndian
+		case "MM":
+			order = binary.BigEndian
+		default:
+			return 1
+		}
+
+		// Scan the entries of the first image file directory.
+		ifd := int(order.Uint32(tiff[4:]))
+		if ifd < 8 || ifd+2 > len(tiff) {
+			return 1
+		}
+
+		count := int(order.Uint16(tiff[ifd:]))
+		for n := 0; n < count; n++ {
+			entry := ifd + 2 + n*12
+			if entry+12 > len(tiff) {
+				return 1
+			}
+
+			if order.Uint16(tiff[entry:]) != tagOrient {
+				continue
+			}
+
+			v := int(order.Uint16(tiff[entry+8:]))
+			if v < 1 || v > 8 {
+				return 1
+			}
+			return v
+		}
+
+		return 1
+	}
+
+	return 1
+}
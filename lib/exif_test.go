@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// exifJPEG returns the start of a JPEG file, with an APP1 segment which
+// holds the given TIFF data.
+func exifJPEG(tiff []byte) []byte {
+	seg := append([]byte("Exif\x00\x00"), tiff...)
+	size := len(seg) + 2
+
+	data := []byte{0xff, 0xd8}
+	data = append(data, 0xff, 0xe0, 0x00, 0x04, 0x00, 0x00) // An empty APP0 segment.
+	data = append(data, 0xff, 0xe1, byte(size>>8), byte(size))
+	data = append(data, seg...)
+	return append(data, 0xff, 0xda, 0x00, 0x02)
+}
+
+// exifTIFF returns TIFF data with a single directory, which holds a
+// SHORT entry with the given value for each tag.
+func exifTIFF(order binary.ByteOrder, tags []uint16, value uint16) []byte {
+	tiff := make([]byte, 8+2+12*len(tags)+4)
+
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], uint16(len(tags)))
+
+	for i, tag := range tags {
+		entry := tiff[10+12*i:]
+		order.PutUint16(entry, tag)
+		order.PutUint16(entry[2:], 3) // SHORT
+		order.PutUint32(entry[4:], 1)
+		order.PutUint16(entry[8:], value)
+	}
+
+	return tiff
+}
+
+func Test_ExifOrientation(t *testing.T) {
+	le, be := binary.LittleEndian, binary.BigEndian
+	orient := []uint16{0x010f, 0x0112}
+
+	// Corrupt variants of valid data.
+	badOffset := exifTIFF(le, orient, 6)
+	le.PutUint32(badOffset[4:], 0xfffffff0)
+
+	shortOffset := exifTIFF(be, orient, 6)
+	be.PutUint32(shortOffset[4:], 4)
+
+	badCount := exifTIFF(le, orient[:1], 6)
+	le.PutUint16(badCount[8:], 0xffff)
+
+	truncated := exifJPEG(exifTIFF(be, orient, 6))
+	truncated = truncated[:len(truncated)-20]
+
+	badSize := exifJPEG(exifTIFF(le, orient, 6))
+	badSize[8], badSize[9] = 0xff, 0xff
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"little endian", exifJPEG(exifTIFF(le, orient, 6)), 6},
+		{"big endian", exifJPEG(exifTIFF(be, orient, 8)), 8},
+		{"only tag", exifJPEG(exifTIFF(be, orient[1:], 3)), 3},
+		{"missing tag", exifJPEG(exifTIFF(le, orient[:1], 6)), 1},
+		{"no entries", exifJPEG(exifTIFF(le, nil, 6)), 1},
+		{"zero", exifJPEG(exifTIFF(le, orient, 0)), 1},
+		{"too large", exifJPEG(exifTIFF(be, orient, 9)), 1},
+		{"bad byte order", exifJPEG(append([]byte("XX"), exifTIFF(le, orient, 6)[2:]...)), 1},
+		{"short TIFF header", exifJPEG([]byte("II*\x00")), 1},
+		{"IFD offset out of range", exifJPEG(badOffset), 1},
+		{"IFD offset in header", exifJPEG(shortOffset), 1},
+		{"entry count out of range", exifJPEG(badCount), 1},
+		{"truncated APP1", truncated, 1},
+		{"segment size out of range", badSize, 1},
+		{"not a JPEG", []byte("\x89PNG\r\n\x1a\n"), 1},
+		{"empty", nil, 1},
+		{"only SOI", []byte{0xff, 0xd8}, 1},
+		{"bad marker", []byte{0xff, 0xd8, 0x00, 0xe1, 0x00, 0x02}, 1},
+	}
+
+	for _, tt := range tests {
+		if got := ExifOrientation(tt.data); got != tt.want {
+			t.Errorf("%s: orientation is %d; want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_ExifOrientationTruncations(t *testing.T) {
+	// No prefix of valid data may cause a panic. Prefixes which end
+	// before the APP1 segment does yield the default.
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		data := exifJPEG(exifTIFF(order, []uint16{0x010f, 0x0112}, 6))
+		end := len(data) - 4 // Start of the SOS marker.
+
+		for n := range data {
+			want := 1
+			if n >= end {
+				want = 6
+			}
+
+			if got := ExifOrientation(data[:n]); got != want {
+				t.Fatalf("%v: prefix of %d bytes has orientation %d; want %d", order, n, got, want)
+			}
+		}
+	}
+}
@@ -11,12 +11,37 @@ import (
 // List of registered file extensions.
 var extensions []string
 
+// List of registered file extensions, by image format name.
+var formatExtensions = make(map[string][]string)
+
 // RegisterExtensions registers the given file extensions.
 // The extensions are expected to be in the format: ".ext"
 func RegisterExtensions(ext ...string) {
 	extensions = append(extensions, ext...)
 }
 
+// RegisterFormatExtensions registers the given file extensions for an
+// image format, as RegisterExtensions does. The first extension is the
+// preferred one for new files of that format.
+func RegisterFormatExtensions(format string, ext ...string) {
+	RegisterExtensions(ext...)
+
+	format = strings.ToLower(format)
+	formatExtensions[format] = append(formatExtensions[format], ext...)
+}
+
+// Extension returns the preferred file extension for the given image format.
+// If the format has no registered extensions, this returns "." + format.
+func Extension(format string) string {
+	format = strings.ToLower(format)
+
+	if list := formatExtensions[format]; len(list) > 0 {
+		return list[0]
+	}
+
+	return "." + format
+}
+
 // ValidFile returns true if the given file path has a known
 // file extension.
 func ValidFile(file string) bool {
@@ -0,0 +1,33 @@
+package lib
+
+import "testing"
+
+func Test_Extension(t *testing.T) {
+	RegisterExtensions(".test1")
+	RegisterFormatExtensions("Test2", ".t2", ".test2")
+
+	tests := []struct {
+		format, want string
+	}{
+		{"png", ".png"},
+		{"jpeg", ".jpg"},
+		{"test2", ".t2"},
+		{"test1", ".test1"},
+	}
+
+	for _, tt := range tests {
+		if got := Extension(tt.format); got != tt.want {
+			t.Errorf("Extension(%q) is %q; want %q", tt.format, got, tt.want)
+		}
+	}
+
+	for _, file := range []string{"a.PNG", "b.jpeg", "c.test1", "d.test2"} {
+		if !ValidFile(file) {
+			t.Errorf("ValidFile(%q) is false", file)
+		}
+	}
+
+	if ValidFile("e.test3") {
+		t.Errorf("ValidFile(%q) is true", "e.test3")
+	}
+}
@@ -9,7 +9,7 @@ import (
 )
 
 func init() {
-	RegisterExtensions(".gif")
+	RegisterFormatExtensions("gif", ".gif")
 	RegisterEncoder("gif", func(w io.Writer, m image.Image, options OptionSet) error {
 		return nil
 	}, "quantizer")
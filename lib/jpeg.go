@@ -10,7 +10,7 @@ import (
 )
 
 func init() {
-	RegisterExtensions(".jpg", ".jpeg")
+	RegisterFormatExtensions("jpeg", ".jpg", ".jpeg")
 	RegisterEncoder("jpeg", func(w io.Writer, m image.Image, options OptionSet) error {
 		return jpeg.Encode(w, m, &jpeg.Options{
 			Quality: options.Int("quality", jpeg.DefaultQuality),
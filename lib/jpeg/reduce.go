@@ -0,0 +1,90 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package jpeg
+
+import "math"
+
+// idctBasis holds the basis functions of the n-point inverse DCTs used for
+// reduced decoding, for n = 1, 2 and 4. Entry [x*n+u] is the weight of
+// frequency u for output sample x.
+var idctBasis [5][]float32
+
+func init() {
+	for _, n := range []int{1, 2, 4} {
+		basis := make([]float32, n*n)
+		for x := 0; x < n; x++ {
+			for u := 0; u < n; u++ {
+				c := 0.5
+				if u == 0 {
+					c = 0.5 / math.Sqrt2
+				}
+				basis[x*n+u] = float32(c * math.Cos(float64((2*x+1)*u)*math.Pi/float64(2*n)))
+			}
+		}
+		idctBasis[n] = basis
+	}
+}
+
+// idctReduced performs an n-point inverse DCT on the n x n lowest frequency
+// coefficients of b, for n = 1, 2 or 4, and stores the result in the top
+// left of b. Every output sample approximates the average of the
+// corresponding 8/n x 8/n samples of the full inverse DCT. Like idct, it
+// produces values which still need to be level shifted by +128.
+//
+// With the coefficients scaled as in the 8-point DCT, the n-point inverse
+// is the same sum over fewer, differently spaced cosines:
+//
+//	f(x,y) = 1/4 Σ_u Σ_v C(u) C(v) F(v,u) cos((2x+1)uπ/2n) cos((2y+1)vπ/2n)
+func idctReduced(b *block, n int) {
+	if n == 1 {
+		// Only the DC coefficient remains: the block average.
+		b[0] = (b[0] + 4) >> 3
+		return
+	}
+
+	basis := idctBasis[n]
+
+	// Transform the rows first, then the columns.
+	var tmp [16]float32
+	for v := 0; v < n; v++ {
+		for x := 0; x < n; x++ {
+			var sum float32
+			for u := 0; u < n; u++ {
+				sum += basis[x*n+u] * float32(b[v*8+u])
+			}
+			tmp[v*n+x] = sum
+		}
+	}
+
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			var sum float32
+			for v := 0; v < n; v++ {
+				sum += basis[y*n+v] * tmp[v*n+x]
+			}
+			b[y*8+x] = int32(math.Floor(float64(sum) + 0.5))
+		}
+	}
+}
+
+// reduction rounds the given reduction factor down to one
+// which is supported by reduced decoding: 1, 2, 4 or 8.
+func reduction(f int) int {
+	switch {
+	case f >= 8:
+		return 8
+	case f >= 4:
+		return 4
+	case f >= 2:
+		return 2
+	}
+	return 1
+}
+
+// reduced returns the size of a full image dimension,
+// after reduction. Partial pixels are rounded up.
+func (d *decoder) reduced(size int) int {
+	f := 8 / d.blockPx
+	return (size + f - 1) / f
+}
@@ -0,0 +1,112 @@
+package jpeg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	stdjpeg "image/jpeg"
+	"math"
+	"testing"
+)
+
+// testJPEG encodes a smooth test pattern of the given size, either in
+// grayscale or in color. Color images use 4:2:0 chroma subsampling.
+func testJPEG(t *testing.T, w, h int, gray bool) []byte {
+	var img image.Image
+	if gray {
+		m := image.NewGray(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				m.SetGray(x, y, color.Gray{uint8(128 + 100*math.Sin(float64(x+y)/9))})
+			}
+		}
+		img = m
+	} else {
+		m := image.NewRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				m.SetRGBA(x, y, color.RGBA{uint8(x * 255 / w), uint8(y * 255 / h), uint8(128 + 100*math.Sin(float64(x)/25)), 255})
+			}
+		}
+		img = m
+	}
+
+	var buf bytes.Buffer
+	if err := stdjpeg.Encode(&buf, img, &stdjpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// boxAverage returns the mean RGB value of the f x f block
+// at block position (bx, by) of img, clipped to its bounds.
+func boxAverage(img image.Image, f, bx, by int) [3]float64 {
+	var sum [3]float64
+	var n float64
+
+	b := img.Bounds()
+	for y := by * f; y < (by+1)*f && y < b.Dy(); y++ {
+		for x := bx * f; x < (bx+1)*f && x < b.Dx(); x++ {
+			r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			sum[0] += float64(r >> 8)
+			sum[1] += float64(g >> 8)
+			sum[2] += float64(bl >> 8)
+			n++
+		}
+	}
+
+	for i := range sum {
+		sum[i] /= n
+	}
+	return sum
+}
+
+func Test_DecodeScaled(t *testing.T) {
+	for _, gray := range []bool{false, true} {
+		data := testJPEG(t, 203, 117, gray)
+
+		full, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, f := range []int{1, 2, 4, 8} {
+			var size image.Point
+			img, err := DecodeScaled(bytes.NewReader(data), func(s image.Point) int {
+				size = s
+				return f
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if size != image.Pt(203, 117) {
+				t.Fatalf("reduce called with %v; want 203x117", size)
+			}
+
+			want := image.Pt((203+f-1)/f, (117+f-1)/f)
+			if got := img.Bounds().Size(); got != want {
+				t.Fatalf("gray=%v, 1/%d: size %v; want %v", gray, f, got, want)
+			}
+
+			// Each pixel should approximate the average of the
+			// block it replaces in the fully decoded image.
+			var mse float64
+			for y := 0; y < want.Y; y++ {
+				for x := 0; x < want.X; x++ {
+					r, g, b, _ := img.At(x, y).RGBA()
+					avg := boxAverage(full, f, x, y)
+					for i, v := range []uint32{r >> 8, g >> 8, b >> 8} {
+						d := float64(v) - avg[i]
+						mse += d * d
+					}
+				}
+			}
+
+			mse /= float64(3 * want.X * want.Y)
+			if psnr := 10 * math.Log10(255*255/mse); psnr < 28 {
+				t.Fatalf("gray=%v, 1/%d: PSNR against box average is %.1f dB; want >= 28", gray, f, psnr)
+			}
+		}
+	}
+}
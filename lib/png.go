@@ -10,7 +10,7 @@ import (
 )
 
 func init() {
-	RegisterExtensions(".png")
+	RegisterFormatExtensions("png", ".png")
 	RegisterEncoder("png", func(w io.Writer, m image.Image, options OptionSet) error {
 		return png.Encode(w, m)
 	})
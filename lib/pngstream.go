@@ -0,0 +1,574 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package lib
+
+import (
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"image"
+	"io"
+)
+
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+// PNG color types.
+const (
+	pngGray      = 0
+	pngRGB       = 2
+	pngPaletted  = 3
+	pngGrayAlpha = 4
+	pngRGBA      = 6
+)
+
+// PNG row filters.
+const (
+	pngFilterNone = iota
+	pngFilterSub
+	pngFilterUp
+	pngFilterAverage
+	pngFilterPaeth
+)
+
+// pngRowReader decodes non-interlaced PNG images row by row.
+type pngRowReader struct {
+	size      image.Point
+	depth     int
+	colorType int
+	palette   [][4]uint32 // Non-premultiplied, 16 bit.
+	trns      []uint32    // Transparent color for gray and RGB images.
+	bpp       int         // Bytes per complete pixel, at least 1.
+	cur, prev []uint8     // Current and previous raw rows, with filter byte.
+	chunk     pngChunks
+	zr        io.ReadCloser
+}
+
+func newPNGRowReader(r io.Reader) (*pngRowReader, error) {
+	var sig [8]byte
+	if _, err := io.ReadFull(r, sig[:]); err != nil {
+		return nil, err
+	}
+
+	p := new(pngRowReader)
+	p.chunk.r = r
+
+	for {
+		typ, err := p.chunk.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if typ == "IDAT" {
+			break
+		}
+
+		data := make([]byte, p.chunk.remaining)
+		if _, err = io.ReadFull(&p.chunk, data); err != nil {
+			return nil, err
+		}
+
+		if err = p.chunk.verify(); err != nil {
+			return nil, err
+		}
+
+		switch typ {
+		case "IHDR":
+			err = p.parseHeader(data)
+		case "PLTE":
+			err = p.parsePalette(data)
+		case "tRNS":
+			err = p.parseTransparency(data)
+		case "IEND":
+			err = fmt.Errorf("PNG image has no image data")
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.size.X == 0 {
+		return nil, fmt.Errorf("PNG image has no header")
+	}
+
+	if p.colorType == pngPaletted && len(p.palette) == 0 {
+		return nil, fmt.Errorf("PNG image has no palette")
+	}
+
+	zr, err := zlib.NewReader(&p.chunk)
+	if err != nil {
+		return nil, err
+	}
+
+	rowSize := 1 + (p.size.X*p.channels()*p.depth+7)/8
+	p.cur = make([]uint8, rowSize)
+	p.prev = make([]uint8, rowSize)
+	p.zr = zr
+	return p, nil
+}
+
+func (p *pngRowReader) parseHeader(data []byte) error {
+	if len(data) != 13 {
+		return fmt.Errorf("Invalid PNG header")
+	}
+
+	p.size.X = int(binary.BigEndian.Uint32(data[0:]))
+	p.size.Y = int(binary.BigEndian.Uint32(data[4:]))
+	p.depth = int(data[8])
+	p.colorType = int(data[9])
+
+	if p.size.X <= 0 || p.size.Y <= 0 {
+		return fmt.Errorf("Invalid PNG image size: %dx%d", p.size.X, p.size.Y)
+	}
+
+	if data[12] != 0 {
+		return fmt.Errorf("Interlaced PNG images can not be decoded row by row")
+	}
+
+	valid := false
+	switch p.colorType {
+	case pngGray:
+		valid = p.depth == 1 || p.depth == 2 || p.depth == 4 || p.depth == 8 || p.depth == 16
+	case pngPaletted:
+		valid = p.depth == 1 || p.depth == 2 || p.depth == 4 || p.depth == 8
+	case pngRGB, pngGrayAlpha, pngRGBA:
+		valid = p.depth == 8 || p.depth == 16
+	}
+
+	if !valid {
+		return fmt.Errorf("Unsupported PNG color type %d with bit depth %d", p.colorType, p.depth)
+	}
+
+	p.bpp = (p.channels()*p.depth + 7) / 8
+	return nil
+}
+
+func (p *pngRowReader) parsePalette(data []byte) error {
+	if len(data)%3 != 0 || len(data) > 3*256 {
+		return fmt.Errorf("Invalid PNG palette")
+	}
+
+	p.palette = make([][4]uint32, len(data)/3)
+	for i := range p.palette {
+		p.palette[i] = [4]uint32{
+			uint32(data[3*i+0]) * 0x101,
+			uint32(data[3*i+1]) * 0x101,
+			uint32(data[3*i+2]) * 0x101,
+			0xffff,
+		}
+	}
+
+	return nil
+}
+
+func (p *pngRowReader) parseTransparency(data []byte) error {
+	switch p.colorType {
+	case pngPaletted:
+		if len(data) > len(p.palette) {
+			return fmt.Errorf("Invalid PNG transparency")
+		}
+
+		for i, a := range data {
+			p.palette[i][3] = uint32(a) * 0x101
+		}
+
+	case pngGray, pngRGB:
+		if len(data) != 2*p.channels() {
+			return fmt.Errorf("Invalid PNG transparency")
+		}
+
+		p.trns = make([]uint32, p.channels())
+		for i := range p.trns {
+			p.trns[i] = uint32(binary.BigEndian.Uint16(data[2*i:]))
+		}
+	}
+
+	return nil
+}
+
+// channels returns the number of samples per pixel.
+func (p *pngRowReader) channels() int {
+	switch p.colorType {
+	case pngRGB:
+		return 3
+	case pngGrayAlpha:
+		return 2
+	case pngRGBA:
+		return 4
+	}
+	return 1
+}
+
+func (p *pngRowReader) Size() image.Point { return p.size }
+
+func (p *pngRowReader) ReadRow(pix []uint8) error {
+	p.cur, p.prev = p.prev, p.cur
+
+	if _, err := io.ReadFull(p.zr, p.cur); err != nil {
+		return fmt.Errorf("Decode PNG row: %v", err)
+	}
+
+	if err := unfilter(p.cur[1:], p.prev[1:], p.cur[0], p.bpp); err != nil {
+		return err
+	}
+
+	row := p.cur[1:]
+	for x := 0; x < p.size.X; x++ {
+		r, g, b, a := p.pixel(row, x)
+		premultiply(pix[8*x:], r, g, b, a)
+	}
+
+	return nil
+}
+
+// pixel returns the non-premultiplied 16 bit color of pixel x in row.
+func (p *pngRowReader) pixel(row []uint8, x int) (r, g, b, a uint32) {
+	if p.colorType == pngPaletted {
+		i := p.raw(row, x)
+		if int(i) >= len(p.palette) {
+			return 0, 0, 0, 0
+		}
+		c := p.palette[i]
+		return c[0], c[1], c[2], c[3]
+	}
+
+	var s [4]uint32
+
+	n := p.channels()
+	for c := 0; c < n; c++ {
+		s[c] = p.sample(row, x*n+c)
+	}
+
+	switch p.colorType {
+	case pngGray:
+		r, g, b, a = s[0], s[0], s[0], 0xffff
+	case pngRGB:
+		r, g, b, a = s[0], s[1], s[2], 0xffff
+	case pngGrayAlpha:
+		return s[0], s[0], s[0], s[1]
+	case pngRGBA:
+		return s[0], s[1], s[2], s[3]
+	}
+
+	if p.trns != nil && p.raw(row, x*n) == p.trns[0] &&
+		(n == 1 || (p.raw(row, x*n+1) == p.trns[1] && p.raw(row, x*n+2) == p.trns[2])) {
+		a = 0
+	}
+
+	return
+}
+
+// raw returns sample i of the row, as stored.
+func (p *pngRowReader) raw(row []uint8, i int) uint32 {
+	switch p.depth {
+	case 16:
+		return uint32(row[2*i])<<8 | uint32(row[2*i+1])
+	case 8:
+		return uint32(row[i])
+	}
+
+	perByte := 8 / p.depth
+	shift := uint(8 - p.depth*(i%perByte+1))
+	return uint32(row[i/perByte]>>shift) & (1<<uint(p.depth) - 1)
+}
+
+// sample returns sample i of the row, scaled to 16 bits.
+func (p *pngRowReader) sample(row []uint8, i int) uint32 {
+	v := p.raw(row, i)
+	if p.depth == 16 {
+		return v
+	}
+	return v * 0xffff / (1<<uint(p.depth) - 1)
+}
+
+// unfilter reverses the PNG filter of the given row.
+func unfilter(cur, prev []uint8, filter uint8, bpp int) error {
+	switch filter {
+	case pngFilterNone:
+	case pngFilterSub:
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += cur[i-bpp]
+		}
+	case pngFilterUp:
+		for i := range cur {
+			cur[i] += prev[i]
+		}
+	case pngFilterAverage:
+		for i := range cur {
+			var left int
+			if i >= bpp {
+				left = int(cur[i-bpp])
+			}
+			cur[i] += uint8((left + int(prev[i])) / 2)
+		}
+	case pngFilterPaeth:
+		for i := range cur {
+			var a, c uint8
+			if i >= bpp {
+				a, c = cur[i-bpp], prev[i-bpp]
+			}
+			cur[i] += paeth(a, prev[i], c)
+		}
+	default:
+		return fmt.Errorf("Invalid PNG filter type: %d", filter)
+	}
+
+	return nil
+}
+
+func paeth(a, b, c uint8) uint8 {
+	p := int(a) + int(b) - int(c)
+	pa := abs(p - int(a))
+	pb := abs(p - int(b))
+	pc := abs(p - int(c))
+
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// pngChunks reads the contents of consecutive PNG chunks. As a reader,
+// it yields the data of consecutive IDAT chunks as one stream.
+type pngChunks struct {
+	r         io.Reader
+	typ       string
+	remaining uint32
+	crc       hash.Hash32
+}
+
+// next reads the header of the next chunk and returns its type.
+func (c *pngChunks) next() (string, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return "", fmt.Errorf("Read PNG chunk: %v", err)
+	}
+
+	c.remaining = binary.BigEndian.Uint32(hdr[:4])
+	c.typ = string(hdr[4:])
+	c.crc = crc32.NewIEEE()
+	c.crc.Write(hdr[4:])
+	return c.typ, nil
+}
+
+// verify reads the checksum at the end of the current chunk.
+func (c *pngChunks) verify() error {
+	var sum [4]byte
+	if _, err := io.ReadFull(c.r, sum[:]); err != nil {
+		return fmt.Errorf("Read PNG chunk: %v", err)
+	}
+
+	if binary.BigEndian.Uint32(sum[:]) != c.crc.Sum32() {
+		return fmt.Errorf("Invalid checksum in PNG chunk %s", c.typ)
+	}
+
+	return nil
+}
+
+func (c *pngChunks) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.typ != "IDAT" {
+			return 0, io.EOF
+		}
+
+		if err := c.verify(); err != nil {
+			return 0, err
+		}
+
+		typ, err := c.next()
+		if err != nil {
+			return 0, err
+		}
+
+		if typ != "IDAT" {
+			return 0, io.EOF
+		}
+	}
+
+	if uint32(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+
+	n, err := c.r.Read(p)
+	c.crc.Write(p[:n])
+	c.remaining -= uint32(n)
+	return n, err
+}
+
+// pngRowWriter encodes PNG images row by row, as 8 bit RGBA.
+type pngRowWriter struct {
+	w         io.Writer
+	size      image.Point
+	cur, prev []uint8
+	filtered  [5][]uint8
+	chunks    *pngChunkWriter
+	zw        *zlib.Writer
+}
+
+func newPNGRowWriter(w io.Writer, size image.Point) (*pngRowWriter, error) {
+	if _, err := io.WriteString(w, pngHeader); err != nil {
+		return nil, err
+	}
+
+	var hdr [13]byte
+	binary.BigEndian.PutUint32(hdr[0:], uint32(size.X))
+	binary.BigEndian.PutUint32(hdr[4:], uint32(size.Y))
+	hdr[8] = 8
+	hdr[9] = pngRGBA
+
+	if err := writePNGChunk(w, "IHDR", hdr[:]); err != nil {
+		return nil, err
+	}
+
+	p := &pngRowWriter{
+		w:      w,
+		size:   size,
+		cur:    make([]uint8, 4*size.X),
+		prev:   make([]uint8, 4*size.X),
+		chunks: &pngChunkWriter{w: w},
+	}
+
+	for i := range p.filtered {
+		p.filtered[i] = make([]uint8, 1+4*size.X)
+		p.filtered[i][0] = uint8(i)
+	}
+
+	p.zw = zlib.NewWriter(p.chunks)
+	return p, nil
+}
+
+func (p *pngRowWriter) WriteRow(pix []uint8) error {
+	p.cur, p.prev = p.prev, p.cur
+
+	for x := 0; x < p.size.X; x++ {
+		r, g, b, a := unpremultiply(pix[8*x:])
+		p.cur[4*x+0] = uint8(r >> 8)
+		p.cur[4*x+1] = uint8(g >> 8)
+		p.cur[4*x+2] = uint8(b >> 8)
+		p.cur[4*x+3] = uint8(a >> 8)
+	}
+
+	_, err := p.zw.Write(p.filter())
+	return err
+}
+
+// filter returns the current row with the filter that yields the
+// smallest sum of absolute differences. This is the heuristic
+// suggested by the PNG specification.
+func (p *pngRowWriter) filter() []uint8 {
+	const bpp = 4
+	cur, prev := p.cur, p.prev
+
+	best, bestSum := 0, -1
+	for f := range p.filtered {
+		out := p.filtered[f][1:]
+		sum := 0
+
+		for i := range cur {
+			var a, c uint8
+			if i >= bpp {
+				a, c = cur[i-bpp], prev[i-bpp]
+			}
+
+			switch f {
+			case pngFilterNone:
+				out[i] = cur[i]
+			case pngFilterSub:
+				out[i] = cur[i] - a
+			case pngFilterUp:
+				out[i] = cur[i] - prev[i]
+			case pngFilterAverage:
+				out[i] = cur[i] - uint8((int(a)+int(prev[i]))/2)
+			case pngFilterPaeth:
+				out[i] = cur[i] - paeth(a, prev[i], c)
+			}
+
+			sum += abs(int(int8(out[i])))
+		}
+
+		if bestSum < 0 || sum < bestSum {
+			best, bestSum = f, sum
+		}
+	}
+
+	return p.filtered[best]
+}
+
+func (p *pngRowWriter) Close() error {
+	if err := p.zw.Close(); err != nil {
+		return err
+	}
+
+	if err := p.chunks.flush(); err != nil {
+		return err
+	}
+
+	return writePNGChunk(p.w, "IEND", nil)
+}
+
+// pngChunkWriter buffers compressed image data and
+// writes it as a sequence of IDAT chunks.
+type pngChunkWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+// pngChunkSize is the size of the IDAT chunks written by pngChunkWriter.
+const pngChunkSize = 1 << 16
+
+func (c *pngChunkWriter) Write(p []byte) (int, error) {
+	c.buf = append(c.buf, p...)
+
+	for len(c.buf) >= pngChunkSize {
+		if err := writePNGChunk(c.w, "IDAT", c.buf[:pngChunkSize]); err != nil {
+			return 0, err
+		}
+		c.buf = append(c.buf[:0], c.buf[pngChunkSize:]...)
+	}
+
+	return len(p), nil
+}
+
+func (c *pngChunkWriter) flush() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+
+	err := writePNGChunk(c.w, "IDAT", c.buf)
+	c.buf = c.buf[:0]
+	return err
+}
+
+func writePNGChunk(w io.Writer, typ string, data []byte) error {
+	var hdr [8]byte
+	binary.BigEndian.PutUint32(hdr[:4], uint32(len(data)))
+	copy(hdr[4:], typ)
+
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[4:])
+	crc.Write(data)
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+
+	for _, b := range [][]byte{hdr[:], data, sum[:]} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -12,7 +12,7 @@ import (
 )
 
 func init() {
-	RegisterExtensions(".pnm", ".pbm", ".pgm", ".ppm")
+	RegisterFormatExtensions("pnm", ".pnm", ".pbm", ".pgm", ".ppm")
 	RegisterEncoder("pnm", encodePNM, "format")
 }
 
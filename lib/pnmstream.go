@@ -0,0 +1,232 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+)
+
+// pnmRowReader decodes PNM images (P1 to P6) row by row.
+type pnmRowReader struct {
+	r      *bufio.Reader
+	magic  byte
+	size   image.Point
+	maxval uint32
+	buf    []uint8
+}
+
+func newPNMRowReader(r *bufio.Reader) (*pnmRowReader, error) {
+	var magic [2]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, err
+	}
+
+	p := &pnmRowReader{r: r, magic: magic[1], maxval: 1}
+
+	fields := []*int{&p.size.X, &p.size.Y}
+	for _, f := range fields {
+		v, err := p.token()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid PNM header: %v", err)
+		}
+		*f = v
+	}
+
+	if p.size.X <= 0 || p.size.Y <= 0 {
+		return nil, fmt.Errorf("Invalid PNM image size: %dx%d", p.size.X, p.size.Y)
+	}
+
+	if p.magic != '1' && p.magic != '4' {
+		v, err := p.token()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid PNM header: %v", err)
+		}
+
+		if v <= 0 || v > 0xffff {
+			return nil, fmt.Errorf("Invalid PNM maximum value: %d", v)
+		}
+
+		p.maxval = uint32(v)
+	}
+
+	// Binary formats have exactly one whitespace character between
+	// the header and the image data. The last token consumed it.
+	switch p.magic {
+	case '4':
+		p.buf = make([]uint8, (p.size.X+7)/8)
+	case '5':
+		p.buf = make([]uint8, p.size.X*p.sampleSize())
+	case '6':
+		p.buf = make([]uint8, 3*p.size.X*p.sampleSize())
+	}
+
+	return p, nil
+}
+
+// token reads the next decimal number from the
+// header or ASCII data, skipping any comments.
+func (p *pnmRowReader) token() (int, error) {
+	var digits []byte
+
+	for {
+		c, err := p.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(digits) > 0 {
+				break
+			}
+			return 0, err
+		}
+
+		switch {
+		case c == '#' && len(digits) == 0:
+			if _, err := p.r.ReadString('\n'); err != nil {
+				return 0, err
+			}
+			continue
+		case c >= '0' && c <= '9':
+			digits = append(digits, c)
+			// Plain bitmaps may omit the whitespace between values.
+			if p.magic == '1' && p.size.Y > 0 {
+				return int(c - '0'), nil
+			}
+			continue
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			if len(digits) == 0 {
+				continue
+			}
+		default:
+			return 0, fmt.Errorf("Unexpected character %q", c)
+		}
+
+		break
+	}
+
+	return strconv.Atoi(string(digits))
+}
+
+// sampleSize returns the number of bytes per binary sample.
+func (p *pnmRowReader) sampleSize() int {
+	if p.maxval > 0xff {
+		return 2
+	}
+	return 1
+}
+
+func (p *pnmRowReader) Size() image.Point { return p.size }
+
+func (p *pnmRowReader) ReadRow(pix []uint8) error {
+	if p.magic >= '4' {
+		if _, err := io.ReadFull(p.r, p.buf); err != nil {
+			return fmt.Errorf("Decode PNM row: %v", err)
+		}
+	}
+
+	for x := 0; x < p.size.X; x++ {
+		var r, g, b uint32
+
+		switch p.magic {
+		case '1', '4':
+			var bit uint32
+			if p.magic == '4' {
+				bit = uint32(p.buf[x/8]>>uint(7-x%8)) & 1
+			} else {
+				v, err := p.token()
+				if err != nil {
+					return fmt.Errorf("Decode PNM row: %v", err)
+				}
+				bit = uint32(v) & 1
+			}
+
+			// A set bit is black.
+			r = (1 - bit) * 0xffff
+			g, b = r, r
+
+		case '2', '5':
+			v, err := p.sample(x)
+			if err != nil {
+				return err
+			}
+			r, g, b = v, v, v
+
+		case '3', '6':
+			var err error
+			if r, err = p.sample(3 * x); err == nil {
+				if g, err = p.sample(3*x + 1); err == nil {
+					b, err = p.sample(3*x + 2)
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		premultiply(pix[8*x:], r, g, b, 0xffff)
+	}
+
+	return nil
+}
+
+// sample returns sample i of the current row, scaled to 16 bits.
+// For ASCII formats, samples are read in order and i is ignored.
+func (p *pnmRowReader) sample(i int) (uint32, error) {
+	var v uint32
+
+	switch {
+	case p.magic <= '3':
+		n, err := p.token()
+		if err != nil {
+			return 0, fmt.Errorf("Decode PNM row: %v", err)
+		}
+		v = uint32(n)
+	case p.sampleSize() == 2:
+		v = uint32(p.buf[2*i])<<8 | uint32(p.buf[2*i+1])
+	default:
+		v = uint32(p.buf[i])
+	}
+
+	if v > p.maxval {
+		v = p.maxval
+	}
+
+	return v * 0xffff / p.maxval, nil
+}
+
+// pnmRowWriter encodes binary PNM pixmaps (P6) row by row.
+// Pixmaps have no alpha channel, so colors are written as
+// if composited onto black.
+type pnmRowWriter struct {
+	w    io.Writer
+	size image.Point
+	buf  []uint8
+}
+
+func newPNMRowWriter(w io.Writer, size image.Point) (*pnmRowWriter, error) {
+	_, err := fmt.Fprintf(w, "P6\n%d %d\n255\n", size.X, size.Y)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pnmRowWriter{
+		w:    w,
+		size: size,
+		buf:  make([]uint8, 3*size.X),
+	}, nil
+}
+
+func (p *pnmRowWriter) WriteRow(pix []uint8) error {
+	for x := 0; x < p.size.X; x++ {
+		p.buf[3*x+0] = pix[8*x+0]
+		p.buf[3*x+1] = pix[8*x+2]
+		p.buf[3*x+2] = pix[8*x+4]
+	}
+
+	_, err := p.w.Write(p.buf)
+	return err
+}
+
+func (p *pnmRowWriter) Close() error { return nil }
@@ -0,0 +1,127 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+)
+
+// RowReader decodes an image one row at a time. This allows images
+// to be processed which are too large to be held in memory at once.
+//
+// Rows are delivered top to bottom in the pixel layout of image.RGBA64:
+// premultiplied red, green, blue and alpha, as big-endian 16 bit values.
+type RowReader interface {
+	// Size returns the dimensions of the image.
+	Size() image.Point
+
+	// ReadRow decodes the next row into pix, which must hold
+	// at least 8 * Size().X bytes.
+	ReadRow(pix []uint8) error
+}
+
+// RowWriter encodes an image one row at a time. Rows are
+// expected in the pixel layout described for RowReader.
+type RowWriter interface {
+	// WriteRow encodes the next row.
+	WriteRow(pix []uint8) error
+
+	// Close finishes the image. It does not close the underlying writer.
+	Close() error
+}
+
+// NewRowReader returns a RowReader for the image in r. The format is
+// detected from the image header. It returns the reader and the name of
+// the format. Supported formats are png and pnm.
+func NewRowReader(r io.Reader) (RowReader, string, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(8)
+	if err != nil && len(magic) < 2 {
+		return nil, "", fmt.Errorf("Read image header: %v", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte(pngHeader)):
+		rr, err := newPNGRowReader(br)
+		return rr, "png", err
+
+	case magic[0] == 'P' && magic[1] >= '1' && magic[1] <= '6':
+		rr, err := newPNMRowReader(br)
+		return rr, "pnm", err
+	}
+
+	return nil, "", fmt.Errorf("Unsupported image format for row-wise decoding; expected png or pnm")
+}
+
+// NewRowWriter returns a RowWriter which encodes an image of the
+// given size in the given format. Supported formats are png and pnm.
+// PNG images are written as 8 bit RGBA. PNM images are written as
+// binary pixmaps (P6), which have no alpha channel.
+func NewRowWriter(w io.Writer, format string, size image.Point) (RowWriter, error) {
+	if size.X <= 0 || size.Y <= 0 {
+		return nil, fmt.Errorf("Invalid image size: %v", size)
+	}
+
+	switch strings.ToLower(format) {
+	case "png":
+		return newPNGRowWriter(w, size)
+	case "pnm":
+		return newPNMRowWriter(w, size)
+	}
+
+	return nil, fmt.Errorf("Unsupported image format for row-wise encoding: %s", format)
+}
+
+// premultiply stores the given non-premultiplied 16 bit color
+// at the start of pix, in the layout of image.RGBA64.
+func premultiply(pix []uint8, r, g, b, a uint32) {
+	if a != 0xffff {
+		r = r * a / 0xffff
+		g = g * a / 0xffff
+		b = b * a / 0xffff
+	}
+
+	pix[0] = uint8(r >> 8)
+	pix[1] = uint8(r)
+	pix[2] = uint8(g >> 8)
+	pix[3] = uint8(g)
+	pix[4] = uint8(b >> 8)
+	pix[5] = uint8(b)
+	pix[6] = uint8(a >> 8)
+	pix[7] = uint8(a)
+}
+
+// unpremultiply returns the non-premultiplied 16 bit color
+// at the start of pix, which is in the layout of image.RGBA64.
+func unpremultiply(pix []uint8) (r, g, b, a uint32) {
+	r = uint32(pix[0])<<8 | uint32(pix[1])
+	g = uint32(pix[2])<<8 | uint32(pix[3])
+	b = uint32(pix[4])<<8 | uint32(pix[5])
+	a = uint32(pix[6])<<8 | uint32(pix[7])
+
+	if a == 0 {
+		return 0, 0, 0, 0
+	}
+
+	if a != 0xffff {
+		r = min16(r * 0xffff / a)
+		g = min16(g * 0xffff / a)
+		b = min16(b * 0xffff / a)
+	}
+
+	return
+}
+
+func min16(v uint32) uint32 {
+	if v > 0xffff {
+		return 0xffff
+	}
+	return v
+}
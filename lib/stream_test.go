@@ -0,0 +1,153 @@
+package lib
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// decodeRows decodes an image with a RowReader.
+func decodeRows(t *testing.T, data []byte) image.Image {
+	rr, _, err := NewRowReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := rr.Size()
+	img := image.NewRGBA64(image.Rect(0, 0, size.X, size.Y))
+	for y := 0; y < size.Y; y++ {
+		if err = rr.ReadRow(img.Pix[y*img.Stride:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return img
+}
+
+func sameImage(t *testing.T, name string, a, b image.Image) {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		t.Fatalf("%s: size %v; want %v", name, a.Bounds().Size(), b.Bounds().Size())
+	}
+
+	ab, bb := a.Bounds(), b.Bounds()
+	for y := 0; y < ab.Dy(); y++ {
+		for x := 0; x < ab.Dx(); x++ {
+			r0, g0, b0, a0 := a.At(ab.Min.X+x, ab.Min.Y+y).RGBA()
+			r1, g1, b1, a1 := b.At(bb.Min.X+x, bb.Min.Y+y).RGBA()
+			if r0 != r1 || g0 != g1 || b0 != b1 || a0 != a1 {
+				t.Fatalf("%s: pixel (%d,%d) is %v; want %v", name, x, y,
+					[4]uint32{r0, g0, b0, a0}, [4]uint32{r1, g1, b1, a1})
+			}
+		}
+	}
+}
+
+func Test_PNGRowReader(t *testing.T) {
+	r := image.Rect(0, 0, 13, 7)
+	pal := color.Palette{color.Black, color.NRGBA{0xff, 0, 0, 0x80}, color.White}
+
+	images := map[string]image.Image{
+		"gray":     image.NewGray(r),
+		"gray16":   image.NewGray16(r),
+		"nrgba":    image.NewNRGBA(r),
+		"nrgba64":  image.NewNRGBA64(r),
+		"paletted": image.NewPaletted(r, pal),
+	}
+
+	for name, img := range images {
+		for y := 0; y < r.Dy(); y++ {
+			for x := 0; x < r.Dx(); x++ {
+				v := uint8(x*19 + y*31)
+				switch m := img.(type) {
+				case *image.Paletted:
+					m.SetColorIndex(x, y, v%3)
+				case *image.NRGBA:
+					m.Set(x, y, color.NRGBA{v, v / 2, 255 - v, v | 0x0f})
+				case *image.NRGBA64:
+					m.Set(x, y, color.NRGBA64{uint16(v) * 250, 0x1234, 0xfedc, uint16(v) * 200})
+				default:
+					img.(interface {
+						Set(int, int, color.Color)
+					}).Set(x, y, color.Gray{v})
+				}
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+
+		want, err := png.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sameImage(t, name, decodeRows(t, buf.Bytes()), want)
+	}
+}
+
+func Test_PNGRowWriter(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 9, 5))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 13)
+	}
+	for i := 3; i < len(src.Pix); i += 4 {
+		src.Pix[i] = 0xff
+	}
+
+	var buf bytes.Buffer
+	rw, err := NewRowWriter(&buf, "png", src.Bounds().Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := decodeRows(t, encodePNG(t, src)).(*image.RGBA64)
+	for y := 0; y < 5; y++ {
+		if err = rw.WriteRow(rows.Pix[y*rows.Stride:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = rw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sameImage(t, "png", out, src)
+}
+
+func Test_PNMRowReader(t *testing.T) {
+	data := []byte("P3\n# comment\n2 2\n255\n255 0 0  0 255 0\n0 0 255  10 20 30\n")
+	img := decodeRows(t, data)
+
+	want := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	want.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	want.Set(1, 0, color.RGBA{0, 255, 0, 255})
+	want.Set(0, 1, color.RGBA{0, 0, 255, 255})
+	want.Set(1, 1, color.RGBA{10, 20, 30, 255})
+	sameImage(t, "P3", img, want)
+
+	data = []byte("P4\n10 1\n\xc0\x40")
+	img = decodeRows(t, data)
+	if c := color.GrayModel.Convert(img.At(1, 0)).(color.Gray); c.Y != 0 {
+		t.Errorf("P4: pixel 1 is %v; want black", c)
+	}
+	if c := color.GrayModel.Convert(img.At(2, 0)).(color.Gray); c.Y != 0xff {
+		t.Errorf("P4: pixel 2 is %v; want white", c)
+	}
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
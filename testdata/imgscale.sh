@@ -10,6 +10,14 @@ cat $IMG | imgscale -width 200% -filter lanczos2lut       >  "scale_up_lanczos2l
 cat $IMG | imgscale -width 200% -filter lanczos2          >  "scale_up_lanczos2.png"
 cat $IMG | imgscale -width 200% -filter lanczos3lut       >  "scale_up_lanczos3lut.png"
 cat $IMG | imgscale -width 200% -filter lanczos3          >  "scale_up_lanczos3.png"
+cat $IMG | imgscale -width 200% -filter box               >  "scale_up_box.png"
+cat $IMG | imgscale -width 200% -filter hermite           >  "scale_up_hermite.png"
+cat $IMG | imgscale -width 200% -filter catmullrom        >  "scale_up_catmullrom.png"
+cat $IMG | imgscale -width 200% -filter gaussian          >  "scale_up_gaussian.png"
+cat $IMG | imgscale -width 200% -filter hann              >  "scale_up_hann.png"
+cat $IMG | imgscale -width 200% -filter hamming           >  "scale_up_hamming.png"
+cat $IMG | imgscale -width 200% -filter blackman          >  "scale_up_blackman.png"
+cat $IMG | imgscale -width 200% -filter lanczos4          >  "scale_up_lanczos4.png"
 
 cat $IMG | imgscale -width 50% -filter NearestNeighbor   >  "scale_down_nearestneighbor.png"
 cat $IMG | imgscale -width 50% -filter bilinear          >  "scale_down_bilinear.png"
@@ -19,4 +27,14 @@ cat $IMG | imgscale -width 50% -filter lanczos2lut       >  "scale_down_lanczos2
 cat $IMG | imgscale -width 50% -filter lanczos2          >  "scale_down_lanczos2.png"
 cat $IMG | imgscale -width 50% -filter lanczos3lut       >  "scale_down_lanczos3lut.png"
 cat $IMG | imgscale -width 50% -filter lanczos3          >  "scale_down_lanczos3.png"
+cat $IMG | imgscale -width 50% -filter box               >  "scale_down_box.png"
+cat $IMG | imgscale -width 50% -filter hermite           >  "scale_down_hermite.png"
+cat $IMG | imgscale -width 50% -filter catmullrom        >  "scale_down_catmullrom.png"
+cat $IMG | imgscale -width 50% -filter gaussian          >  "scale_down_gaussian.png"
+cat $IMG | imgscale -width 50% -filter hann              >  "scale_down_hann.png"
+cat $IMG | imgscale -width 50% -filter hamming           >  "scale_down_hamming.png"
+cat $IMG | imgscale -width 50% -filter blackman          >  "scale_down_blackman.png"
+cat $IMG | imgscale -width 50% -filter lanczos4          >  "scale_down_lanczos4.png"
 
+cat $IMG | imgscale -width 50% -carve  >  "carve_down.png"
+cat $IMG | imgscale -width 150% -carve >  "carve_up.png"